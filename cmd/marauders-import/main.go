@@ -0,0 +1,54 @@
+// Command marauders-import loads tracks recorded by other apps into the location history
+// of a device, from GPX files or the Records.json of a Google Takeout archive.
+//
+//	marauders-import -device 42 -format takeout -dry-run Records.json
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/tracks"
+)
+
+func main() {
+	deviceID := flag.Int("device", 0, "id of the device to import the tracks into")
+	format := flag.String("format", tracks.FormatGPX, "format of the files, gpx or takeout")
+	dryRun := flag.Bool("dry-run", false, "report what would be imported without saving anything")
+	flag.Parse()
+	if *deviceID == 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	device, err := db.GetDeviceByID(*deviceID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range flag.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		importer := tracks.Importer{
+			Device: device,
+			DryRun: *dryRun,
+			Progress: func(report tracks.ImportReport) {
+				log.Printf("%s: read %d, imported %d, duplicates %d, invalid %d",
+					path, report.Read, report.Imported, report.Duplicates, report.Invalid)
+			},
+		}
+		report, err := importer.Import(*format, file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		verb := "imported"
+		if report.DryRun {
+			verb = "would import"
+		}
+		log.Printf("%s: done, %s %d of %d snapshots", path, verb, report.Imported, report.Read)
+	}
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Statuses of a checkout.
+const (
+	// CheckoutOpen is waiting for the user to pay with the provider.
+	CheckoutOpen = "open"
+	// CheckoutPaid has been paid and recorded in the ledger.
+	CheckoutPaid = "paid"
+	// CheckoutFailed was declined or abandoned, and can still be paid later.
+	CheckoutFailed = "failed"
+)
+
+// ErrCheckoutMismatch is returned when a payment provider reports a different amount for
+// a checkout than the one it was opened for.
+var ErrCheckoutMismatch = errors.New("payment does not match the amount of its checkout")
+
+// Checkout is a top up of a user's account that is paid with a payment provider. The id
+// is the one given to it by the provider, and the URL is where the user pays it.
+type Checkout struct {
+	Provider      string
+	ID            string
+	User          string
+	Amount        int64
+	Currency      string
+	URL           string
+	Status        string
+	Created       string
+	Settled       sql.NullString
+	TransactionID sql.NullInt64 `db:"transaction_id"`
+}
+
+// Money returns the amount the checkout tops up.
+func (c Checkout) Money() Money {
+	return Money{Amount: c.Amount, Currency: c.Currency}
+}
+
+// NewCheckout saves a checkout the provider opened for this user to pay the amount.
+func (u *User) NewCheckout(provider, id, url string, amount Money) (Checkout, error) {
+	if amount.Amount <= 0 || !validCurrency(amount.Currency) {
+		return Checkout{}, fmt.Errorf("checkout for user<%s> should be of a positive amount in a currency", u.Username)
+	}
+	_, err := db.Exec(`
+	INSERT INTO payment_checkouts (provider, id, user, amount, currency, url) VALUES (?, ?, ?, ?, ?, ?)`,
+		provider, id, u.Username, amount.Amount, amount.Currency, url)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("could not save checkout<%s> of user<%s>: %v", id, u.Username, err)
+	}
+	return GetCheckout(provider, id)
+}
+
+// Checkouts returns the latest checkouts of this user, latest first.
+func (u *User) Checkouts(lim int) (checkouts []Checkout, err error) {
+	err = db.Select(&checkouts, "SELECT * FROM payment_checkouts WHERE user = ? ORDER BY created DESC LIMIT ?",
+		u.Username, lim)
+	if err != nil {
+		return checkouts, fmt.Errorf("could not get checkouts of user<%s>: %v", u.Username, err)
+	}
+	return checkouts, nil
+}
+
+// GetCheckout returns the checkout the provider opened with the passed id. The returned
+// error wraps sql.ErrNoRows if there is none.
+func GetCheckout(provider, id string) (checkout Checkout, err error) {
+	err = db.Get(&checkout, "SELECT * FROM payment_checkouts WHERE provider = ? AND id = ?", provider, id)
+	if err != nil {
+		return checkout, fmt.Errorf("could not get checkout<%s> of provider<%s>: %w", id, provider, err)
+	}
+	return checkout, nil
+}
+
+// PayCheckout records the payment of the checkout in the ledger of its user. The payment
+// is posted with an idempotency key of its own, so a checkout is only ever paid once no
+// matter how many times its provider reports it.
+func PayCheckout(provider, id string, paid Money) (Checkout, error) {
+	checkout, err := GetCheckout(provider, id)
+	if err != nil {
+		return checkout, err
+	}
+	if paid != checkout.Money() {
+		return checkout, ErrCheckoutMismatch
+	}
+	user := &User{Username: checkout.User}
+	payment, err := user.RecordPayment(paid, "top up with "+provider,
+		fmt.Sprintf("checkout:%s:%s", provider, id))
+	if err != nil {
+		return checkout, err
+	}
+	_, err = db.Exec(`
+	UPDATE payment_checkouts SET status = ?, settled = COALESCE(settled, CURRENT_TIMESTAMP), transaction_id = ?
+	WHERE provider = ? AND id = ?`, CheckoutPaid, payment.ID, provider, id)
+	if err != nil {
+		return checkout, fmt.Errorf("could not settle checkout<%s> of provider<%s>: %v", id, provider, err)
+	}
+	return GetCheckout(provider, id)
+}
+
+// FailCheckout marks a checkout that has not been paid as failed.
+func FailCheckout(provider, id string) (Checkout, error) {
+	_, err := db.Exec("UPDATE payment_checkouts SET status = ? WHERE provider = ? AND id = ? AND status != ?",
+		CheckoutFailed, provider, id, CheckoutPaid)
+	if err != nil {
+		return Checkout{}, fmt.Errorf("could not fail checkout<%s> of provider<%s>: %v", id, provider, err)
+	}
+	return GetCheckout(provider, id)
+}
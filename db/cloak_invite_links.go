@@ -50,11 +50,17 @@ func (invite *CloakInviteLink) Invite(entity utils.Invitee) error {
 	}
 	err := entity.AssociateToCloak(invite.CloakID)
 	if err != nil {
-		return fmt.Errorf("could not invite entity through link <%s> for cloak<%s>; %v",
+		return fmt.Errorf("could not invite entity through link <%s> for cloak<%s>; %w",
 			invite.Link, invite.CloakID, err)
 	}
 	invite.Added++
-	return invite.update()
+	err = invite.update()
+	if err != nil {
+		return fmt.Errorf("could not record invite through link <%s> for cloak<%s>: %v",
+			invite.Link, invite.CloakID, err)
+	}
+	publish(InviteRedeemed{Link: invite, Invitee: entity})
+	return nil
 }
 
 // Delete deletes the cloak_invite_links row which has the passed cloakID and inviteLink
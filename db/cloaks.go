@@ -1,13 +1,29 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
+	"math"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/geocode"
 	"github.com/mcctor/marauders/utils"
 )
 
-const cloakIDLen = 20
+const (
+	cloakIDLen  = 20
+	clockFormat = "15:04:05"
+)
+
+// accuracyDecimals maps a cloak's accuracy level to the number of decimal
+// places its members' coordinates are rounded to before being shown.
+var accuracyDecimals = map[string]int{
+	"street":  3,
+	"city":    1,
+	"country": 0,
+}
 
 type Cloak struct {
 	ID              string `db:"id"`
@@ -28,7 +44,7 @@ type Cloak struct {
 	Modified        string
 }
 
-func (c *Cloak) save() error {
+func (c *Cloak) save(execer sqlx.Execer) error {
 	insertQuery := `
 	INSERT INTO cloaks 
 		(id, user, name, description, active, wake, sleep, accuracy, duration, member_limit,
@@ -36,7 +52,7 @@ func (c *Cloak) save() error {
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 	c.ID = utils.GenerateKey(cloakIDLen)
-	_, err := db.Exec(insertQuery, c.ID, c.User, c.Name, c.Description, c.Active, c.Wake, c.Sleep,
+	_, err := execer.Exec(insertQuery, c.ID, c.User, c.Name, c.Description, c.Active, c.Wake, c.Sleep,
 		c.Accuracy, c.Duration, c.MemberLimit, c.MemberVisible, c.CreatorVisible, c.EveryoneVisible, c.Private)
 	if err != nil {
 		return fmt.Errorf("failed to save cloak<%s>: %v", c.ID, err)
@@ -158,8 +174,188 @@ func (c *Cloak) LocationSnapshotsForMember(device Device, lim int) ([]LocationSn
 	return device.locationSnapshotsForCloak(c, lim)
 }
 
+// LocationSnapshotsForMemberSince returns the location snapshots for the passed device
+// recorded after the since timestamp, oldest first, as allowed by the rules of this cloak.
+func (c *Cloak) LocationSnapshotsForMemberSince(device Device, since string, lim int) ([]LocationSnapshot, error) {
+	return device.locationSnapshotsForCloakSince(c, since, lim)
+}
+
+// NewCircleGeofence creates a geofence owned by this cloak, covering the circle of the
+// passed radius in metres around the center. It applies to all the members of the cloak.
+func (c *Cloak) NewCircleGeofence(name string, center geo.Point, radius float64) (*Geofence, error) {
+	return newCircleGeofence(c.User, sql.NullString{String: c.ID, Valid: true}, name, center, radius,
+		DefaultGeofenceHysteresis)
+}
+
+// NewPolygonGeofence creates a geofence owned by this cloak, covering the area within the
+// passed polygon. It applies to all the members of the cloak.
+func (c *Cloak) NewPolygonGeofence(name string, polygon geo.Polygon) (*Geofence, error) {
+	return newPolygonGeofence(c.User, sql.NullString{String: c.ID, Valid: true}, name, polygon,
+		DefaultGeofenceHysteresis)
+}
+
+// SetProximityRule configures this cloak to record an event whenever two of its members come
+// within the passed distance in metres of each other, or move apart again.
+func (c *Cloak) SetProximityRule(distance float64) (*ProximityRule, error) {
+	if distance <= 0 {
+		return &ProximityRule{}, fmt.Errorf("proximity distance for cloak<%s> should be positive", c.ID)
+	}
+	rule := &ProximityRule{
+		CloakID:    c.ID,
+		Distance:   distance,
+		Hysteresis: math.Min(DefaultGeofenceHysteresis, distance/2),
+	}
+	err := rule.save()
+	if err != nil {
+		return &ProximityRule{}, err
+	}
+	return rule, nil
+}
+
+// ProximityRule returns the proximity rule configured for this cloak. The returned error
+// wraps sql.ErrNoRows if the cloak has none.
+func (c *Cloak) ProximityRule() (*ProximityRule, error) {
+	return getProximityRuleFor(c.ID)
+}
+
+// RemoveProximityRule stops this cloak from recording proximity events.
+func (c *Cloak) RemoveProximityRule() error {
+	_, err := db.Exec("DELETE FROM cloak_proximity_rules WHERE cloak_id = ?", c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to remove proximity rule for cloak<%s>: %v", c.ID, err)
+	}
+	return nil
+}
+
+// ProximityEvents returns the proximity events recorded between the members of this cloak,
+// latest first.
+func (c *Cloak) ProximityEvents(lim int) ([]ProximityEvent, error) {
+	return getProximityEventsFor(c.ID, lim)
+}
+
+// LocationSnapshotsForMemberBetween returns the location snapshots for the passed device
+// recorded from the from time stamp up to the to time stamp, oldest first, as allowed by
+// the rules of this cloak.
+func (c *Cloak) LocationSnapshotsForMemberBetween(device Device, from, to string) ([]LocationSnapshot, error) {
+	allSnaps, err := device.LocationSnapshotsBetween(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loc snapshots for device<%d> of cloak<%s>: %v", device.ID, c.ID, err)
+	}
+	var locationSnaps []LocationSnapshot
+	for _, snapshot := range allSnaps {
+		if c.Permits(snapshot) {
+			locationSnaps = append(locationSnaps, c.Obscure(snapshot))
+		}
+	}
+	return locationSnaps, nil
+}
+
+// EachLocationSnapshotForMemberBetween calls fn with each location snapshot of the passed
+// device recorded between the from and to time stamps that this cloak allows to be seen,
+// oldest first and obscured as the cloak requires.
+func (c *Cloak) EachLocationSnapshotForMemberBetween(device Device, from, to string,
+	fn func(LocationSnapshot) error) error {
+	return device.EachLocationSnapshotBetween(from, to, func(snapshot LocationSnapshot) error {
+		if !c.Permits(snapshot) {
+			return nil
+		}
+		return fn(c.Obscure(snapshot))
+	})
+}
+
+// HasMember checks whether the device with the passed deviceID has joined this cloak.
+func (c *Cloak) HasMember(deviceID int) (bool, error) {
+	var count int
+	err := db.Get(&count, "SELECT COUNT(*) FROM associated_cloaks WHERE cloak_id = ? AND device_id = ?",
+		c.ID, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("could not check membership of device<%d> in cloak<%s>: %v", deviceID, c.ID, err)
+	}
+	return count > 0, nil
+}
+
+// CanBeViewedBy checks whether the user with the passed username is allowed to see
+// the location data of this cloak's members, based on the visibility flags of the cloak.
+func (c *Cloak) CanBeViewedBy(username string) (bool, error) {
+	if !c.Active {
+		return false, nil
+	}
+	if c.EveryoneVisible || (c.CreatorVisible && c.User == username) {
+		return true, nil
+	}
+	if !c.MemberVisible {
+		return false, nil
+	}
+	devices, err := c.AllMembers()
+	if err != nil {
+		return false, fmt.Errorf("could not check visibility of cloak<%s> for user<%s>: %v", c.ID, username, err)
+	}
+	for _, device := range devices {
+		if device.User == username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Permits checks whether the passed snapshot falls within the period and the daily
+// wake and sleep schedule during which this cloak shares its members' locations.
+func (c *Cloak) Permits(snapshot LocationSnapshot) bool {
+	if !c.Active {
+		return false
+	}
+	recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+	if err != nil {
+		return false
+	}
+	since, err := time.Parse(utils.TimeFormat, c.Duration)
+	if err != nil || !recorded.After(since) {
+		return false
+	}
+	clock := recorded.Format(clockFormat)
+	wake, sleep := clockOf(c.Wake), clockOf(c.Sleep)
+	if wake <= sleep {
+		return wake <= clock && clock <= sleep
+	}
+	// the schedule wraps past midnight
+	return clock >= wake || clock <= sleep
+}
+
+// Obscure returns a copy of the passed snapshot whose coordinates have been rounded
+// off to the accuracy level of this cloak. The accuracy radius of the copy is widened
+// to cover the rounding, unless the device reported a coarser one, and the readings
+// that would give away a more precise position are left out.
+func (c *Cloak) Obscure(snapshot LocationSnapshot) LocationSnapshot {
+	decimals, ok := accuracyDecimals[c.Accuracy]
+	if !ok {
+		return snapshot
+	}
+	// the place is named from the actual position, so a rounded one near a border does
+	// not end up named after the wrong side of it
+	level := geocode.LevelCity
+	if c.Accuracy == geocode.LevelCountry {
+		level = geocode.LevelCountry
+	}
+	snapshot.Place = geocode.Default.Lookup(pointOf(snapshot)).Label(level)
+
+	scale := math.Pow(10, float64(decimals))
+	snapshot.Latitude = math.Round(snapshot.Latitude*scale) / scale
+	snapshot.Longitude = math.Round(snapshot.Longitude*scale) / scale
+
+	// the furthest a rounded position can be from the actual one, at the equator
+	radius := math.Sqrt2 / 2 * (geo.EarthRadius * math.Pi / 180) / scale
+	if !snapshot.Accuracy.Valid || snapshot.Accuracy.Float64 < radius {
+		snapshot.Accuracy = sql.NullFloat64{Float64: radius, Valid: true}
+	}
+	snapshot.Altitude = sql.NullFloat64{}
+	snapshot.Speed = sql.NullFloat64{}
+	snapshot.Bearing = sql.NullFloat64{}
+	snapshot.Geohash = ""
+	return snapshot
+}
+
 // newCloak creates a new cloak and commits it to the database
-func newCloak(creator, name, description string, wake, sleep, duration time.Time,
+func newCloak(execer sqlx.Execer, creator, name, description string, wake, sleep, duration time.Time,
 	accuracy string, memberLimit int, memberVisible, creatorVisible, everyoneVisible, isPrivate bool) (*Cloak, error) {
 
 	newCloak := &Cloak{
@@ -177,7 +373,7 @@ func newCloak(creator, name, description string, wake, sleep, duration time.Time
 		EveryoneVisible: everyoneVisible,
 		Private:         isPrivate,
 	}
-	err := newCloak.save()
+	err := newCloak.save(execer)
 	if err != nil {
 		return &Cloak{}, fmt.Errorf("failed to create new cloak<%s>: %v", newCloak.ID, err)
 	}
@@ -194,6 +390,15 @@ func getCloaksFor(username string) (ownedCloaks []*Cloak, err error) {
 	return ownedCloaks, nil
 }
 
+// clockOf returns the time of day portion of a cloak's wake or sleep column, which is
+// stored as a TIME but may be set from a full timestamp.
+func clockOf(value string) string {
+	if len(value) > len(clockFormat) {
+		return value[len(value)-len(clockFormat):]
+	}
+	return value
+}
+
 // GetCloakByID returns the cloaks row which matches the cloakID passed in as a struct
 func GetCloakByID(cloakID string) (fetchedCloak *Cloak, err error) {
 	fetchedCloak = &Cloak{}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// deviceCredentialBytes is how many random bytes make up a credential, which is handed out
+// hex encoded.
+const deviceCredentialBytes = 32
+
+// NewCredential issues a secret with which this device can authenticate itself when it
+// reports its location without a user session, replacing any issued before. Only a hash
+// of the secret is kept, so it can only be handed out once.
+func (d Device) NewCredential() (secret string, err error) {
+	random := make([]byte, deviceCredentialBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("could not issue credential for device<%d>: %v", d.ID, err)
+	}
+	secret = hex.EncodeToString(random)
+	_, err = db.Exec("REPLACE INTO device_credentials (device_id, hash) VALUES (?, ?)",
+		d.ID, credentialHash(secret))
+	if err != nil {
+		return "", fmt.Errorf("could not issue credential for device<%d>: %v", d.ID, err)
+	}
+	return secret, nil
+}
+
+// RevokeCredential removes the credential of this device, if it has one.
+func (d Device) RevokeCredential() error {
+	_, err := db.Exec("DELETE FROM device_credentials WHERE device_id = ?", d.ID)
+	if err != nil {
+		return fmt.Errorf("could not revoke credential for device<%d>: %v", d.ID, err)
+	}
+	return nil
+}
+
+// GetDeviceByCredential returns the device the passed secret was issued to. The returned
+// error wraps sql.ErrNoRows if the secret does not belong to any device.
+func GetDeviceByCredential(secret string) (device Device, err error) {
+	err = db.Get(&device,
+		`SELECT devices.* FROM devices INNER JOIN device_credentials
+			ON devices.id = device_credentials.device_id WHERE device_credentials.hash = ?`,
+		credentialHash(secret))
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to get device by credential: %w", err)
+	}
+	return device, nil
+}
+
+// credentialHash returns the hex encoded sha256 hash of the secret. Credentials are long
+// random keys, so unlike passwords they are looked up by hash and need no salt.
+func credentialHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,72 @@
+package db
+
+import "fmt"
+
+// Protocols under which a device can register the identifier it reports itself with.
+const (
+	ProtocolOsmAnd = "osmand"
+	// ProtocolIMEI covers the hardware trackers that identify themselves by their IMEI
+	ProtocolIMEI = "imei"
+)
+
+// DeviceIdentifier maps the identifier a tracker reports itself with over some protocol
+// to a device.
+type DeviceIdentifier struct {
+	Protocol   string
+	Identifier string
+	DeviceID   int `db:"device_id"`
+	Created    string
+}
+
+// RegisterIdentifier registers the identifier this device reports itself with over the
+// passed protocol. An identifier can only belong to one device per protocol.
+func (d Device) RegisterIdentifier(protocol, identifier string) (*DeviceIdentifier, error) {
+	switch protocol {
+	case ProtocolOsmAnd, ProtocolIMEI:
+	default:
+		return &DeviceIdentifier{}, fmt.Errorf("unknown identifier protocol <%s>", protocol)
+	}
+	if identifier == "" {
+		return &DeviceIdentifier{}, fmt.Errorf("empty %s identifier for device<%d>", protocol, d.ID)
+	}
+	_, err := db.Exec("INSERT INTO device_identifiers (protocol, identifier, device_id) VALUES (?, ?, ?)",
+		protocol, identifier, d.ID)
+	if err != nil {
+		return &DeviceIdentifier{}, fmt.Errorf("could not register %s identifier <%s> for device<%d>: %v",
+			protocol, identifier, d.ID, err)
+	}
+	return &DeviceIdentifier{Protocol: protocol, Identifier: identifier, DeviceID: d.ID}, nil
+}
+
+// Identifiers returns the identifiers registered for this device.
+func (d Device) Identifiers() (identifiers []DeviceIdentifier, err error) {
+	err = db.Select(&identifiers, "SELECT * FROM device_identifiers WHERE device_id = ? ORDER BY created", d.ID)
+	if err != nil {
+		return identifiers, fmt.Errorf("failed to get identifiers of device<%d>: %v", d.ID, err)
+	}
+	return identifiers, nil
+}
+
+// RemoveIdentifier unregisters an identifier of this device.
+func (d Device) RemoveIdentifier(protocol, identifier string) error {
+	_, err := db.Exec("DELETE FROM device_identifiers WHERE protocol = ? AND identifier = ? AND device_id = ?",
+		protocol, identifier, d.ID)
+	if err != nil {
+		return fmt.Errorf("could not remove %s identifier <%s> of device<%d>: %v", protocol, identifier, d.ID, err)
+	}
+	return nil
+}
+
+// GetDeviceByIdentifier returns the device registered under the passed identifier for the
+// protocol. The returned error wraps sql.ErrNoRows if no device is registered under it.
+func GetDeviceByIdentifier(protocol, identifier string) (device Device, err error) {
+	err = db.Get(&device,
+		`SELECT devices.* FROM devices INNER JOIN device_identifiers
+			ON devices.id = device_identifiers.device_id
+			WHERE device_identifiers.protocol = ? AND device_identifiers.identifier = ?`,
+		protocol, identifier)
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to get device by %s identifier <%s>: %w", protocol, identifier, err)
+	}
+	return device, nil
+}
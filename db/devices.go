@@ -1,6 +1,13 @@
 package db
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mcctor/marauders/utils"
+)
 
 type Device struct {
 	ID      int `db:"id"`
@@ -39,9 +46,112 @@ func (d Device) LocationSnapshots(lim int) ([]LocationSnapshot, error) {
 	return allLocationSnapshots, nil
 }
 
+// LocationSnapshotsBetween returns the location snapshots of this device recorded from the
+// passed from time stamp up to but excluding the to time stamp, oldest first.
+func (d Device) LocationSnapshotsBetween(from, to string) ([]LocationSnapshot, error) {
+	var locationSnaps []LocationSnapshot
+	err := db.Select(&locationSnaps,
+		"SELECT * FROM location_snapshots WHERE device_id = ? AND time_stamp >= ? AND time_stamp < ? ORDER BY time_stamp",
+		d.ID, from, to)
+	if err != nil {
+		return locationSnaps, fmt.Errorf("failed to fetch location history for device<%d> between %s and %s: %v",
+			d.ID, from, to, err)
+	}
+	return locationSnaps, nil
+}
+
+// EachLocationSnapshotBetween calls fn with each location snapshot of this device recorded
+// between the from and to time stamps, oldest first, without loading them all into memory.
+// It stops at the first error returned by fn.
+func (d Device) EachLocationSnapshotBetween(from, to string, fn func(LocationSnapshot) error) error {
+	rows, err := db.Queryx(
+		"SELECT * FROM location_snapshots WHERE device_id = ? AND time_stamp >= ? AND time_stamp < ? ORDER BY time_stamp",
+		d.ID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to fetch location history for device<%d> between %s and %s: %v",
+			d.ID, from, to, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var snapshot LocationSnapshot
+		if err := rows.StructScan(&snapshot); err != nil {
+			return fmt.Errorf("failed to read location history for device<%d>: %v", d.ID, err)
+		}
+		if err := fn(snapshot); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read location history for device<%d>: %v", d.ID, err)
+	}
+	return nil
+}
+
+// ImportLocationSnapshots adds a batch of historical location snapshots to this device,
+// skipping those at a time stamp the device already has a snapshot for. Imported history
+// bypasses the ingest filter and publishes no events. In a dry run nothing is saved, but
+// the returned counts are those that a real import of this batch would have produced. As
+// nothing is saved, snapshots repeating those of an earlier batch of the same dry run are
+// not caught here.
+func (d Device) ImportLocationSnapshots(snapshots []LocationSnapshot, dryRun bool) (imported, duplicates int,
+	err error) {
+	if len(snapshots) == 0 {
+		return 0, 0, nil
+	}
+	stamps := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		stamps[i] = snapshot.TimeStamp
+	}
+	query, args, err := sqlx.In(
+		"SELECT time_stamp FROM location_snapshots WHERE device_id = ? AND time_stamp IN (?)", d.ID, stamps)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to build duplicate check for device<%d>: %v", d.ID, err)
+	}
+	var existing []string
+	if err := db.Select(&existing, db.Rebind(query), args...); err != nil {
+		return 0, 0, fmt.Errorf("failed to check for duplicate snapshots of device<%d>: %v", d.ID, err)
+	}
+	seen := make(map[string]bool, len(existing)+len(snapshots))
+	for _, stamp := range existing {
+		seen[normalizeTimeStamp(stamp)] = true
+	}
+
+	var fresh []LocationSnapshot
+	for _, snapshot := range snapshots {
+		stamp := normalizeTimeStamp(snapshot.TimeStamp)
+		if seen[stamp] {
+			duplicates++
+			continue
+		}
+		seen[stamp] = true
+		snapshot.DeviceID = d.ID
+		fresh = append(fresh, snapshot.dropInvalidReadings())
+	}
+	if dryRun || len(fresh) == 0 {
+		return len(fresh), duplicates, nil
+	}
+	imported, err = insertLocationSnapshots(fresh)
+	if err != nil {
+		return 0, duplicates, fmt.Errorf("failed to import location history for device<%d>: %v", d.ID, err)
+	}
+	meterSnapshots(map[int]int{d.ID: imported})
+	latest := fresh[0]
+	for _, snapshot := range fresh[1:] {
+		if snapshot.TimeStamp > latest.TimeStamp {
+			latest = snapshot
+		}
+	}
+	if err := updateLatestLocation(latest); err != nil {
+		return imported, duplicates + len(fresh) - imported, err
+	}
+	// anything the insert ignored was written concurrently at the same time stamp
+	return imported, duplicates + len(fresh) - imported, nil
+}
+
 // NewLocationSnapshot adds the passed fields for a LocationSnapshot struct
 // as a row in the LocationSnapshots table. If this fails, an error is
-// returned.NewLocation
+// returned. Snapshots turned away by the ingest filter are kept aside, and
+// a *RejectedSnapshotError is returned for them.
 func (d Device) NewLocationSnapshot(timeStamp string, latitude, longitude float64) error {
 	newSnapshot := LocationSnapshot{
 		DeviceID:  d.ID,
@@ -49,15 +159,80 @@ func (d Device) NewLocationSnapshot(timeStamp string, latitude, longitude float6
 		Latitude:  latitude,
 		Longitude: longitude,
 	}
-	return newSnapshot.save()
+	return d.ingest(newSnapshot)
 }
 
-// AssociateToCloak associates this device to the cloak with the specified cloak_id.
-func (d Device) AssociateToCloak(cloakID string) error {
-	_, err := db.Exec("INSERT INTO associated_cloaks (cloak_id, device_id) VALUES (?, ?)", cloakID, d.ID)
+// RecordLocationSnapshot adds the passed snapshot, along with whichever of its optional
+// readings are set, to the location history of this device. Snapshots turned away by the
+// ingest filter are kept aside, and a *RejectedSnapshotError is returned for them.
+func (d Device) RecordLocationSnapshot(snapshot LocationSnapshot) error {
+	snapshot.DeviceID = d.ID
+	return d.ingest(snapshot)
+}
+
+// RejectedSnapshots returns the latest location snapshots of this device that were turned
+// away by the ingest filter, along with the reason for each.
+func (d Device) RejectedSnapshots(lim int) ([]RejectedSnapshot, error) {
+	return getRejectedSnapshotsFor(d.ID, lim)
+}
+
+// FellowMemberLocations returns the latest location, as obscured by the cloak, of every
+// other device sharing a cloak with this one that the owner of this device may see.
+func (d Device) FellowMemberLocations() ([]LocationSnapshot, error) {
+	cloaks, err := d.AssociatedCloaks()
 	if err != nil {
+		return nil, err
+	}
+	latest := make(map[int]LocationSnapshot)
+	for _, cloak := range cloaks {
+		visible, err := cloak.CanBeViewedBy(d.User)
+		if err != nil {
+			return nil, fmt.Errorf("could not get fellow members of device<%d>: %v", d.ID, err)
+		}
+		if !visible {
+			continue
+		}
+		locations, err := cloak.LatestMemberLocations()
+		if err != nil {
+			return nil, fmt.Errorf("could not get fellow members of device<%d>: %v", d.ID, err)
+		}
+		for _, snapshot := range locations {
+			if snapshot.DeviceID == d.ID {
+				continue
+			}
+			if snapshot.TimeStamp > latest[snapshot.DeviceID].TimeStamp {
+				latest[snapshot.DeviceID] = snapshot
+			}
+		}
+	}
+	locations := make([]LocationSnapshot, 0, len(latest))
+	for _, snapshot := range latest {
+		locations = append(locations, snapshot)
+	}
+	return locations, nil
+}
+
+// AssociateToCloak associates this device to the cloak with the specified cloak_id. An
+// *UpgradeRequiredError is returned if the cloak already has as many members as the plan
+// of its creator allows.
+func (d Device) AssociateToCloak(cloakID string) error {
+	var creator string
+	if err := db.Get(&creator, "SELECT user FROM cloaks WHERE id = ?", cloakID); err != nil {
 		return fmt.Errorf("device<%d> for user<%s> could not join cloak<%s>: %v", d.ID, d.User, cloakID, err)
 	}
+	insert := func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("INSERT INTO associated_cloaks (cloak_id, device_id) VALUES (?, ?)", cloakID, d.ID)
+		if err != nil {
+			return fmt.Errorf("device<%d> for user<%s> could not join cloak<%s>: %v", d.ID, d.User, cloakID, err)
+		}
+		return nil
+	}
+	err := insertWithinEntitlement(creator, func(plan Plan) int { return plan.MaxMembersPerCloak },
+		"members per cloak", insert, "SELECT COUNT(*) FROM associated_cloaks WHERE cloak_id = ?", cloakID)
+	if err != nil {
+		return err
+	}
+	publish(DeviceJoinedCloak{Device: d, CloakID: cloakID})
 	return nil
 }
 
@@ -84,27 +259,90 @@ func (d Device) Delete() error {
 // locationSnapshotsForCloak method returns the location history of device after having been
 // filtered by the rules of the passed cloak.
 func (d Device) locationSnapshotsForCloak(cloak *Cloak, lim int) (locationSnaps []LocationSnapshot, err error) {
+	wake, sleep := clockOf(cloak.Wake), clockOf(cloak.Sleep)
+	schedule := "TIME(time_stamp) BETWEEN ? AND ?"
+	if wake > sleep {
+		// the schedule wraps past midnight, as in Cloak.Permits
+		schedule = "(TIME(time_stamp) >= ? OR TIME(time_stamp) <= ?)"
+	}
 	query := `
 	SELECT * FROM location_snapshots
-	WHERE device_id = ? AND (time_stamp > ? AND (TIME(time_stamp) BETWEEN ? AND ?))
+	WHERE device_id = ? AND time_stamp > ? AND ` + schedule + `
 	ORDER BY time_stamp DESC LIMIT ?
 `
-	err = db.Select(&locationSnaps, query, d.ID, cloak.Duration, cloak.Wake, cloak.Sleep, lim)
+	err = db.Select(&locationSnaps, query, d.ID, cloak.Duration, wake, sleep, lim)
 	if err != nil {
 		return locationSnaps, fmt.Errorf("failed to get loc snapshots for device<%d> of cloak<%s>: %v",
 			d.ID, cloak.ID, err)
 	}
-	return locationSnaps, nil
+	return obscureFor(cloak, locationSnaps), nil
+}
+
+// locationSnapshotsForCloakSince method returns the location history of device recorded after
+// the passed since timestamp, oldest first, filtered by the rules of the passed cloak.
+func (d Device) locationSnapshotsForCloakSince(cloak *Cloak, since string,
+	lim int) (locationSnaps []LocationSnapshot, err error) {
+	query := `
+	SELECT * FROM location_snapshots
+	WHERE device_id = ? AND time_stamp > ? ORDER BY time_stamp ASC LIMIT ?
+`
+	var allSnaps []LocationSnapshot
+	err = db.Select(&allSnaps, query, d.ID, since, lim)
+	if err != nil {
+		return locationSnaps, fmt.Errorf("failed to get loc snapshots since %s for device<%d> of cloak<%s>: %v",
+			since, d.ID, cloak.ID, err)
+	}
+	for _, snapshot := range allSnaps {
+		if cloak.Permits(snapshot) {
+			locationSnaps = append(locationSnaps, snapshot)
+		}
+	}
+	return obscureFor(cloak, locationSnaps), nil
+}
+
+// ingest screens the snapshot with the ingest filter, then saves and publishes it, through
+// the batcher if batched ingest was started.
+func (d Device) ingest(snapshot LocationSnapshot) error {
+	if err := checkSnapshotRate(d, snapshot); err != nil {
+		return err
+	}
+	screened, late, err := IngestFilter.screen(snapshot)
+	var rejection *RejectedSnapshotError
+	if errors.As(err, &rejection) {
+		rejectSnapshot(rejection)
+		return err
+	} else if err != nil {
+		return fmt.Errorf("could not screen location snapshot for device<%d>: %v", d.ID, err)
+	}
+	if b := activeBatcher(); b != nil {
+		return b.write(screened, late)
+	}
+	err = screened.save()
+	if err != nil {
+		return err
+	}
+	meterSnapshots(map[int]int{d.ID: 1})
+	publish(LocationSnapshotRecorded{Snapshot: screened, Late: late})
+	return nil
+}
+
+// obscureFor rounds off the coordinates of the passed snapshots to the accuracy
+// level of the passed cloak.
+func obscureFor(cloak *Cloak, snapshots []LocationSnapshot) []LocationSnapshot {
+	for i, snapshot := range snapshots {
+		snapshots[i] = cloak.Obscure(snapshot)
+	}
+	return snapshots
 }
 
 // newDeviceFor adds a new row to the table Device associating it to
 // the passed username.
-func newDeviceFor(username string, deviceID int) (Device, error) {
+func newDeviceFor(execer sqlx.Execer, username string, deviceID int) (Device, error) {
 	createdDevice := Device{
 		ID:   deviceID,
 		User: username,
 	}
-	_, err := db.Exec("INSERT INTO devices (id, user) VALUES (?, ?)", deviceID, username)
+	_, err := execer.Exec("INSERT INTO devices (id, user) VALUES (?, ?)", deviceID, username)
 	if err != nil {
 		return Device{}, fmt.Errorf("failed to create new device for user<%s>: %v", username, err)
 	}
@@ -118,3 +356,22 @@ func getDevicesFor(username string) (devices []Device, err error) {
 	}
 	return devices, nil
 }
+
+// GetDeviceByID returns the devices row whose id matches the passed deviceID as a struct.
+func GetDeviceByID(deviceID int) (device Device, err error) {
+	err = db.Get(&device, "SELECT * FROM devices WHERE id = ?", deviceID)
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to get device<%d>: %v", deviceID, err)
+	}
+	return device, nil
+}
+
+// normalizeTimeStamp formats a time stamp to millisecond precision, so that one read back
+// from the database compares equal to the one that was written.
+func normalizeTimeStamp(stamp string) string {
+	parsed, err := time.Parse(utils.TimeFormat, stamp)
+	if err != nil {
+		return stamp
+	}
+	return parsed.Format(utils.TimeFormatMilli)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"log"
+	"sync"
+
+	"github.com/mcctor/marauders/utils"
+)
+
+// Topics of the events published by this package.
+const (
+	TopicUserCreated              = "user.created"
+	TopicLocationSnapshotRecorded = "location_snapshot.recorded"
+	TopicDeviceJoinedCloak        = "device.joined_cloak"
+	TopicInviteRedeemed           = "invite.redeemed"
+	TopicUserBilled               = "user.billed"
+	TopicGeofenceCrossed          = "geofence.crossed"
+	TopicProximityChanged         = "proximity.changed"
+)
+
+var (
+	eventHandlersLock sync.RWMutex
+	eventHandlers     = make(map[string][]eventSubscription)
+	nextHandlerID     int
+)
+
+type eventSubscription struct {
+	id      int
+	handler EventHandler
+}
+
+// Event is a change that has been committed to the database, published so that
+// other parts of the system can react to it without touching the persistence code.
+type Event interface {
+	Topic() string
+}
+
+// EventHandler is called with every event published under the topic it subscribed to.
+type EventHandler func(Event)
+
+// UserCreated is published once a new user and their billing account have been saved.
+type UserCreated struct {
+	User *User
+}
+
+// LocationSnapshotRecorded is published once a device's location snapshot has been saved.
+// Late is set when the device had already reported snapshots recorded after it.
+type LocationSnapshotRecorded struct {
+	Snapshot LocationSnapshot
+	Late     bool
+}
+
+// DeviceJoinedCloak is published once a device has been associated to a cloak.
+type DeviceJoinedCloak struct {
+	Device  Device
+	CloakID string
+}
+
+// InviteRedeemed is published once an invite link has been used to bring an
+// entity into the link's cloak.
+type InviteRedeemed struct {
+	Link    *CloakInviteLink
+	Invitee utils.Invitee
+}
+
+// UserBilled is published once a transaction has been posted to the ledger for a user.
+type UserBilled struct {
+	Transaction LedgerTransaction
+}
+
+// GeofenceCrossed is published once a device has been recorded entering or exiting a geofence.
+type GeofenceCrossed struct {
+	Geofence *Geofence
+	Event    *GeofenceEvent
+}
+
+// ProximityChanged is published once two members of a cloak have been recorded coming
+// near each other or moving apart.
+type ProximityChanged struct {
+	Event *ProximityEvent
+}
+
+func (UserCreated) Topic() string              { return TopicUserCreated }
+func (LocationSnapshotRecorded) Topic() string { return TopicLocationSnapshotRecorded }
+func (DeviceJoinedCloak) Topic() string        { return TopicDeviceJoinedCloak }
+func (InviteRedeemed) Topic() string           { return TopicInviteRedeemed }
+func (UserBilled) Topic() string               { return TopicUserBilled }
+func (GeofenceCrossed) Topic() string          { return TopicGeofenceCrossed }
+func (ProximityChanged) Topic() string         { return TopicProximityChanged }
+
+// Subscribe registers the handler for the events published under the passed topic and
+// returns a function that removes it again. Handlers are called synchronously by the
+// publishing operation, so any lengthy work should be handed off to another goroutine.
+func Subscribe(topic string, handler EventHandler) (unsubscribe func()) {
+	eventHandlersLock.Lock()
+	defer eventHandlersLock.Unlock()
+
+	nextHandlerID++
+	id := nextHandlerID
+	eventHandlers[topic] = append(eventHandlers[topic], eventSubscription{id, handler})
+
+	return func() {
+		eventHandlersLock.Lock()
+		defer eventHandlersLock.Unlock()
+		subscriptions := eventHandlers[topic]
+		for i, subscription := range subscriptions {
+			if subscription.id == id {
+				eventHandlers[topic] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish passes the event to every handler subscribed to its topic, in the order they
+// subscribed. A handler that panics is logged and does not stop the rest from running.
+func publish(event Event) {
+	eventHandlersLock.RLock()
+	subscriptions := eventHandlers[event.Topic()]
+	eventHandlersLock.RUnlock()
+
+	for _, subscription := range subscriptions {
+		callEventHandler(subscription.handler, event)
+	}
+}
+
+func callEventHandler(handler EventHandler, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("handler for event %s panicked: %v", event.Topic(), r)
+		}
+	}()
+	handler(event)
+}
@@ -0,0 +1,63 @@
+package db
+
+import "fmt"
+
+const (
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+)
+
+type GeofenceEvent struct {
+	ID         int    `db:"id"`
+	GeofenceID string `db:"geofence_id"`
+	DeviceID   int    `db:"device_id"`
+	Kind       string
+	TimeStamp  string `db:"time_stamp"`
+	Latitude   float64
+	Longitude  float64
+	Created    string
+}
+
+// save commits the fields of a newly constructed geofence event to the database.
+func (event *GeofenceEvent) save() error {
+	_, err := db.Exec(
+		`INSERT INTO geofence_events (geofence_id, device_id, kind, time_stamp, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		event.GeofenceID, event.DeviceID, event.Kind, event.TimeStamp, event.Latitude, event.Longitude)
+	if err != nil {
+		return fmt.Errorf("failed to save %s event of device<%d> for geofence<%s>: %v",
+			event.Kind, event.DeviceID, event.GeofenceID, err)
+	}
+	return nil
+}
+
+// newGeofenceEvent records the device of the passed snapshot entering or exiting the
+// geofence, and publishes the crossing.
+func newGeofenceEvent(geofence *Geofence, kind string, snapshot LocationSnapshot) error {
+	event := &GeofenceEvent{
+		GeofenceID: geofence.ID,
+		DeviceID:   snapshot.DeviceID,
+		Kind:       kind,
+		TimeStamp:  snapshot.TimeStamp,
+		Latitude:   snapshot.Latitude,
+		Longitude:  snapshot.Longitude,
+	}
+	err := event.save()
+	if err != nil {
+		return err
+	}
+	publish(GeofenceCrossed{Geofence: geofence, Event: event})
+	return nil
+}
+
+// getGeofenceEventsFor returns the events recorded for the geofence with the passed
+// geofenceID, latest first.
+func getGeofenceEventsFor(geofenceID string, lim int) (events []GeofenceEvent, err error) {
+	err = db.Select(&events,
+		"SELECT * FROM geofence_events WHERE geofence_id = ? ORDER BY time_stamp DESC, id DESC LIMIT ?",
+		geofenceID, lim)
+	if err != nil {
+		return events, fmt.Errorf("failed to get events for geofence<%s>: %v", geofenceID, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	geofenceIDLen = 20
+
+	GeofenceCircle  = "circle"
+	GeofencePolygon = "polygon"
+
+	// DefaultGeofenceHysteresis is the distance in metres a device has to travel past the
+	// edge of a geofence before it is considered to have crossed it. Geofences too small
+	// for it to be reached inside get half their radius or depth instead.
+	DefaultGeofenceHysteresis = 25.0
+)
+
+type Geofence struct {
+	ID         string `db:"id"`
+	User       string
+	CloakID    sql.NullString `db:"cloak_id"`
+	Name       string
+	Kind       string
+	Latitude   sql.NullFloat64
+	Longitude  sql.NullFloat64
+	Radius     sql.NullFloat64
+	Vertices   sql.NullString
+	Hysteresis float64
+	Created    string
+}
+
+// save commits the fields of a newly constructed geofence struct to the database.
+func (g *Geofence) save() error {
+	insertQuery := `
+	INSERT INTO geofences
+		(id, user, cloak_id, name, kind, latitude, longitude, radius, vertices, hysteresis)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+	g.ID = utils.GenerateKey(geofenceIDLen)
+	_, err := db.Exec(insertQuery, g.ID, g.User, g.CloakID, g.Name, g.Kind, g.Latitude, g.Longitude,
+		g.Radius, g.Vertices, g.Hysteresis)
+	if err != nil {
+		return fmt.Errorf("failed to save geofence<%s>: %v", g.ID, err)
+	}
+	return nil
+}
+
+// Delete removes the geofence row with this struct's ID, along with its recorded events.
+func (g *Geofence) Delete() error {
+	_, err := db.Exec("DELETE FROM geofences WHERE id = ?", g.ID)
+	if err != nil {
+		return fmt.Errorf("could not delete geofence<%s>: %v", g.ID, err)
+	}
+	return nil
+}
+
+// Polygon returns the vertices of a polygon geofence.
+func (g *Geofence) Polygon() (geo.Polygon, error) {
+	var polygon geo.Polygon
+	err := json.Unmarshal([]byte(g.Vertices.String), &polygon)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vertices of geofence<%s>: %v", g.ID, err)
+	}
+	return polygon, nil
+}
+
+// Events returns the enter and exit events recorded for this geofence, latest first.
+func (g *Geofence) Events(lim int) ([]GeofenceEvent, error) {
+	return getGeofenceEventsFor(g.ID, lim)
+}
+
+// signedDistance returns the distance in metres from the point to the edge of this
+// geofence, negative when the point lies inside it.
+func (g *Geofence) signedDistance(point geo.Point) (float64, error) {
+	if g.Kind == GeofenceCircle {
+		center := geo.Point{Latitude: g.Latitude.Float64, Longitude: g.Longitude.Float64}
+		return geo.Distance(center, point) - g.Radius.Float64, nil
+	}
+	polygon, err := g.Polygon()
+	if err != nil {
+		return 0, err
+	}
+	return polygon.SignedDistance(point), nil
+}
+
+// evaluate compares the snapshot against this geofence and records an event if the device
+// has crossed its edge by more than the hysteresis, so that GPS jitter around the edge
+// does not produce a stream of enter and exit events.
+func (g *Geofence) evaluate(snapshot LocationSnapshot) error {
+	distance, err := g.signedDistance(pointOf(snapshot))
+	if err != nil {
+		return err
+	}
+	var inside bool
+	err = db.Get(&inside, "SELECT inside FROM geofence_states WHERE geofence_id = ? AND device_id = ?",
+		g.ID, snapshot.DeviceID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get state of device<%d> in geofence<%s>: %v", snapshot.DeviceID, g.ID, err)
+	}
+
+	var kind string
+	switch {
+	case !inside && distance < -g.Hysteresis:
+		kind = GeofenceEnter
+	case inside && distance > g.Hysteresis:
+		kind = GeofenceExit
+	default:
+		return nil
+	}
+
+	_, err = db.Exec("REPLACE INTO geofence_states (geofence_id, device_id, inside) VALUES (?, ?, ?)",
+		g.ID, snapshot.DeviceID, kind == GeofenceEnter)
+	if err != nil {
+		return fmt.Errorf("failed to save state of device<%d> in geofence<%s>: %v", snapshot.DeviceID, g.ID, err)
+	}
+	return newGeofenceEvent(g, kind, snapshot)
+}
+
+// newCircleGeofence creates a new circular geofence and commits it to the database.
+func newCircleGeofence(owner string, cloakID sql.NullString, name string, center geo.Point,
+	radius, hysteresis float64) (*Geofence, error) {
+	if !center.IsValid() || radius <= 0 {
+		return &Geofence{}, fmt.Errorf("failed to create new geofence for user<%s>: invalid circle", owner)
+	}
+	geofence := &Geofence{
+		User:       owner,
+		CloakID:    cloakID,
+		Name:       name,
+		Kind:       GeofenceCircle,
+		Latitude:   sql.NullFloat64{Float64: center.Latitude, Valid: true},
+		Longitude:  sql.NullFloat64{Float64: center.Longitude, Valid: true},
+		Radius:     sql.NullFloat64{Float64: radius, Valid: true},
+		Hysteresis: math.Min(hysteresis, radius/2),
+	}
+	err := geofence.save()
+	if err != nil {
+		return &Geofence{}, fmt.Errorf("failed to create new geofence for user<%s>: %v", owner, err)
+	}
+	return geofence, nil
+}
+
+// newPolygonGeofence creates a new polygon geofence and commits it to the database.
+func newPolygonGeofence(owner string, cloakID sql.NullString, name string, polygon geo.Polygon,
+	hysteresis float64) (*Geofence, error) {
+	if len(polygon) < 3 {
+		return &Geofence{}, fmt.Errorf("failed to create new geofence for user<%s>: too few vertices", owner)
+	}
+	for _, vertex := range polygon {
+		if !vertex.IsValid() {
+			return &Geofence{}, fmt.Errorf("failed to create new geofence for user<%s>: invalid vertex", owner)
+		}
+	}
+	vertices, _ := json.Marshal(polygon)
+	geofence := &Geofence{
+		User:       owner,
+		CloakID:    cloakID,
+		Name:       name,
+		Kind:       GeofencePolygon,
+		Vertices:   sql.NullString{String: string(vertices), Valid: true},
+		Hysteresis: math.Min(hysteresis, polygon.Depth()/2),
+	}
+	err := geofence.save()
+	if err != nil {
+		return &Geofence{}, fmt.Errorf("failed to create new geofence for user<%s>: %v", owner, err)
+	}
+	return geofence, nil
+}
+
+// getGeofencesFor returns the geofences created by the passed user.
+func getGeofencesFor(username string) (geofences []*Geofence, err error) {
+	err = db.Select(&geofences, "SELECT * FROM geofences WHERE user = ? ORDER BY created DESC", username)
+	if err != nil {
+		return geofences, fmt.Errorf("failed to get geofences for user<%s>: %v", username, err)
+	}
+	return geofences, nil
+}
+
+// getGeofencesWatching returns the geofences that apply to the passed device, being the
+// personal geofences of its owner and the geofences of the cloaks it has joined.
+func getGeofencesWatching(device Device) (geofences []*Geofence, err error) {
+	query := `
+	SELECT * FROM geofences
+	WHERE (cloak_id IS NULL AND user = ?)
+	   OR cloak_id IN (SELECT cloak_id FROM associated_cloaks WHERE device_id = ?)
+`
+	err = db.Select(&geofences, query, device.User, device.ID)
+	if err != nil {
+		return geofences, fmt.Errorf("failed to get geofences watching device<%d>: %v", device.ID, err)
+	}
+	return geofences, nil
+}
+
+// evaluateGeofences checks every geofence that applies to the device of a newly
+// recorded snapshot for enter and exit events. Late snapshots are skipped, as the
+// device has already been placed by a more recent one.
+func evaluateGeofences(event Event) {
+	recorded := event.(LocationSnapshotRecorded)
+	if recorded.Late {
+		return
+	}
+	snapshot := recorded.Snapshot
+	device, err := GetDeviceByID(snapshot.DeviceID)
+	if err != nil {
+		log.Printf("could not evaluate geofences: %v", err)
+		return
+	}
+	geofences, err := getGeofencesWatching(device)
+	if err != nil {
+		log.Printf("could not evaluate geofences: %v", err)
+		return
+	}
+	for _, geofence := range geofences {
+		if err := geofence.evaluateShared(snapshot); err != nil {
+			log.Printf("could not evaluate geofence<%s>: %v", geofence.ID, err)
+		}
+	}
+}
+
+// evaluateShared evaluates the snapshot against this geofence as its creator may see it.
+// The geofence of a cloak only sees what the cloak shares, so snapshots outside its
+// schedule are skipped and the rest are obscured to its accuracy first, so that events
+// reveal no more than the cloak itself does.
+func (g *Geofence) evaluateShared(snapshot LocationSnapshot) error {
+	if !g.CloakID.Valid {
+		return g.evaluate(snapshot)
+	}
+	cloak, err := GetCloakByID(g.CloakID.String)
+	if err != nil {
+		return err
+	}
+	if !cloak.Permits(snapshot) {
+		return nil
+	}
+	return g.evaluate(cloak.Obscure(snapshot))
+}
+
+// GetGeofenceByID returns the geofences row which matches the passed geofenceID as a struct.
+func GetGeofenceByID(geofenceID string) (geofence *Geofence, err error) {
+	geofence = &Geofence{}
+	err = db.Get(geofence, "SELECT * FROM geofences WHERE id = ?", geofenceID)
+	if err != nil {
+		return geofence, fmt.Errorf("failed to get geofence with id<%s>: %v", geofenceID, err)
+	}
+	return geofence, nil
+}
+
+func init() {
+	Subscribe(TopicLocationSnapshotRecorded, evaluateGeofences)
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IngestBatching sets how snapshots are buffered and written together once batched
+// ingest has been started with StartBatchedIngest.
+var IngestBatching = IngestBatchConfig{
+	BatchSize:     500,
+	FlushInterval: 200 * time.Millisecond,
+	QueueSize:     5000,
+}
+
+// IngestBatchConfig sets the size and timing of the batches snapshots are written in.
+type IngestBatchConfig struct {
+	// BatchSize is how many snapshots are written with a single insert.
+	BatchSize int
+	// FlushInterval is the longest a snapshot waits for its batch to fill before it is
+	// written anyway.
+	FlushInterval time.Duration
+	// QueueSize is how many snapshots may wait to be written. Recording a snapshot blocks
+	// while the queue is full, holding back devices until the database catches up.
+	QueueSize int
+}
+
+// ErrIngestStopped is returned for snapshots recorded after batched ingest was stopped.
+var ErrIngestStopped = errors.New("batched ingest has been stopped")
+
+var (
+	batcherLock sync.RWMutex
+	batcher     *snapshotBatcher
+)
+
+// pendingSnapshot is a screened snapshot waiting in the queue, along with where to report
+// whether it was written.
+type pendingSnapshot struct {
+	snapshot LocationSnapshot
+	late     bool
+	written  chan error
+}
+
+// snapshotBatcher collects queued snapshots into batches and writes each with a single
+// multi-row insert.
+type snapshotBatcher struct {
+	config  IngestBatchConfig
+	queue   chan pendingSnapshot
+	drained chan struct{}
+	// lock keeps the queue from being closed while snapshots are being sent on it
+	lock   sync.RWMutex
+	closed bool
+}
+
+// StartBatchedIngest buffers recorded snapshots and writes them in batches rather than
+// one insert each. Recording a snapshot still waits for its batch to be written, so errors
+// reach the caller as before, but snapshots recorded at the same time share an insert.
+// Screening only sees snapshots already written, so a jump within a single batch may get
+// through the ingest filter.
+//
+// The returned stop function writes whatever is still queued and returns once it is.
+func StartBatchedIngest() (stop func()) {
+	b := &snapshotBatcher{
+		config:  IngestBatching,
+		queue:   make(chan pendingSnapshot, IngestBatching.QueueSize),
+		drained: make(chan struct{}),
+	}
+	go b.run()
+
+	batcherLock.Lock()
+	batcher = b
+	batcherLock.Unlock()
+	return func() {
+		batcherLock.Lock()
+		if batcher == b {
+			batcher = nil
+		}
+		batcherLock.Unlock()
+		b.close()
+	}
+}
+
+// activeBatcher returns the batcher snapshots are written through, or nil if each is to
+// be saved on its own.
+func activeBatcher() *snapshotBatcher {
+	batcherLock.RLock()
+	defer batcherLock.RUnlock()
+	return batcher
+}
+
+// write queues the screened snapshot and waits until its batch has been written.
+func (b *snapshotBatcher) write(snapshot LocationSnapshot, late bool) error {
+	pending := pendingSnapshot{snapshot: snapshot, late: late, written: make(chan error, 1)}
+	b.lock.RLock()
+	if b.closed {
+		b.lock.RUnlock()
+		return ErrIngestStopped
+	}
+	b.queue <- pending
+	b.lock.RUnlock()
+	return <-pending.written
+}
+
+// close stops the batcher taking snapshots and waits for the queued ones to be written.
+func (b *snapshotBatcher) close() {
+	b.lock.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.lock.Unlock()
+	<-b.drained
+}
+
+func (b *snapshotBatcher) run() {
+	defer close(b.drained)
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingSnapshot, 0, b.config.BatchSize)
+	for {
+		select {
+		case pending, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) >= b.config.BatchSize {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes the batch, publishes its snapshots once they are saved and reports the
+// outcome to everyone waiting on them.
+func (b *snapshotBatcher) flush(batch []pendingSnapshot) {
+	if len(batch) == 0 {
+		return
+	}
+	// snapshots repeated within the batch are turned away here as a single insert would
+	// have, as the batch insert would otherwise fail for all of them
+	type key struct {
+		deviceID  int
+		timeStamp string
+	}
+	seen := make(map[key]bool, len(batch))
+	snapshots := make([]LocationSnapshot, 0, len(batch))
+	unique := batch[:0:0]
+	for _, pending := range batch {
+		k := key{pending.snapshot.DeviceID, pending.snapshot.TimeStamp}
+		if seen[k] {
+			pending.written <- fmt.Errorf("failed to save locationsnapshot for device<%d>: already recorded at %s",
+				k.deviceID, k.timeStamp)
+			continue
+		}
+		seen[k] = true
+		snapshots = append(snapshots, pending.snapshot)
+		unique = append(unique, pending)
+	}
+	if err := insertNewLocationSnapshots(snapshots); err != nil {
+		// a single snapshot that cannot be saved, such as one already recorded by an
+		// earlier batch, fails the whole insert, so each is saved on its own instead and
+		// only those actually saved are published
+		saved := make(map[int]int)
+		for _, pending := range unique {
+			err := pending.snapshot.save()
+			if err == nil {
+				saved[pending.snapshot.DeviceID]++
+			}
+			settle(pending, err)
+		}
+		meterSnapshots(saved)
+		return
+	}
+	saved := make(map[int]int)
+	for _, pending := range unique {
+		saved[pending.snapshot.DeviceID]++
+	}
+	meterSnapshots(saved)
+	for _, pending := range unique {
+		settle(pending, nil)
+	}
+}
+
+// settle publishes the snapshot if it was saved and reports the outcome to its caller.
+func settle(pending pendingSnapshot, err error) {
+	if err == nil {
+		publish(LocationSnapshotRecorded{Snapshot: pending.snapshot, Late: pending.late})
+	}
+	pending.written <- err
+}
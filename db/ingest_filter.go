@@ -0,0 +1,226 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/utils"
+)
+
+// Reasons a location snapshot can be rejected for on ingest.
+const (
+	RejectBadTimeStamp = "bad_time_stamp"
+	RejectFuture       = "future_time_stamp"
+	RejectOutOfRange   = "out_of_range"
+	RejectNullIsland   = "null_island"
+	RejectDuplicate    = "duplicate"
+	RejectTooFast      = "too_fast"
+)
+
+// IngestFilter holds the settings used to screen location snapshots as they are recorded.
+var IngestFilter = IngestFilterConfig{
+	MaxSpeed:         90,
+	SpeedCheckWindow: time.Hour,
+	ReanchorAfter:    3,
+	MaxFutureSkew:    5 * time.Minute,
+	SmoothingWindow:  2 * time.Minute,
+}
+
+// IngestFilterConfig sets how strictly recorded location snapshots are screened.
+type IngestFilterConfig struct {
+	// MaxSpeed is the speed in metres per second above which a snapshot is taken to be a
+	// jump caused by a bad fix rather than actual movement.
+	MaxSpeed float64
+	// SpeedCheckWindow is how close in time neighbouring snapshots have to be for the speed
+	// between them to be checked. After a longer gap, such as a flight, a device may turn up
+	// anywhere.
+	SpeedCheckWindow time.Duration
+	// ReanchorAfter is how many snapshots in a row may be rejected as too fast since the
+	// latest saved one before the next is taken as the device's true position, so that a
+	// single bad fix does not lock the device out. Re-anchoring is disabled when it is 0.
+	ReanchorAfter int
+	// MaxFutureSkew is how far ahead of the server's clock a snapshot may be recorded.
+	MaxFutureSkew time.Duration
+	// Smoothing is the weight, between 0 and 1, given to the previous position when
+	// smoothing a new one. Smoothing is disabled when it is 0.
+	Smoothing float64
+	// SmoothingWindow is how close in time the previous position has to be to be smoothed with.
+	SmoothingWindow time.Duration
+}
+
+// RejectedSnapshotError is returned when a location snapshot does not pass the ingest filter.
+type RejectedSnapshotError struct {
+	Snapshot LocationSnapshot
+	Reason   string
+}
+
+func (err *RejectedSnapshotError) Error() string {
+	return fmt.Sprintf("location snapshot for device<%d> at %s rejected: %s",
+		err.Snapshot.DeviceID, err.Snapshot.TimeStamp, err.Reason)
+}
+
+type RejectedSnapshot struct {
+	ID        int    `db:"id"`
+	DeviceID  int    `db:"device_id"`
+	TimeStamp string `db:"time_stamp"`
+	Latitude  float64
+	Longitude float64
+	Reason    string
+	Received  string
+}
+
+// save commits the fields of a newly rejected snapshot to the database.
+func (rejected *RejectedSnapshot) save() error {
+	_, err := db.Exec(
+		"INSERT INTO rejected_snapshots (device_id, time_stamp, latitude, longitude, reason) VALUES (?, ?, ?, ?, ?)",
+		rejected.DeviceID, rejected.TimeStamp, rejected.Latitude, rejected.Longitude, rejected.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to save rejected snapshot for device<%d>: %v", rejected.DeviceID, err)
+	}
+	return nil
+}
+
+// screen checks the snapshot against the ingest filter, returning the snapshot as it should
+// be saved, whether it arrived after snapshots recorded later than it, and a
+// *RejectedSnapshotError if it should not be saved at all.
+func (config IngestFilterConfig) screen(snapshot LocationSnapshot) (screened LocationSnapshot, late bool, err error) {
+	recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+	if err != nil {
+		return snapshot, false, &RejectedSnapshotError{snapshot, RejectBadTimeStamp}
+	}
+	if recorded.Sub(time.Now()) > config.MaxFutureSkew {
+		return snapshot, false, &RejectedSnapshotError{snapshot, RejectFuture}
+	}
+	position := pointOf(snapshot)
+	if !position.IsValid() {
+		return snapshot, false, &RejectedSnapshotError{snapshot, RejectOutOfRange}
+	}
+	if snapshot.Latitude == 0 && snapshot.Longitude == 0 {
+		return snapshot, false, &RejectedSnapshotError{snapshot, RejectNullIsland}
+	}
+	snapshot = snapshot.dropInvalidReadings()
+
+	previous, next, err := neighboursOf(snapshot)
+	if err != nil {
+		return snapshot, false, err
+	}
+	for _, neighbour := range []*LocationSnapshot{previous, next} {
+		if neighbour == nil {
+			continue
+		}
+		elapsed := elapsedBetween(*neighbour, recorded)
+		if elapsed == 0 {
+			return snapshot, false, &RejectedSnapshotError{snapshot, RejectDuplicate}
+		}
+		if elapsed > config.SpeedCheckWindow ||
+			geo.Distance(pointOf(*neighbour), position)/elapsed.Seconds() <= config.MaxSpeed {
+			continue
+		}
+		if neighbour == previous && next == nil {
+			reanchor, err := config.reanchors(*previous)
+			if err != nil {
+				return snapshot, false, err
+			}
+			if reanchor {
+				continue
+			}
+		}
+		return snapshot, false, &RejectedSnapshotError{snapshot, RejectTooFast}
+	}
+
+	// only the newest position is smoothed, late ones are kept as they were reported
+	if config.Smoothing > 0 && previous != nil && next == nil &&
+		elapsedBetween(*previous, recorded) <= config.SmoothingWindow {
+		snapshot.Latitude = config.Smoothing*previous.Latitude + (1-config.Smoothing)*snapshot.Latitude
+		snapshot.Longitude = config.Smoothing*previous.Longitude + (1-config.Smoothing)*snapshot.Longitude
+	}
+	return snapshot, next != nil, nil
+}
+
+// reanchors checks whether enough snapshots in a row have been rejected as too fast since
+// the latest saved one that it is more likely the saved one was the bad fix.
+func (config IngestFilterConfig) reanchors(latest LocationSnapshot) (bool, error) {
+	if config.ReanchorAfter == 0 {
+		return false, nil
+	}
+	var rejected int
+	err := db.Get(&rejected,
+		"SELECT COUNT(*) FROM rejected_snapshots WHERE device_id = ? AND reason = ? AND time_stamp > ?",
+		latest.DeviceID, RejectTooFast, latest.TimeStamp)
+	if err != nil {
+		return false, fmt.Errorf("failed to count rejected snapshots of device<%d>: %v", latest.DeviceID, err)
+	}
+	return rejected >= config.ReanchorAfter, nil
+}
+
+// elapsedBetween returns the absolute time between the saved snapshot and the passed time.
+func elapsedBetween(saved LocationSnapshot, recorded time.Time) time.Duration {
+	savedRecorded, err := time.Parse(utils.TimeFormat, saved.TimeStamp)
+	if err != nil {
+		return time.Duration(math.MaxInt64)
+	}
+	elapsed := recorded.Sub(savedRecorded)
+	if elapsed < 0 {
+		return -elapsed
+	}
+	return elapsed
+}
+
+// neighboursOf returns the saved snapshots of the same device recorded closest before or at,
+// and after the passed snapshot, either of which is nil if there is none.
+func neighboursOf(snapshot LocationSnapshot) (previous, next *LocationSnapshot, err error) {
+	previous = &LocationSnapshot{}
+	err = db.Get(previous,
+		"SELECT * FROM location_snapshots WHERE device_id = ? AND time_stamp <= ? ORDER BY time_stamp DESC LIMIT 1",
+		snapshot.DeviceID, snapshot.TimeStamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		previous = nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to get snapshot preceding %s for device<%d>: %v",
+			snapshot.TimeStamp, snapshot.DeviceID, err)
+	}
+
+	next = &LocationSnapshot{}
+	err = db.Get(next,
+		"SELECT * FROM location_snapshots WHERE device_id = ? AND time_stamp > ? ORDER BY time_stamp LIMIT 1",
+		snapshot.DeviceID, snapshot.TimeStamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		next = nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to get snapshot following %s for device<%d>: %v",
+			snapshot.TimeStamp, snapshot.DeviceID, err)
+	}
+	return previous, next, nil
+}
+
+// rejectSnapshot keeps the snapshot turned away by the ingest filter so that misbehaving
+// devices can be looked into.
+func rejectSnapshot(rejection *RejectedSnapshotError) {
+	rejected := &RejectedSnapshot{
+		DeviceID:  rejection.Snapshot.DeviceID,
+		TimeStamp: rejection.Snapshot.TimeStamp,
+		Latitude:  rejection.Snapshot.Latitude,
+		Longitude: rejection.Snapshot.Longitude,
+		Reason:    rejection.Reason,
+	}
+	if err := rejected.save(); err != nil {
+		log.Println(err)
+	}
+}
+
+// getRejectedSnapshotsFor returns the snapshots of the device with the passed deviceID that
+// were turned away by the ingest filter, latest first.
+func getRejectedSnapshotsFor(deviceID int, lim int) (rejected []RejectedSnapshot, err error) {
+	err = db.Select(&rejected,
+		"SELECT * FROM rejected_snapshots WHERE device_id = ? ORDER BY received DESC, id DESC LIMIT ?",
+		deviceID, lim)
+	if err != nil {
+		return rejected, fmt.Errorf("failed to get rejected snapshots for device<%d>: %v", deviceID, err)
+	}
+	return rejected, nil
+}
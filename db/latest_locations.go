@@ -0,0 +1,148 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// latestLocationColumns are the columns of latest_locations read back as a LocationSnapshot.
+const latestLocationColumns = `device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing,
+	battery, provider, received, geohash`
+
+// updateLatestLocation records the snapshot as the latest location of its device, unless
+// the device already has a more recent one. The comparison is made by the upsert itself, so
+// snapshots of a device saved at the same time cannot overwrite a newer one with an older.
+func updateLatestLocation(snapshot LocationSnapshot) error {
+	// the columns are assigned in order, so time_stamp has to be the last one updated for
+	// the others to be compared against the one being replaced
+	_, err := db.Exec(
+		`INSERT INTO latest_locations
+			(device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing, battery, provider, geohash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			latitude = IF(VALUES(time_stamp) > time_stamp, VALUES(latitude), latitude),
+			longitude = IF(VALUES(time_stamp) > time_stamp, VALUES(longitude), longitude),
+			altitude = IF(VALUES(time_stamp) > time_stamp, VALUES(altitude), altitude),
+			accuracy = IF(VALUES(time_stamp) > time_stamp, VALUES(accuracy), accuracy),
+			speed = IF(VALUES(time_stamp) > time_stamp, VALUES(speed), speed),
+			bearing = IF(VALUES(time_stamp) > time_stamp, VALUES(bearing), bearing),
+			battery = IF(VALUES(time_stamp) > time_stamp, VALUES(battery), battery),
+			provider = IF(VALUES(time_stamp) > time_stamp, VALUES(provider), provider),
+			geohash = IF(VALUES(time_stamp) > time_stamp, VALUES(geohash), geohash),
+			received = IF(VALUES(time_stamp) > time_stamp, CURRENT_TIMESTAMP(3), received),
+			time_stamp = IF(VALUES(time_stamp) > time_stamp, VALUES(time_stamp), time_stamp)`,
+		snapshot.DeviceID, snapshot.TimeStamp, snapshot.Latitude, snapshot.Longitude, snapshot.Altitude,
+		snapshot.Accuracy, snapshot.Speed, snapshot.Bearing, snapshot.Battery, snapshot.Provider,
+		geohashOf(snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to update latest location of device<%d>: %v", snapshot.DeviceID, err)
+	}
+	return nil
+}
+
+// LatestLocationSnapshot returns the most recent location snapshot of this device. The
+// returned error wraps sql.ErrNoRows if the device has not reported any yet.
+func (d Device) LatestLocationSnapshot() (snapshot LocationSnapshot, err error) {
+	err = db.Get(&snapshot, "SELECT "+latestLocationColumns+" FROM latest_locations WHERE device_id = ?", d.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// history recorded before latest locations were kept is only found in full
+		err = db.Get(&snapshot,
+			"SELECT * FROM location_snapshots WHERE device_id = ? ORDER BY time_stamp DESC LIMIT 1", d.ID)
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to fetch latest location of device<%d>: %w", d.ID, err)
+	}
+	return snapshot, nil
+}
+
+// LatestMemberLocation returns the most recent location snapshot of the passed device that
+// this cloak allows to be seen, obscured as the cloak requires. The returned error wraps
+// sql.ErrNoRows if there is none.
+func (c *Cloak) LatestMemberLocation(device Device) (LocationSnapshot, error) {
+	latest, err := device.LatestLocationSnapshot()
+	if err != nil {
+		return latest, err
+	}
+	if c.Permits(latest) {
+		return c.Obscure(latest), nil
+	}
+	visible, err := c.LocationSnapshotsForMember(device, 1)
+	if err != nil {
+		return LocationSnapshot{}, err
+	}
+	if len(visible) == 0 {
+		return LocationSnapshot{}, fmt.Errorf("no visible location of device<%d> in cloak<%s>: %w",
+			device.ID, c.ID, sql.ErrNoRows)
+	}
+	return visible[0], nil
+}
+
+// LatestMemberLocations returns the most recent location snapshot this cloak allows to be
+// seen of each of its members, obscured as the cloak requires. Members are looked up in
+// latest_locations all at once, and only those whose latest position falls outside what
+// the cloak shares are looked up in their history.
+func (c *Cloak) LatestMemberLocations() ([]LocationSnapshot, error) {
+	members, err := c.Members()
+	if err != nil {
+		return nil, fmt.Errorf("could not get latest locations of cloak<%s>: %v", c.ID, err)
+	}
+	return c.latestLocationsOf(members)
+}
+
+// latestLocationsOf returns the most recent location snapshot this cloak allows to be seen
+// of each of the passed members, obscured as the cloak requires.
+func (c *Cloak) latestLocationsOf(members []Device) ([]LocationSnapshot, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	ids := make([]int, len(members))
+	for i, member := range members {
+		ids[i] = member.ID
+	}
+	query, args, err := sqlx.In("SELECT "+latestLocationColumns+" FROM latest_locations WHERE device_id IN (?)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("could not build query for latest locations of cloak<%s>: %v", c.ID, err)
+	}
+	var latest []LocationSnapshot
+	if err := db.Select(&latest, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("could not get latest locations of cloak<%s>: %v", c.ID, err)
+	}
+	latestByID := make(map[int]LocationSnapshot, len(latest))
+	for _, snapshot := range latest {
+		latestByID[snapshot.DeviceID] = snapshot
+	}
+
+	locations := make([]LocationSnapshot, 0, len(members))
+	seen := make(map[int]bool, len(members))
+	for _, member := range members {
+		// a device may be a member both directly and through a permitted cloak
+		if seen[member.ID] {
+			continue
+		}
+		seen[member.ID] = true
+		snapshot, ok := latestByID[member.ID]
+		if ok && c.Permits(snapshot) {
+			locations = append(locations, c.Obscure(snapshot))
+			continue
+		}
+		visible, err := c.LocationSnapshotsForMember(member, 1)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, visible...)
+	}
+	return locations, nil
+}
+
+func init() {
+	Subscribe(TopicLocationSnapshotRecorded, func(event Event) {
+		recorded := event.(LocationSnapshotRecorded)
+		if err := updateLatestLocation(recorded.Snapshot); err != nil {
+			log.Print(err)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+// DefaultCurrency is the currency amounts are charged in unless another is given.
+const DefaultCurrency = "USD"
+
+// Types of ledger transactions.
+const (
+	// TransactionCharge bills the user for something they used.
+	TransactionCharge = "charge"
+	// TransactionPayment records money the user paid.
+	TransactionPayment = "payment"
+	// TransactionCredit gives the user money off what they owe.
+	TransactionCredit = "credit"
+	// TransactionRefund returns money the user paid.
+	TransactionRefund = "refund"
+	// TransactionAdjustment corrects what the user owes, such as the opening balance
+	// carried over from the old billings table.
+	TransactionAdjustment = "adjustment"
+)
+
+// Ledger accounts money moves between, besides the account of each user.
+const (
+	// AccountRevenue is what has been earned from charges.
+	AccountRevenue = "revenue"
+	// AccountPayments is the money that has been received from users.
+	AccountPayments = "payments"
+)
+
+// postings are the accounts each type of transaction debits and credits. userAccount
+// stands for the account of the user the transaction is for, whose balance is what the
+// user owes.
+var postings = map[string]struct{ debit, credit string }{
+	TransactionCharge:     {userAccount, AccountRevenue},
+	TransactionPayment:    {AccountPayments, userAccount},
+	TransactionCredit:     {AccountRevenue, userAccount},
+	TransactionRefund:     {userAccount, AccountPayments},
+	TransactionAdjustment: {userAccount, AccountRevenue},
+}
+
+const userAccount = "user:"
+
+// ErrIdempotencyKeyReused is returned when an idempotency key that was already used is
+// passed along with a different transaction.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different transaction")
+
+// Money is an amount in the minor units of its currency, such as cents.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// String formats the money with two decimal places, followed by its currency.
+func (m Money) String() string {
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, m.Currency)
+}
+
+// LedgerTransaction is a single movement of money for a user. It is made up of entries
+// that always add up to zero.
+type LedgerTransaction struct {
+	ID             int
+	User           string
+	Type           string
+	Description    string
+	Amount         int64
+	Currency       string
+	IdempotencyKey string `db:"idempotency_key"`
+	Created        string
+	// Entries are only filled in by User.LedgerTransaction
+	Entries []LedgerEntry `db:"-"`
+}
+
+// Money returns the amount of the transaction.
+func (t LedgerTransaction) Money() Money {
+	return Money{Amount: t.Amount, Currency: t.Currency}
+}
+
+// LedgerEntry moves an amount in or out of a ledger account. Debits are positive and
+// credits negative.
+type LedgerEntry struct {
+	ID            int
+	TransactionID int `db:"transaction_id"`
+	Account       string
+	Amount        int64
+	Currency      string
+}
+
+// Charge bills this user the amount. Posting again with the same idempotency key returns
+// the transaction already posted rather than billing the user twice.
+func (u *User) Charge(amount Money, description, idempotencyKey string) (LedgerTransaction, error) {
+	return postLedgerTransaction(u.Username, TransactionCharge, amount, description, idempotencyKey)
+}
+
+// RecordPayment records money paid by this user against what they owe.
+func (u *User) RecordPayment(amount Money, description, idempotencyKey string) (LedgerTransaction, error) {
+	return postLedgerTransaction(u.Username, TransactionPayment, amount, description, idempotencyKey)
+}
+
+// Credit takes the amount off what this user owes without them paying it.
+func (u *User) Credit(amount Money, description, idempotencyKey string) (LedgerTransaction, error) {
+	return postLedgerTransaction(u.Username, TransactionCredit, amount, description, idempotencyKey)
+}
+
+// Refund returns money this user paid, adding it back to what they owe.
+func (u *User) Refund(amount Money, description, idempotencyKey string) (LedgerTransaction, error) {
+	return postLedgerTransaction(u.Username, TransactionRefund, amount, description, idempotencyKey)
+}
+
+// Balance returns what this user owes in the currency, derived from the entries of their
+// account. A negative balance is money held in the user's favour.
+func (u *User) Balance(currency string) (Money, error) {
+	var balance sql.NullInt64
+	err := db.Get(&balance, "SELECT SUM(amount) FROM ledger_entries WHERE account = ? AND currency = ?",
+		userAccount+u.Username, currency)
+	if err != nil {
+		return Money{}, fmt.Errorf("could not get balance of user<%s>: %v", u.Username, err)
+	}
+	return Money{Amount: balance.Int64, Currency: currency}, nil
+}
+
+// LedgerTransactions returns the latest transactions of this user, latest first.
+func (u *User) LedgerTransactions(lim int) (transactions []LedgerTransaction, err error) {
+	err = db.Select(&transactions,
+		"SELECT * FROM ledger_transactions WHERE user = ? ORDER BY created DESC, id DESC LIMIT ?", u.Username, lim)
+	if err != nil {
+		return transactions, fmt.Errorf("could not get ledger transactions of user<%s>: %v", u.Username, err)
+	}
+	return transactions, nil
+}
+
+// LedgerTransaction returns the transaction of this user with the passed id along with its
+// entries. The returned error wraps sql.ErrNoRows if there is none.
+func (u *User) LedgerTransaction(id int) (transaction LedgerTransaction, err error) {
+	err = db.Get(&transaction, "SELECT * FROM ledger_transactions WHERE id = ? AND user = ?", id, u.Username)
+	if err != nil {
+		return transaction, fmt.Errorf("could not get ledger transaction<%d> of user<%s>: %w", id, u.Username, err)
+	}
+	err = db.Select(&transaction.Entries, "SELECT * FROM ledger_entries WHERE transaction_id = ? ORDER BY id", id)
+	if err != nil {
+		return transaction, fmt.Errorf("could not get entries of ledger transaction<%d>: %v", id, err)
+	}
+	return transaction, nil
+}
+
+// postLedgerTransaction posts a transaction of the passed type for the user, debiting and
+// crediting the accounts its type moves money between. The transaction and its entries
+// are saved together, and a transaction already posted with the same idempotency key is
+// returned instead of posting it again.
+func postLedgerTransaction(username, kind string, amount Money, description,
+	idempotencyKey string) (LedgerTransaction, error) {
+	posting, ok := postings[kind]
+	if !ok {
+		return LedgerTransaction{}, fmt.Errorf("no ledger transaction of type %q", kind)
+	}
+	if amount.Amount <= 0 {
+		return LedgerTransaction{}, fmt.Errorf("ledger transaction for user<%s> should be of a positive amount",
+			username)
+	}
+	if !validCurrency(amount.Currency) {
+		return LedgerTransaction{}, fmt.Errorf("%q is not a currency code", amount.Currency)
+	}
+	if idempotencyKey == "" {
+		return LedgerTransaction{}, fmt.Errorf("ledger transaction for user<%s> needs an idempotency key", username)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger transaction for user<%s>: %v", username, err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`
+	INSERT IGNORE INTO ledger_transactions (user, type, description, amount, currency, idempotency_key)
+		VALUES (?, ?, ?, ?, ?, ?)`, username, kind, description, amount.Amount, amount.Currency, idempotencyKey)
+	if err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger transaction for user<%s>: %v", username, err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		tx.Rollback()
+		return postedLedgerTransaction(username, kind, amount, idempotencyKey)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger transaction for user<%s>: %v", username, err)
+	}
+	accountOf := func(account string) string {
+		if account == userAccount {
+			return userAccount + username
+		}
+		return account
+	}
+	_, err = tx.Exec(`
+	INSERT INTO ledger_entries (transaction_id, account, amount, currency) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		id, accountOf(posting.debit), amount.Amount, amount.Currency,
+		id, accountOf(posting.credit), -amount.Amount, amount.Currency)
+	if err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger entries for user<%s>: %v", username, err)
+	}
+	var transaction LedgerTransaction
+	if err := tx.Get(&transaction, "SELECT * FROM ledger_transactions WHERE id = ?", id); err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger transaction for user<%s>: %v", username, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not post ledger transaction for user<%s>: %v", username, err)
+	}
+	publish(UserBilled{Transaction: transaction})
+	return transaction, nil
+}
+
+// postedLedgerTransaction returns the transaction already posted for the user with the
+// idempotency key, making sure it is the same transaction being posted again.
+func postedLedgerTransaction(username, kind string, amount Money, idempotencyKey string) (LedgerTransaction, error) {
+	var posted LedgerTransaction
+	err := db.Get(&posted, "SELECT * FROM ledger_transactions WHERE user = ? AND idempotency_key = ?",
+		username, idempotencyKey)
+	if err != nil {
+		return LedgerTransaction{}, fmt.Errorf("could not get ledger transaction %q of user<%s>: %v",
+			idempotencyKey, username, err)
+	}
+	if posted.Type != kind || posted.Money() != amount {
+		return posted, ErrIdempotencyKeyReused
+	}
+	return posted, nil
+}
+
+// validCurrency checks that the currency is given as a three letter ISO 4217 code.
+func validCurrency(currency string) bool {
+	return len(currency) == 3 && strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
+
+// migrateLegacyBillings carries over what each user owed in the old billings table, whose
+// rows were running totals, as an opening balance in the ledger. The old table is then
+// renamed to billings_legacy so it is only carried over once.
+func migrateLegacyBillings() error {
+	var tables []string
+	if err := db.Select(&tables, "SHOW TABLES LIKE 'billings'"); err != nil {
+		return fmt.Errorf("could not look for legacy billings: %v", err)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	var totals []struct {
+		User   string
+		Debit  float64
+		Credit float64
+	}
+	err := db.Select(&totals, `
+	SELECT billings.user, billings.debit, billings.credit FROM billings
+	INNER JOIN (SELECT user, MAX(time_stamp) AS latest FROM billings GROUP BY user) AS latest
+		ON latest.user = billings.user AND latest.latest = billings.time_stamp`)
+	if err != nil {
+		return fmt.Errorf("could not read legacy billings: %v", err)
+	}
+	for _, total := range totals {
+		owed := int64(math.Round((total.Debit - total.Credit) * 100))
+		kind := TransactionAdjustment
+		if owed < 0 {
+			kind, owed = TransactionCredit, -owed
+		}
+		if owed == 0 {
+			continue
+		}
+		_, err := postLedgerTransaction(total.User, kind, Money{Amount: owed, Currency: DefaultCurrency},
+			"opening balance carried over from billings", "legacy-billings")
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("RENAME TABLE billings TO billings_legacy"); err != nil {
+		return fmt.Errorf("could not retire legacy billings: %v", err)
+	}
+	log.Printf("carried over the billing balances of %d users into the ledger", len(totals))
+	return nil
+}
@@ -1,22 +1,182 @@
 package db
 
-import "fmt"
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
 
+	"github.com/mcctor/marauders/geocode"
+)
+
+// Providers a device can report a location snapshot as having come from.
+const (
+	ProviderGPS     = "gps"
+	ProviderNetwork = "network"
+	ProviderFused   = "fused"
+)
+
+// LocationSnapshot is a position reported by a device. TimeStamp is when the device took
+// the fix and Received is when the server saved it. The rest of the fields are optional,
+// as not every device reports them.
 type LocationSnapshot struct {
 	DeviceID  int    `db:"device_id"`
 	TimeStamp string `db:"time_stamp"`
 	Latitude  float64
 	Longitude float64
+	// Altitude above sea level in metres
+	Altitude sql.NullFloat64
+	// Accuracy is the radius in metres within which the actual position lies
+	Accuracy sql.NullFloat64
+	// Speed over ground in metres per second
+	Speed sql.NullFloat64
+	// Bearing in degrees clockwise from true north
+	Bearing sql.NullFloat64
+	// Battery level as a percentage
+	Battery  sql.NullInt64
+	Provider sql.NullString
+	Received string
+	// Geohash of the position, indexing the snapshot for spatial queries
+	Geohash string
+	// Place names where the snapshot was taken, as much as it may be told. It is only set
+	// on snapshots obscured by a cloak, see PlaceLabel.
+	Place string `db:"-"`
+}
+
+// PlaceLabel names the city and country the snapshot was taken in. Snapshots seen through
+// a cloak are named no more precisely than the accuracy level of the cloak allows.
+func (snapshot LocationSnapshot) PlaceLabel() string {
+	if snapshot.Place != "" {
+		return snapshot.Place
+	}
+	return geocode.Default.Lookup(pointOf(snapshot)).Label(geocode.LevelCity)
 }
 
 // saves commits the struct's fields to the location_snapshots table in the database.
 func (snapshot LocationSnapshot) save() error {
 	_, err := db.Exec(
-		"INSERT INTO location_snapshots (device_id, time_stamp, latitude, longitude) VALUES (?, ?, ?, ?)",
-		snapshot.DeviceID, snapshot.TimeStamp, snapshot.Latitude, snapshot.Longitude,
+		`INSERT INTO location_snapshots
+			(device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing, battery, provider, geohash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		snapshot.DeviceID, snapshot.TimeStamp, snapshot.Latitude, snapshot.Longitude, snapshot.Altitude,
+		snapshot.Accuracy, snapshot.Speed, snapshot.Bearing, snapshot.Battery, snapshot.Provider,
+		geohashOf(snapshot),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save locationsnapshot for device<%d>: %v", snapshot.DeviceID, err)
 	}
 	return nil
 }
+
+// dropInvalidReadings clears the optional readings of the snapshot that are out of range,
+// so that a position is not lost over a bad reading from another sensor.
+func (snapshot LocationSnapshot) dropInvalidReadings() LocationSnapshot {
+	if snapshot.Accuracy.Valid && snapshot.Accuracy.Float64 < 0 {
+		snapshot.Accuracy = sql.NullFloat64{}
+	}
+	if snapshot.Speed.Valid && snapshot.Speed.Float64 < 0 {
+		snapshot.Speed = sql.NullFloat64{}
+	}
+	if snapshot.Bearing.Valid && (snapshot.Bearing.Float64 < 0 || snapshot.Bearing.Float64 >= 360) {
+		snapshot.Bearing = sql.NullFloat64{}
+	}
+	if snapshot.Battery.Valid && (snapshot.Battery.Int64 < 0 || snapshot.Battery.Int64 > 100) {
+		snapshot.Battery = sql.NullInt64{}
+	}
+	switch snapshot.Provider.String {
+	case ProviderGPS, ProviderNetwork, ProviderFused:
+	default:
+		snapshot.Provider = sql.NullString{}
+	}
+	return snapshot
+}
+
+// insertLocationSnapshots saves the passed snapshots with a single multi-row insert.
+// Snapshots whose device already has one at the same time stamp are skipped, and the
+// number of rows actually inserted is returned.
+func insertLocationSnapshots(snapshots []LocationSnapshot) (int, error) {
+	return execSnapshotsInsert("INSERT IGNORE", snapshots)
+}
+
+// insertNewLocationSnapshots saves the passed snapshots with a single multi-row insert,
+// which fails as a whole if any of them cannot be saved, such as one already recorded.
+func insertNewLocationSnapshots(snapshots []LocationSnapshot) error {
+	_, err := execSnapshotsInsert("INSERT", snapshots)
+	return err
+}
+
+// execSnapshotsInsert saves the passed snapshots with the insert statement, returning the
+// number of rows inserted.
+func execSnapshotsInsert(insert string, snapshots []LocationSnapshot) (int, error) {
+	if len(snapshots) == 0 {
+		return 0, nil
+	}
+	query := insert + ` INTO location_snapshots
+		(device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing, battery, provider, geohash)
+		VALUES ` + strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), ", len(snapshots)), ", ")
+	args := make([]interface{}, 0, len(snapshots)*11)
+	for _, snapshot := range snapshots {
+		args = append(args, snapshot.DeviceID, snapshot.TimeStamp, snapshot.Latitude, snapshot.Longitude,
+			snapshot.Altitude, snapshot.Accuracy, snapshot.Speed, snapshot.Bearing, snapshot.Battery,
+			snapshot.Provider, geohashOf(snapshot))
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save %d location snapshots: %v", len(snapshots), err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count saved location snapshots: %v", err)
+	}
+	return int(inserted), nil
+}
+
+// addedSnapshotColumns are the columns location_snapshots has gained since it only held
+// the device, time stamp and coordinates, along with their definitions.
+var addedSnapshotColumns = []struct{ name, definition string }{
+	{"altitude", "FLOAT"},
+	{"accuracy", "FLOAT"},
+	{"speed", "FLOAT"},
+	{"bearing", "FLOAT"},
+	{"battery", "TINYINT"},
+	{"provider", "ENUM('gps', 'network', 'fused')"},
+	{"received", "DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3)"},
+}
+
+// migrateLocationSnapshots brings a location_snapshots table created before the optional
+// readings were recorded up to date, as CREATE TABLE IF NOT EXISTS leaves it as it was. The
+// missing columns are added and the time stamps given milliseconds, so it does nothing once
+// the table is up to date.
+func migrateLocationSnapshots() error {
+	var columns []struct {
+		Name string
+		Type string
+	}
+	err := db.Select(&columns, `
+	SELECT COLUMN_NAME AS name, COLUMN_TYPE AS type FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'location_snapshots'`)
+	if err != nil {
+		return fmt.Errorf("could not read the columns of location_snapshots: %v", err)
+	}
+	types := make(map[string]string)
+	for _, column := range columns {
+		types[strings.ToLower(column.Name)] = strings.ToLower(column.Type)
+	}
+	var alterations []string
+	if types["time_stamp"] != "datetime(3)" {
+		alterations = append(alterations, "MODIFY COLUMN time_stamp DATETIME(3) NOT NULL")
+	}
+	for _, column := range addedSnapshotColumns {
+		if _, ok := types[column.name]; !ok {
+			alterations = append(alterations, "ADD COLUMN "+column.name+" "+column.definition)
+		}
+	}
+	if len(alterations) == 0 {
+		return nil
+	}
+	if _, err := db.Exec("ALTER TABLE location_snapshots " + strings.Join(alterations, ", ")); err != nil {
+		return fmt.Errorf("could not migrate location_snapshots: %v", err)
+	}
+	log.Printf("migrated location_snapshots: %s", strings.Join(alterations, ", "))
+	return nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mcctor/marauders/utils"
+)
+
+// Pricing turns the usage metered over a billing period into a charge.
+var Pricing = PricingConfig{
+	Currency:             DefaultCurrency,
+	PerThousandSnapshots: 5,
+	PerActiveDevice:      50,
+	PerCloak:             100,
+	PerMember:            10,
+}
+
+// PricingConfig sets the price of each kind of billable usage, in minor units of the
+// currency.
+type PricingConfig struct {
+	Currency string
+	// PerThousandSnapshots is charged for every thousand location snapshots stored, and
+	// pro rata for part of a thousand.
+	PerThousandSnapshots int64
+	// PerActiveDevice is charged for every device that reported a location in the period.
+	PerActiveDevice int64
+	// PerCloak is charged for every cloak the user has created.
+	PerCloak int64
+	// PerMember is charged for every device that has joined one of the user's cloaks.
+	PerMember int64
+}
+
+// Usage is what a user has used over a billing period.
+type Usage struct {
+	User          string
+	PeriodStart   string `db:"period_start"`
+	PeriodEnd     string `db:"period_end"`
+	Snapshots     int
+	ActiveDevices int `db:"active_devices"`
+	Cloaks        int
+	Members       int
+}
+
+// UsageStatement is the usage of a closed billing period, along with what it was charged.
+type UsageStatement struct {
+	Usage
+	Amount   int64
+	Currency string
+	Billed   bool
+	Closed   string
+}
+
+// Charge prices the usage, rounding part of a minor unit to the nearest.
+func (pricing PricingConfig) Charge(usage Usage) Money {
+	amount := (int64(usage.Snapshots)*pricing.PerThousandSnapshots+500)/1000 +
+		int64(usage.ActiveDevices)*pricing.PerActiveDevice +
+		int64(usage.Cloaks)*pricing.PerCloak +
+		int64(usage.Members)*pricing.PerMember
+	return Money{Amount: amount, Currency: pricing.Currency}
+}
+
+// BillingPeriodOf returns the calendar month, in UTC, the passed time falls in.
+func BillingPeriodOf(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Usage meters what this user has used between the start and end of a period. Snapshots
+// and active devices are those counted as the snapshots were saved, so history purged since
+// is still billed, while cloaks and members are those that existed by the end of the period.
+func (u *User) Usage(start, end time.Time) (Usage, error) {
+	usage := Usage{
+		User:        u.Username,
+		PeriodStart: start.UTC().Format(utils.TimeFormat),
+		PeriodEnd:   end.UTC().Format(utils.TimeFormat),
+	}
+	err := db.QueryRowx(`
+	SELECT COALESCE(SUM(snapshots), 0), COUNT(DISTINCT device_id) FROM snapshot_usage
+	WHERE user = ? AND period_start >= ? AND period_start < ? AND snapshots > 0`,
+		u.Username, usage.PeriodStart, usage.PeriodEnd).Scan(&usage.Snapshots, &usage.ActiveDevices)
+	if err != nil {
+		return usage, fmt.Errorf("could not meter snapshots of user<%s>: %v", u.Username, err)
+	}
+	err = db.Get(&usage.Cloaks, "SELECT COUNT(*) FROM cloaks WHERE user = ? AND created < ?",
+		u.Username, usage.PeriodEnd)
+	if err != nil {
+		return usage, fmt.Errorf("could not meter cloaks of user<%s>: %v", u.Username, err)
+	}
+	err = db.Get(&usage.Members, `
+	SELECT COUNT(*) FROM associated_cloaks
+	INNER JOIN cloaks ON cloaks.id = associated_cloaks.cloak_id
+	WHERE cloaks.user = ? AND associated_cloaks.created < ?`, u.Username, usage.PeriodEnd)
+	if err != nil {
+		return usage, fmt.Errorf("could not meter cloak members of user<%s>: %v", u.Username, err)
+	}
+	return usage, nil
+}
+
+// meterSnapshots counts the snapshots just saved for each device towards the billing period
+// under way. A count that cannot be saved is logged, as the snapshots themselves were.
+func meterSnapshots(saved map[int]int) {
+	start, _ := BillingPeriodOf(time.Now())
+	for deviceID, count := range saved {
+		if count == 0 {
+			continue
+		}
+		_, err := db.Exec(`
+		INSERT INTO snapshot_usage (user, device_id, period_start, snapshots)
+			SELECT user, id, ?, ? FROM devices WHERE id = ?
+		ON DUPLICATE KEY UPDATE snapshots = snapshots + VALUES(snapshots)`,
+			start.Format(utils.TimeFormat), count, deviceID)
+		if err != nil {
+			log.Printf("could not meter %d snapshots of device<%d>: %v", count, deviceID, err)
+		}
+	}
+}
+
+// CloseUsagePeriod meters this user's usage over the period and charges it. The statement
+// saved the first time a period is closed is the one charged, and the charge is posted to
+// the ledger under an idempotency key of the period, so closing it again, or retrying a
+// close that failed part way, never charges the user twice.
+func (u *User) CloseUsagePeriod(start, end time.Time) (UsageStatement, error) {
+	usage, err := u.Usage(start, end)
+	if err != nil {
+		return UsageStatement{}, err
+	}
+	charge := Pricing.Charge(usage)
+	_, err = db.Exec(`
+	INSERT IGNORE INTO usage_statements
+		(user, period_start, period_end, snapshots, active_devices, cloaks, members, amount, currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.Username, usage.PeriodStart, usage.PeriodEnd, usage.Snapshots, usage.ActiveDevices, usage.Cloaks,
+		usage.Members, charge.Amount, charge.Currency)
+	if err != nil {
+		return UsageStatement{}, fmt.Errorf("could not close usage period of user<%s>: %v", u.Username, err)
+	}
+	var statement UsageStatement
+	err = db.Get(&statement, "SELECT * FROM usage_statements WHERE user = ? AND period_start = ?",
+		u.Username, usage.PeriodStart)
+	if err != nil {
+		return UsageStatement{}, fmt.Errorf("could not get usage statement of user<%s>: %v", u.Username, err)
+	}
+	if statement.Billed {
+		return statement, nil
+	}
+	if statement.Amount > 0 {
+		_, err = u.Charge(Money{Amount: statement.Amount, Currency: statement.Currency},
+			fmt.Sprintf("usage from %s to %s", statement.PeriodStart, statement.PeriodEnd),
+			"usage:"+statement.PeriodStart)
+		if err != nil {
+			return statement, err
+		}
+	}
+	_, err = db.Exec("UPDATE usage_statements SET billed = TRUE WHERE user = ? AND period_start = ?",
+		u.Username, usage.PeriodStart)
+	if err != nil {
+		return statement, fmt.Errorf("could not mark usage statement of user<%s> billed: %v", u.Username, err)
+	}
+	statement.Billed = true
+	return statement, nil
+}
+
+// UsageStatements returns the statements of this user's closed periods, latest first.
+func (u *User) UsageStatements(lim int) (statements []UsageStatement, err error) {
+	err = db.Select(&statements,
+		"SELECT * FROM usage_statements WHERE user = ? ORDER BY period_start DESC LIMIT ?", u.Username, lim)
+	if err != nil {
+		return statements, fmt.Errorf("could not get usage statements of user<%s>: %v", u.Username, err)
+	}
+	return statements, nil
+}
+
+// CloseUsagePeriods closes the billing period for every user it has not been closed for.
+// A user whose period cannot be closed is logged and left for the next run, without holding
+// up the rest.
+func CloseUsagePeriods(start, end time.Time) error {
+	var users []*User
+	err := db.Select(&users, `
+	SELECT users.* FROM users
+	LEFT JOIN usage_statements ON usage_statements.user = users.username AND usage_statements.period_start = ?
+	WHERE users.created < ? AND (usage_statements.user IS NULL OR NOT usage_statements.billed)`,
+		start.UTC().Format(utils.TimeFormat), end.UTC().Format(utils.TimeFormat))
+	if err != nil {
+		return fmt.Errorf("failed to list users for closing usage periods: %v", err)
+	}
+	failed := 0
+	for _, user := range users {
+		if _, err := user.CloseUsagePeriod(start, end); err != nil {
+			log.Print(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to close the usage period of %d of %d users", failed, len(users))
+	}
+	return nil
+}
+
+// StartMeteringJob closes the previous billing period every interval, billing any user
+// whose period has not been closed yet and issuing the statements of the period, until the
+// returned stop function is called.
+func StartMeteringJob(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				current, _ := BillingPeriodOf(time.Now())
+				start, end := BillingPeriodOf(current.Add(-time.Second))
+				// statements are issued even if some periods could not be closed, whose
+				// charges then go on the statement of the period they are billed in
+				if err := CloseUsagePeriods(start, end); err != nil {
+					log.Print(err)
+				}
+				if err := IssueStatements(start, end); err != nil {
+					log.Print(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mcctor/marauders/utils"
+)
+
+// Names of the plans a user can be subscribed to.
+const (
+	PlanFree     = "free"
+	PlanPro      = "pro"
+	PlanBusiness = "business"
+	// PlanGrandfathered is the plan of users who signed up before there were plans, who
+	// keep doing everything they could then.
+	PlanGrandfathered = "grandfathered"
+)
+
+// Plans are the limits of each plan. A limit of 0 means there is none.
+var Plans = map[string]Plan{
+	PlanFree: {
+		Name:                PlanFree,
+		MaxDevices:          2,
+		MaxCloaks:           1,
+		MaxMembersPerCloak:  5,
+		RetentionDays:       30,
+		MinSnapshotInterval: time.Minute,
+	},
+	PlanPro: {
+		Name:                PlanPro,
+		MaxDevices:          10,
+		MaxCloaks:           10,
+		MaxMembersPerCloak:  50,
+		RetentionDays:       365,
+		MinSnapshotInterval: 5 * time.Second,
+	},
+	PlanBusiness: {
+		Name: PlanBusiness,
+	},
+	PlanGrandfathered: {
+		Name: PlanGrandfathered,
+	},
+}
+
+// Plan limits what a user subscribed to it may do.
+type Plan struct {
+	Name string
+	// MaxDevices is how many devices the user may own.
+	MaxDevices int
+	// MaxCloaks is how many cloaks the user may create.
+	MaxCloaks int
+	// MaxMembersPerCloak is how many devices may join each cloak the user created.
+	MaxMembersPerCloak int
+	// RetentionDays is the longest the user may choose to keep the location history of
+	// their devices for. It never shortens a retention the user has not chosen.
+	RetentionDays int
+	// MinSnapshotInterval is how far apart the snapshots of each device have to be.
+	MinSnapshotInterval time.Duration
+}
+
+// Subscription is the period a user is subscribed to a plan for. Ends is empty for a
+// subscription that runs until it is replaced.
+type Subscription struct {
+	ID      int
+	User    string
+	Plan    string
+	Starts  string
+	Ends    sql.NullString
+	Created string
+}
+
+// PlanUsage is how much of the limits of their plan a user is using.
+type PlanUsage struct {
+	Devices int
+	Cloaks  int
+	// MostMembers is the membership of the user's largest cloak.
+	MostMembers   int
+	RetentionDays int
+}
+
+// ErrUpgradeRequired is matched by every *UpgradeRequiredError with errors.Is.
+var ErrUpgradeRequired = errors.New("upgrade required")
+
+// UpgradeRequiredError is returned when something would take a user past a limit of their
+// plan.
+type UpgradeRequiredError struct {
+	User string
+	Plan string
+	// Limit describes what the plan allows
+	Limit string
+}
+
+func (err *UpgradeRequiredError) Error() string {
+	return fmt.Sprintf("upgrade required: the %s plan of user<%s> allows %s", err.Plan, err.User, err.Limit)
+}
+
+func (err *UpgradeRequiredError) Is(target error) bool {
+	return target == ErrUpgradeRequired
+}
+
+// Subscribe subscribes this user to the plan from starts until ends, or until replaced by
+// a later subscription if ends is the zero time.
+func (u *User) Subscribe(plan string, starts, ends time.Time) (Subscription, error) {
+	if _, ok := Plans[plan]; !ok {
+		return Subscription{}, fmt.Errorf("no plan named %q", plan)
+	}
+	subscription := Subscription{User: u.Username, Plan: plan, Starts: starts.UTC().Format(utils.TimeFormat)}
+	if !ends.IsZero() {
+		if !ends.After(starts) {
+			return Subscription{}, fmt.Errorf("subscription of user<%s> should end after it starts", u.Username)
+		}
+		subscription.Ends = sql.NullString{String: ends.UTC().Format(utils.TimeFormat), Valid: true}
+	}
+	result, err := db.Exec("INSERT INTO user_plans (user, plan, starts, ends) VALUES (?, ?, ?, ?)",
+		subscription.User, subscription.Plan, subscription.Starts, subscription.Ends)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("could not subscribe user<%s> to plan %s: %v", u.Username, plan, err)
+	}
+	id, _ := result.LastInsertId()
+	subscription.ID = int(id)
+	return subscription, nil
+}
+
+// currentSubscriptionQuery selects the subscription of a user in effect at a time.
+const currentSubscriptionQuery = `
+	SELECT * FROM user_plans WHERE user = ? AND starts <= ? AND (ends IS NULL OR ends > ?)
+	ORDER BY id DESC LIMIT 1`
+
+// Subscription returns the subscription of this user in effect now, the latest made of
+// those that have started and not ended. The returned error wraps sql.ErrNoRows if there is
+// none, in which case the user signed up before there were plans and is grandfathered.
+func (u *User) Subscription() (subscription Subscription, err error) {
+	now := time.Now().UTC().Format(utils.TimeFormat)
+	err = db.Get(&subscription, currentSubscriptionQuery, u.Username, now, now)
+	if err != nil {
+		return subscription, fmt.Errorf("could not get subscription of user<%s>: %w", u.Username, err)
+	}
+	return subscription, nil
+}
+
+// Plan returns the plan this user is subscribed to now.
+func (u *User) Plan() (Plan, error) {
+	return planOf(u.Username)
+}
+
+// PlanUsage returns how much of the limits of their plan this user is using.
+func (u *User) PlanUsage() (usage PlanUsage, err error) {
+	err = db.Get(&usage.Devices, "SELECT COUNT(*) FROM devices WHERE user = ?", u.Username)
+	if err != nil {
+		return usage, fmt.Errorf("could not count devices of user<%s>: %v", u.Username, err)
+	}
+	err = db.Get(&usage.Cloaks, "SELECT COUNT(*) FROM cloaks WHERE user = ?", u.Username)
+	if err != nil {
+		return usage, fmt.Errorf("could not count cloaks of user<%s>: %v", u.Username, err)
+	}
+	err = db.Get(&usage.MostMembers, `
+	SELECT COALESCE(MAX(members), 0) FROM (
+		SELECT COUNT(*) AS members FROM associated_cloaks
+		INNER JOIN cloaks ON cloaks.id = associated_cloaks.cloak_id
+		WHERE cloaks.user = ? GROUP BY associated_cloaks.cloak_id
+	) AS memberships`, u.Username)
+	if err != nil {
+		return usage, fmt.Errorf("could not count cloak members of user<%s>: %v", u.Username, err)
+	}
+	usage.RetentionDays, _, err = u.Retention()
+	return usage, err
+}
+
+// planOf returns the plan the user with the passed username is subscribed to now.
+func planOf(username string) (Plan, error) {
+	subscription, err := (&User{Username: username}).Subscription()
+	return subscribedPlan(username, subscription, err)
+}
+
+// lockedPlanOf returns the plan the user with the passed username is subscribed to now,
+// locking the row of their subscription until the transaction ends.
+func lockedPlanOf(tx *sqlx.Tx, username string) (Plan, error) {
+	var subscription Subscription
+	now := time.Now().UTC().Format(utils.TimeFormat)
+	err := tx.Get(&subscription, currentSubscriptionQuery+" FOR UPDATE", username, now, now)
+	if err != nil {
+		err = fmt.Errorf("could not lock subscription of user<%s>: %w", username, err)
+	}
+	return subscribedPlan(username, subscription, err)
+}
+
+// subscribedPlan returns the plan of the subscription read for the user, or the
+// grandfathered plan if the user has none.
+func subscribedPlan(username string, subscription Subscription, err error) (Plan, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return Plans[PlanGrandfathered], nil
+	} else if err != nil {
+		return Plan{}, err
+	}
+	plan, ok := Plans[subscription.Plan]
+	if !ok {
+		return Plan{}, fmt.Errorf("user<%s> is subscribed to unknown plan %q", username, subscription.Plan)
+	}
+	return plan, nil
+}
+
+// insertWithinEntitlement runs insert in a transaction once the user is found to have fewer
+// than the limit of their plan picked by limitOf, counted by the query. describe names the
+// limit. The subscription of the user stays locked until the insert is committed, so that
+// concurrent requests are counted one after the other rather than all passing the limit. An
+// *UpgradeRequiredError is returned, and nothing inserted, if the user is at the limit.
+func insertWithinEntitlement(username string, limitOf func(Plan) int, describe string,
+	insert func(tx *sqlx.Tx) error, query string, args ...interface{}) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("could not check the plan limits of user<%s>: %v", username, err)
+	}
+	defer tx.Rollback()
+	plan, err := lockedPlanOf(tx, username)
+	if err != nil {
+		return err
+	}
+	if limit := limitOf(plan); limit != 0 {
+		var count int
+		if err := tx.Get(&count, query, args...); err != nil {
+			return fmt.Errorf("could not check the %s plan limits of user<%s>: %v", plan.Name, username, err)
+		}
+		if count >= limit {
+			return &UpgradeRequiredError{User: username, Plan: plan.Name, Limit: fmt.Sprintf("%d %s", limit, describe)}
+		}
+	}
+	if err := insert(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit within the %s plan limits of user<%s>: %v", plan.Name, username, err)
+	}
+	return nil
+}
+
+// checkSnapshotRate returns an *UpgradeRequiredError if the snapshot was taken closer to
+// the latest one of its device than the plan of the device's owner allows.
+func checkSnapshotRate(d Device, snapshot LocationSnapshot) error {
+	plan, err := planOf(d.User)
+	if err != nil {
+		return err
+	}
+	if plan.MinSnapshotInterval == 0 {
+		return nil
+	}
+	var latest []string
+	err = db.Select(&latest, "SELECT time_stamp FROM latest_locations WHERE device_id = ?", d.ID)
+	if err != nil {
+		return fmt.Errorf("could not check snapshot rate of device<%d>: %v", d.ID, err)
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+	previous, err := time.Parse(utils.TimeFormat, latest[0])
+	if err != nil {
+		return nil
+	}
+	recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+	if err != nil {
+		return nil
+	}
+	elapsed := recorded.Sub(previous)
+	if elapsed < 0 {
+		elapsed = -elapsed
+	}
+	if elapsed < plan.MinSnapshotInterval {
+		return &UpgradeRequiredError{User: d.User, Plan: plan.Name,
+			Limit: fmt.Sprintf("a snapshot every %v", plan.MinSnapshotInterval)}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	ProximityNear  = "near"
+	ProximityApart = "apart"
+
+	// proximityMaxAge is how old the latest position of another member may be, relative to
+	// a new snapshot, for the two to still be compared.
+	proximityMaxAge = 15 * time.Minute
+)
+
+type ProximityRule struct {
+	CloakID    string `db:"cloak_id"`
+	Distance   float64
+	Hysteresis float64
+	Created    string
+}
+
+type ProximityEvent struct {
+	ID            int    `db:"id"`
+	CloakID       string `db:"cloak_id"`
+	DeviceID      int    `db:"device_id"`
+	OtherDeviceID int    `db:"other_device_id"`
+	Kind          string
+	TimeStamp     string `db:"time_stamp"`
+	Created       string
+}
+
+// save commits the fields of the rule to the database, replacing any previous rule of its cloak.
+func (rule *ProximityRule) save() error {
+	_, err := db.Exec("REPLACE INTO cloak_proximity_rules (cloak_id, distance, hysteresis) VALUES (?, ?, ?)",
+		rule.CloakID, rule.Distance, rule.Hysteresis)
+	if err != nil {
+		return fmt.Errorf("failed to save proximity rule for cloak<%s>: %v", rule.CloakID, err)
+	}
+	return nil
+}
+
+// save commits the fields of a newly constructed proximity event to the database.
+func (event *ProximityEvent) save() error {
+	_, err := db.Exec(
+		`INSERT INTO proximity_events (cloak_id, device_id, other_device_id, kind, time_stamp)
+		VALUES (?, ?, ?, ?, ?)`,
+		event.CloakID, event.DeviceID, event.OtherDeviceID, event.Kind, event.TimeStamp)
+	if err != nil {
+		return fmt.Errorf("failed to save proximity event of devices<%d, %d> in cloak<%s>: %v",
+			event.DeviceID, event.OtherDeviceID, event.CloakID, err)
+	}
+	return nil
+}
+
+// evaluate compares the position in the passed snapshot with the latest position of every
+// other member of the cloak, recording an event when a pair has moved within the rule's
+// distance or apart from it by more than the hysteresis. Positions are obscured to the
+// cloak's accuracy first, so that alerts reveal no more than the cloak itself shares.
+func (rule *ProximityRule) evaluate(cloak *Cloak, snapshot LocationSnapshot) error {
+	if !cloak.Permits(snapshot) {
+		return nil
+	}
+	recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+	if err != nil {
+		return fmt.Errorf("failed to read time stamp of snapshot: %v", err)
+	}
+	members, err := cloak.Members()
+	if err != nil {
+		return err
+	}
+
+	position := pointOf(cloak.Obscure(snapshot))
+	for _, member := range members {
+		if member.ID == snapshot.DeviceID {
+			continue
+		}
+		latest, err := member.LatestLocationSnapshot()
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		latestRecorded, err := time.Parse(utils.TimeFormat, latest.TimeStamp)
+		if err != nil || recorded.Sub(latestRecorded) > proximityMaxAge || !cloak.Permits(latest) {
+			continue
+		}
+
+		distance := geo.Distance(position, pointOf(cloak.Obscure(latest)))
+		err = rule.updatePair(snapshot, member.ID, distance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePair records a change in the proximity state of the device of the snapshot and the
+// other device, given the distance between them.
+func (rule *ProximityRule) updatePair(snapshot LocationSnapshot, otherDeviceID int, distance float64) error {
+	// pairs are stored with the lower device id first so that each pair has a single state
+	first, second := snapshot.DeviceID, otherDeviceID
+	if first > second {
+		first, second = second, first
+	}
+	var near bool
+	err := db.Get(&near,
+		"SELECT near FROM proximity_states WHERE cloak_id = ? AND device_id = ? AND other_device_id = ?",
+		rule.CloakID, first, second)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get proximity state of devices<%d, %d>: %v", first, second, err)
+	}
+
+	var kind string
+	switch {
+	case !near && distance < rule.Distance-rule.Hysteresis:
+		kind = ProximityNear
+	case near && distance > rule.Distance+rule.Hysteresis:
+		kind = ProximityApart
+	default:
+		return nil
+	}
+
+	_, err = db.Exec(
+		"REPLACE INTO proximity_states (cloak_id, device_id, other_device_id, near) VALUES (?, ?, ?, ?)",
+		rule.CloakID, first, second, kind == ProximityNear)
+	if err != nil {
+		return fmt.Errorf("failed to save proximity state of devices<%d, %d>: %v", first, second, err)
+	}
+	event := &ProximityEvent{
+		CloakID:       rule.CloakID,
+		DeviceID:      snapshot.DeviceID,
+		OtherDeviceID: otherDeviceID,
+		Kind:          kind,
+		TimeStamp:     snapshot.TimeStamp,
+	}
+	err = event.save()
+	if err != nil {
+		return err
+	}
+	publish(ProximityChanged{Event: event})
+	return nil
+}
+
+// getProximityRuleFor returns the proximity rule configured for the cloak with the passed cloakID.
+func getProximityRuleFor(cloakID string) (rule *ProximityRule, err error) {
+	rule = &ProximityRule{}
+	err = db.Get(rule, "SELECT * FROM cloak_proximity_rules WHERE cloak_id = ?", cloakID)
+	if err != nil {
+		return rule, fmt.Errorf("failed to get proximity rule for cloak<%s>: %w", cloakID, err)
+	}
+	return rule, nil
+}
+
+// getProximityEventsFor returns the proximity events recorded in the cloak with the passed
+// cloakID, latest first.
+func getProximityEventsFor(cloakID string, lim int) (events []ProximityEvent, err error) {
+	err = db.Select(&events,
+		"SELECT * FROM proximity_events WHERE cloak_id = ? ORDER BY time_stamp DESC, id DESC LIMIT ?",
+		cloakID, lim)
+	if err != nil {
+		return events, fmt.Errorf("failed to get proximity events for cloak<%s>: %v", cloakID, err)
+	}
+	return events, nil
+}
+
+// evaluateProximity checks the members of every cloak with a proximity rule that the
+// device of a newly recorded snapshot has joined. Late snapshots are skipped.
+func evaluateProximity(event Event) {
+	recorded := event.(LocationSnapshotRecorded)
+	if recorded.Late {
+		return
+	}
+	snapshot := recorded.Snapshot
+	cloaks, err := Device{ID: snapshot.DeviceID}.AssociatedCloaks()
+	if err != nil {
+		log.Printf("could not evaluate proximity: %v", err)
+		return
+	}
+	for _, cloak := range cloaks {
+		rule, err := getProximityRuleFor(cloak.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			log.Printf("could not evaluate proximity: %v", err)
+			continue
+		}
+		if err := rule.evaluate(cloak, snapshot); err != nil {
+			log.Printf("could not evaluate proximity in cloak<%s>: %v", cloak.ID, err)
+		}
+	}
+}
+
+// pointOf returns the position of the snapshot as a point.
+func pointOf(snapshot LocationSnapshot) geo.Point {
+	return geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude}
+}
+
+func init() {
+	Subscribe(TopicLocationSnapshotRecorded, evaluateProximity)
+}
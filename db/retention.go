@@ -0,0 +1,264 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mcctor/marauders/utils"
+)
+
+const day = 24 * time.Hour
+
+// Retention holds the settings of the job purging expired location history.
+var Retention = RetentionConfig{
+	Default:    0,
+	BatchSize:  1000,
+	BatchPause: 50 * time.Millisecond,
+}
+
+// RetentionConfig sets how long location history is kept and how it is purged.
+type RetentionConfig struct {
+	// Default is how long history is kept for users who have not set their own period. It
+	// is 0 unless configured, keeping history forever until users choose otherwise.
+	Default time.Duration
+	// BatchSize is how many snapshots are purged at a time, keeping each delete short so
+	// that it does not hold up ingest.
+	BatchSize int
+	// BatchPause is how long the job waits between batches.
+	BatchPause time.Duration
+	// Archive moves expired snapshots to the location_snapshots_archive table instead of
+	// deleting them outright.
+	Archive bool
+}
+
+// RetentionRun records the purge of the expired history of a device.
+type RetentionRun struct {
+	ID       int
+	DeviceID int `db:"device_id"`
+	User     string
+	Cutoff   string
+	Purged   int
+	Archived bool
+	Ran      string
+}
+
+// SetRetention sets how many days the location history of this user's devices is kept. An
+// *UpgradeRequiredError is returned if that is longer than the user's plan allows.
+func (u *User) SetRetention(days int) error {
+	if days <= 0 {
+		return fmt.Errorf("retention for user<%s> should be at least a day", u.Username)
+	}
+	plan, err := u.Plan()
+	if err != nil {
+		return err
+	}
+	if plan.RetentionDays != 0 && days > plan.RetentionDays {
+		return &UpgradeRequiredError{User: u.Username, Plan: plan.Name,
+			Limit: fmt.Sprintf("%d days of history", plan.RetentionDays)}
+	}
+	_, err = db.Exec("REPLACE INTO user_retention (user, days) VALUES (?, ?)", u.Username, days)
+	if err != nil {
+		return fmt.Errorf("could not set retention for user<%s>: %v", u.Username, err)
+	}
+	return nil
+}
+
+// Retention returns how many days the location history of this user's devices is kept,
+// and whether that is the user's own setting rather than the system default. 0 days means
+// it is kept forever.
+func (u *User) Retention() (days int, custom bool, err error) {
+	err = db.Get(&days, "SELECT days FROM user_retention WHERE user = ?", u.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return int(Retention.Default / day), false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("could not get retention for user<%s>: %v", u.Username, err)
+	}
+	return days, true, nil
+}
+
+// ClearRetention returns this user to the system default retention.
+func (u *User) ClearRetention() error {
+	_, err := db.Exec("DELETE FROM user_retention WHERE user = ?", u.Username)
+	if err != nil {
+		return fmt.Errorf("could not clear retention for user<%s>: %v", u.Username, err)
+	}
+	return nil
+}
+
+// RetentionRuns returns the latest purges of this user's expired history.
+func (u *User) RetentionRuns(lim int) (runs []RetentionRun, err error) {
+	err = db.Select(&runs, "SELECT * FROM retention_runs WHERE user = ? ORDER BY ran DESC, id DESC LIMIT ?",
+		u.Username, lim)
+	if err != nil {
+		return runs, fmt.Errorf("could not get retention runs for user<%s>: %v", u.Username, err)
+	}
+	return runs, nil
+}
+
+// SetMaxRetention caps how many days the history of the members of this cloak owned by its
+// creator is kept, whatever their own retention is. The history of other members is theirs
+// to keep, so it is left alone.
+func (c *Cloak) SetMaxRetention(days int) error {
+	if days <= 0 {
+		return fmt.Errorf("retention for cloak<%s> should be at least a day", c.ID)
+	}
+	_, err := db.Exec("REPLACE INTO cloak_retention (cloak_id, days) VALUES (?, ?)", c.ID, days)
+	if err != nil {
+		return fmt.Errorf("could not set retention for cloak<%s>: %v", c.ID, err)
+	}
+	return nil
+}
+
+// MaxRetention returns the retention cap of this cloak in days. The returned error wraps
+// sql.ErrNoRows if the cloak has none.
+func (c *Cloak) MaxRetention() (days int, err error) {
+	err = db.Get(&days, "SELECT days FROM cloak_retention WHERE cloak_id = ?", c.ID)
+	if err != nil {
+		return 0, fmt.Errorf("could not get retention for cloak<%s>: %w", c.ID, err)
+	}
+	return days, nil
+}
+
+// RemoveMaxRetention lifts the retention cap of this cloak.
+func (c *Cloak) RemoveMaxRetention() error {
+	_, err := db.Exec("DELETE FROM cloak_retention WHERE cloak_id = ?", c.ID)
+	if err != nil {
+		return fmt.Errorf("could not remove retention for cloak<%s>: %v", c.ID, err)
+	}
+	return nil
+}
+
+// RetentionPeriod returns how long the history of this device is kept: its owner's
+// retention, cut down to the cap of any cloak its owner created and it has joined. 0 means
+// it is kept forever.
+func (d Device) RetentionPeriod() (time.Duration, error) {
+	owner := &User{Username: d.User}
+	days, _, err := owner.Retention()
+	if err != nil {
+		return 0, err
+	}
+	var caps []int
+	err = db.Select(&caps, `
+	SELECT cloak_retention.days FROM cloak_retention
+	INNER JOIN associated_cloaks ON associated_cloaks.cloak_id = cloak_retention.cloak_id
+	INNER JOIN cloaks ON cloaks.id = cloak_retention.cloak_id
+	WHERE associated_cloaks.device_id = ? AND cloaks.user = ?`, d.ID, d.User)
+	if err != nil {
+		return 0, fmt.Errorf("could not get retention caps for device<%d>: %v", d.ID, err)
+	}
+	for _, cap := range caps {
+		if days == 0 || cap < days {
+			days = cap
+		}
+	}
+	return time.Duration(days) * day, nil
+}
+
+// PurgeExpiredSnapshots purges the history of every device older than its retention
+// period, returning how many snapshots were purged in all. Devices whose history is kept
+// forever are skipped.
+func PurgeExpiredSnapshots() (int, error) {
+	var devices []Device
+	if err := db.Select(&devices, "SELECT * FROM devices"); err != nil {
+		return 0, fmt.Errorf("failed to list devices for retention: %v", err)
+	}
+	total := 0
+	for _, device := range devices {
+		period, err := device.RetentionPeriod()
+		if err != nil {
+			return total, err
+		}
+		if period == 0 {
+			continue
+		}
+		cutoff := time.Now().UTC().Add(-period).Format(utils.TimeFormatMilli)
+		purged, err := device.purgeBefore(cutoff)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// purgeBefore purges the snapshots of this device recorded before the cutoff, a batch at
+// a time, and records the run if anything was purged.
+func (d Device) purgeBefore(cutoff string) (int, error) {
+	purged := 0
+	for {
+		var stamps []string
+		err := db.Select(&stamps,
+			"SELECT time_stamp FROM location_snapshots WHERE device_id = ? AND time_stamp < ? ORDER BY time_stamp LIMIT ?",
+			d.ID, cutoff, Retention.BatchSize)
+		if err != nil {
+			return purged, fmt.Errorf("failed to find expired snapshots of device<%d>: %v", d.ID, err)
+		}
+		if len(stamps) == 0 {
+			break
+		}
+		// the batch is the oldest rows, so it ends at the last time stamp selected
+		last := stamps[len(stamps)-1]
+		if Retention.Archive {
+			_, err = db.Exec(`
+			INSERT IGNORE INTO location_snapshots_archive
+				(device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing, battery, provider,
+				 received, geohash)
+			SELECT device_id, time_stamp, latitude, longitude, altitude, accuracy, speed, bearing, battery, provider,
+				received, geohash
+			FROM location_snapshots WHERE device_id = ? AND time_stamp <= ?`, d.ID, last)
+			if err != nil {
+				return purged, fmt.Errorf("failed to archive expired snapshots of device<%d>: %v", d.ID, err)
+			}
+		}
+		result, err := db.Exec("DELETE FROM location_snapshots WHERE device_id = ? AND time_stamp <= ?", d.ID, last)
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge expired snapshots of device<%d>: %v", d.ID, err)
+		}
+		deleted, _ := result.RowsAffected()
+		purged += int(deleted)
+		if len(stamps) < Retention.BatchSize {
+			break
+		}
+		time.Sleep(Retention.BatchPause)
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	_, err := db.Exec("DELETE FROM latest_locations WHERE device_id = ? AND time_stamp < ?", d.ID, cutoff)
+	if err != nil {
+		return purged, fmt.Errorf("failed to purge expired latest location of device<%d>: %v", d.ID, err)
+	}
+	_, err = db.Exec("INSERT INTO retention_runs (device_id, user, cutoff, purged, archived) VALUES (?, ?, ?, ?, ?)",
+		d.ID, d.User, cutoff, purged, Retention.Archive)
+	if err != nil {
+		return purged, fmt.Errorf("failed to record retention run for device<%d>: %v", d.ID, err)
+	}
+	return purged, nil
+}
+
+// StartRetentionJob purges expired history every interval until the returned stop
+// function is called.
+func StartRetentionJob(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if purged, err := PurgeExpiredSnapshots(); err != nil {
+					log.Print(err)
+				} else if purged > 0 {
+					log.Printf("retention purged %d expired location snapshots", purged)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"log"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
@@ -41,15 +43,6 @@ CREATE TABLE IF NOT EXISTS auth_tokens (
 	CONSTRAINT fk_auth_tokens_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE 
 );
 
-CREATE TABLE IF NOT EXISTS billings (
-	time_stamp DATETIME,
-	user VARCHAR(20),
-	debit FLOAT NOT NULL DEFAULT 0.0,
-	credit FLOAT NOT NULL DEFAULT 0.0,
-	CONSTRAINT pk_billings PRIMARY KEY (user, time_stamp),
-	CONSTRAINT fk_billings_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
-);
-
 CREATE TABLE IF NOT EXISTS devices (
 	id INT,
 	user VARCHAR(20),
@@ -60,10 +53,19 @@ CREATE TABLE IF NOT EXISTS devices (
 
 CREATE TABLE IF NOT EXISTS location_snapshots (
 	device_id INT,
-	time_stamp DATETIME,
+	time_stamp DATETIME(3),
 	latitude FLOAT NOT NULL,
 	longitude FLOAT NOT NULL,
+	altitude FLOAT,
+	accuracy FLOAT,
+	speed FLOAT,
+	bearing FLOAT,
+	battery TINYINT,
+	provider ENUM('gps', 'network', 'fused'),
+	received DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+	geohash VARCHAR(12) NOT NULL DEFAULT '',
 	CONSTRAINT pk_location_snapshots PRIMARY KEY (device_id, time_stamp),
+	INDEX idx_location_snapshots_geohash (geohash),
 	CONSTRAINT fk_location_snapshots_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
 );
 
@@ -121,14 +123,304 @@ CREATE TABLE IF NOT EXISTS cloak_invite_links (
 	CONSTRAINT pk_cloak_invite_link_creator FOREIGN KEY (created_by) REFERENCES users (username) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS geofences (
+	id VARCHAR(20),
+	user VARCHAR(20) NOT NULL,
+	cloak_id VARCHAR(20),
+	name VARCHAR(40) NOT NULL,
+	kind ENUM('circle', 'polygon') NOT NULL,
+	latitude FLOAT,
+	longitude FLOAT,
+	radius FLOAT,
+	vertices MEDIUMTEXT,
+	hysteresis FLOAT NOT NULL DEFAULT 25.0,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_geofences PRIMARY KEY (id),
+	CONSTRAINT fk_geofences_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE,
+	CONSTRAINT fk_geofences_cloak FOREIGN KEY (cloak_id) REFERENCES cloaks (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS geofence_states (
+	geofence_id VARCHAR(20),
+	device_id INT,
+	inside BOOLEAN NOT NULL DEFAULT FALSE,
+	modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	CONSTRAINT pk_geofence_states PRIMARY KEY (geofence_id, device_id),
+	CONSTRAINT fk_geofence_states_geofence FOREIGN KEY (geofence_id) REFERENCES geofences (id) ON DELETE CASCADE,
+	CONSTRAINT fk_geofence_states_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS geofence_events (
+	id INT AUTO_INCREMENT,
+	geofence_id VARCHAR(20) NOT NULL,
+	device_id INT NOT NULL,
+	kind ENUM('enter', 'exit') NOT NULL,
+	time_stamp DATETIME NOT NULL,
+	latitude FLOAT NOT NULL,
+	longitude FLOAT NOT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_geofence_events PRIMARY KEY (id),
+	CONSTRAINT fk_geofence_events_geofence FOREIGN KEY (geofence_id) REFERENCES geofences (id) ON DELETE CASCADE,
+	CONSTRAINT fk_geofence_events_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cloak_proximity_rules (
+	cloak_id VARCHAR(20),
+	distance FLOAT NOT NULL,
+	hysteresis FLOAT NOT NULL DEFAULT 25.0,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_cloak_proximity_rules PRIMARY KEY (cloak_id),
+	CONSTRAINT fk_cloak_proximity_rules_cloak FOREIGN KEY (cloak_id) REFERENCES cloaks (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS proximity_states (
+	cloak_id VARCHAR(20),
+	device_id INT,
+	other_device_id INT,
+	near BOOLEAN NOT NULL DEFAULT FALSE,
+	modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	CONSTRAINT pk_proximity_states PRIMARY KEY (cloak_id, device_id, other_device_id),
+	CONSTRAINT fk_proximity_states_cloak FOREIGN KEY (cloak_id) REFERENCES cloaks (id) ON DELETE CASCADE,
+	CONSTRAINT fk_proximity_states_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE,
+	CONSTRAINT fk_proximity_states_other_device FOREIGN KEY (other_device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS proximity_events (
+	id INT AUTO_INCREMENT,
+	cloak_id VARCHAR(20) NOT NULL,
+	device_id INT NOT NULL,
+	other_device_id INT NOT NULL,
+	kind ENUM('near', 'apart') NOT NULL,
+	time_stamp DATETIME NOT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_proximity_events PRIMARY KEY (id),
+	CONSTRAINT fk_proximity_events_cloak FOREIGN KEY (cloak_id) REFERENCES cloaks (id) ON DELETE CASCADE,
+	CONSTRAINT fk_proximity_events_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE,
+	CONSTRAINT fk_proximity_events_other_device FOREIGN KEY (other_device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS rejected_snapshots (
+	id INT AUTO_INCREMENT,
+	device_id INT NOT NULL,
+	time_stamp VARCHAR(40) NOT NULL,
+	latitude DOUBLE NOT NULL,
+	longitude DOUBLE NOT NULL,
+	reason VARCHAR(20) NOT NULL,
+	received TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_rejected_snapshots PRIMARY KEY (id),
+	CONSTRAINT fk_rejected_snapshots_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS device_credentials (
+	device_id INT,
+	hash VARCHAR(64) UNIQUE NOT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_device_credentials PRIMARY KEY (device_id),
+	CONSTRAINT fk_device_credentials_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS device_identifiers (
+	protocol VARCHAR(20),
+	identifier VARCHAR(64),
+	device_id INT NOT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_device_identifiers PRIMARY KEY (protocol, identifier),
+	CONSTRAINT fk_device_identifiers_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS latest_locations (
+	device_id INT,
+	time_stamp DATETIME(3) NOT NULL,
+	latitude DOUBLE NOT NULL,
+	longitude DOUBLE NOT NULL,
+	altitude FLOAT,
+	accuracy FLOAT,
+	speed FLOAT,
+	bearing FLOAT,
+	battery TINYINT,
+	provider ENUM('gps', 'network', 'fused'),
+	received DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+	geohash VARCHAR(12) NOT NULL,
+	CONSTRAINT pk_latest_locations PRIMARY KEY (device_id),
+	INDEX idx_latest_locations_geohash (geohash),
+	CONSTRAINT fk_latest_locations_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_retention (
+	user VARCHAR(20),
+	days INT NOT NULL,
+	modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	CONSTRAINT pk_user_retention PRIMARY KEY (user),
+	CONSTRAINT fk_user_retention_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cloak_retention (
+	cloak_id VARCHAR(20),
+	days INT NOT NULL,
+	modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	CONSTRAINT pk_cloak_retention PRIMARY KEY (cloak_id),
+	CONSTRAINT fk_cloak_retention_cloak FOREIGN KEY (cloak_id) REFERENCES cloaks (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS location_snapshots_archive (
+	device_id INT,
+	time_stamp DATETIME(3),
+	latitude FLOAT NOT NULL,
+	longitude FLOAT NOT NULL,
+	altitude FLOAT,
+	accuracy FLOAT,
+	speed FLOAT,
+	bearing FLOAT,
+	battery TINYINT,
+	provider ENUM('gps', 'network', 'fused'),
+	received DATETIME(3) NOT NULL,
+	geohash VARCHAR(12) NOT NULL DEFAULT '',
+	archived TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_location_snapshots_archive PRIMARY KEY (device_id, time_stamp),
+	CONSTRAINT fk_location_snapshots_archive_device FOREIGN KEY (device_id) REFERENCES devices (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS retention_runs (
+	id INT AUTO_INCREMENT,
+	device_id INT NOT NULL,
+	user VARCHAR(20) NOT NULL,
+	cutoff DATETIME(3) NOT NULL,
+	purged INT NOT NULL,
+	archived BOOLEAN NOT NULL,
+	ran TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_retention_runs PRIMARY KEY (id),
+	CONSTRAINT fk_retention_runs_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS usage_statements (
+	user VARCHAR(20),
+	period_start DATETIME,
+	period_end DATETIME NOT NULL,
+	snapshots INT NOT NULL,
+	active_devices INT NOT NULL,
+	cloaks INT NOT NULL,
+	members INT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency CHAR(3) NOT NULL,
+	billed BOOLEAN NOT NULL DEFAULT FALSE,
+	closed TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_usage_statements PRIMARY KEY (user, period_start),
+	CONSTRAINT fk_usage_statements_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+	id INT AUTO_INCREMENT,
+	user VARCHAR(20) NOT NULL,
+	type ENUM('charge', 'payment', 'credit', 'refund', 'adjustment') NOT NULL,
+	description VARCHAR(255) NOT NULL,
+	amount BIGINT NOT NULL,
+	currency CHAR(3) NOT NULL,
+	idempotency_key VARCHAR(64) NOT NULL,
+	created DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+	CONSTRAINT pk_ledger_transactions PRIMARY KEY (id),
+	CONSTRAINT uq_ledger_transactions_key UNIQUE (user, idempotency_key),
+	CONSTRAINT fk_ledger_transactions_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id INT AUTO_INCREMENT,
+	transaction_id INT NOT NULL,
+	account VARCHAR(30) NOT NULL,
+	amount BIGINT NOT NULL,
+	currency CHAR(3) NOT NULL,
+	CONSTRAINT pk_ledger_entries PRIMARY KEY (id),
+	INDEX idx_ledger_entries_account (account, currency),
+	CONSTRAINT fk_ledger_entries_transaction FOREIGN KEY (transaction_id) REFERENCES ledger_transactions (id)
+		ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_plans (
+	id INT AUTO_INCREMENT,
+	user VARCHAR(20) NOT NULL,
+	plan VARCHAR(20) NOT NULL,
+	starts DATETIME NOT NULL,
+	ends DATETIME,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_user_plans PRIMARY KEY (id),
+	INDEX idx_user_plans_user (user, starts),
+	CONSTRAINT fk_user_plans_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS statement_sequence (
+	id TINYINT,
+	next INT NOT NULL,
+	CONSTRAINT pk_statement_sequence PRIMARY KEY (id)
+);
+
+INSERT IGNORE INTO statement_sequence (id, next) VALUES (1, 1);
+
+CREATE TABLE IF NOT EXISTS statements (
+	number INT,
+	user VARCHAR(20) NOT NULL,
+	period_start DATETIME NOT NULL,
+	period_end DATETIME NOT NULL,
+	currency CHAR(3) NOT NULL,
+	opening BIGINT NOT NULL,
+	closing BIGINT NOT NULL,
+	issued TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT pk_statements PRIMARY KEY (number),
+	CONSTRAINT uq_statements_period UNIQUE (user, period_start, currency)
+);
+
+CREATE TABLE IF NOT EXISTS statement_lines (
+	statement_number INT,
+	line INT,
+	transaction_id INT NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	description VARCHAR(255) NOT NULL,
+	amount BIGINT NOT NULL,
+	posted DATETIME(3) NOT NULL,
+	CONSTRAINT pk_statement_lines PRIMARY KEY (statement_number, line),
+	CONSTRAINT fk_statement_lines_statement FOREIGN KEY (statement_number) REFERENCES statements (number)
+);
+
+CREATE TABLE IF NOT EXISTS payment_checkouts (
+	provider VARCHAR(20),
+	id VARCHAR(64),
+	user VARCHAR(20) NOT NULL,
+	amount BIGINT NOT NULL,
+	currency CHAR(3) NOT NULL,
+	url VARCHAR(255) NOT NULL,
+	status VARCHAR(10) NOT NULL DEFAULT 'open',
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	settled TIMESTAMP NULL,
+	transaction_id INT,
+	CONSTRAINT pk_payment_checkouts PRIMARY KEY (provider, id),
+	CONSTRAINT fk_payment_checkouts_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_usage (
+	user VARCHAR(20),
+	device_id INT,
+	period_start DATETIME,
+	snapshots INT NOT NULL,
+	CONSTRAINT pk_snapshot_usage PRIMARY KEY (user, period_start, device_id),
+	CONSTRAINT fk_snapshot_usage_user FOREIGN KEY (user) REFERENCES users (username) ON DELETE CASCADE
+);
+
 `
 )
 
 func init() {
 	db = sqlx.MustConnect(
 		"mysql",
-		"mcctor:@lienmwanga01@(localhost:3306)/marauders?multiStatements=True")
+		// time stamps the database sets itself are in UTC, like those the server writes
+		"mcctor:@lienmwanga01@(localhost:3306)/marauders?multiStatements=True&time_zone=%27%2B00%3A00%27")
 	db.MustExec(schema)
+	if err := migrateLocationSnapshots(); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrateSnapshotGeohashes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrateLegacyBillings(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // ChangeDB should only be used for hooking up a test database
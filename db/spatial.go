@@ -0,0 +1,244 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mcctor/marauders/geo"
+)
+
+const (
+	// geohashPrecision is the length of the geohashes snapshots are indexed by, giving
+	// cells of about 5 metres.
+	geohashPrecision = 9
+	// maxCoveringCells bounds how many geohash prefixes a spatial query matches against.
+	maxCoveringCells = 16
+	// geohashBackfillBatch is how many snapshots saved without a geohash are given one at a
+	// time.
+	geohashBackfillBatch = 1000
+	// nearestFirstRadius is the radius in metres the search for the nearest members starts
+	// out with, before widening it.
+	nearestFirstRadius = 1000.0
+)
+
+// MemberLocation is the latest position of a cloak member as the cloak allows it to be
+// seen, along with how far it is from the point a spatial query was made around.
+type MemberLocation struct {
+	Device   Device
+	Snapshot LocationSnapshot
+	Distance float64
+}
+
+func geohashOf(snapshot LocationSnapshot) string {
+	return geo.Geohash(pointOf(snapshot), geohashPrecision)
+}
+
+// geohashFilter returns an SQL condition matching the rows of the passed geohash column
+// that lie in one of the cells covering the box, along with its arguments. Cells overlap
+// the edges of the box, so matches still have to be checked against it.
+func geohashFilter(column string, box geo.Box) (string, []interface{}) {
+	cells := geo.CoveringGeohashes(box, maxCoveringCells)
+	conditions := make([]string, len(cells))
+	args := make([]interface{}, len(cells))
+	for i, cell := range cells {
+		conditions[i] = column + " LIKE ?"
+		args[i] = cell + "%"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// LocationSnapshotsWithin returns the snapshots of the devices owned by this user that lie
+// within the box and were recorded between the from and to time stamps, oldest first.
+func (u *User) LocationSnapshotsWithin(box geo.Box, from, to string, lim int) ([]LocationSnapshot, error) {
+	filter, args := geohashFilter("location_snapshots.geohash", box)
+	query := `
+	SELECT location_snapshots.* FROM location_snapshots
+	INNER JOIN devices ON devices.id = location_snapshots.device_id
+	WHERE devices.user = ? AND location_snapshots.time_stamp >= ? AND location_snapshots.time_stamp < ?
+		AND location_snapshots.latitude BETWEEN ? AND ? AND location_snapshots.longitude BETWEEN ? AND ?
+		AND ` + filter + `
+	ORDER BY location_snapshots.time_stamp LIMIT ?
+`
+	args = append([]interface{}{u.Username, from, to, box.South, box.North, box.West, box.East}, args...)
+	var snapshots []LocationSnapshot
+	err := db.Select(&snapshots, query, append(args, lim)...)
+	if err != nil {
+		return snapshots, fmt.Errorf("failed to get snapshots of user<%s> within %v: %v", u.Username, box, err)
+	}
+	return snapshots, nil
+}
+
+// EachLocationSnapshotForMemberWithin calls fn with each location snapshot of the passed
+// device recorded between the from and to time stamps that this cloak allows to be seen and
+// lies within the box, oldest first and obscured as the cloak requires. Snapshots are found
+// through the geohash index rather than by reading the member's whole history, so fn is also
+// told whether each one follows on from the one before it, with none left out in between.
+func (c *Cloak) EachLocationSnapshotForMemberWithin(device Device, box geo.Box, from, to string,
+	fn func(snapshot LocationSnapshot, follows bool) error) error {
+	// snapshots just outside the box may be obscured into it
+	if decimals, ok := accuracyDecimals[c.Accuracy]; ok {
+		margin := 0.5 * math.Pow(10, -float64(decimals))
+		box = box.Widen(margin, margin)
+	}
+	filter, args := geohashFilter("location_snapshots.geohash", box)
+	query := `
+	SELECT location_snapshots.*, (
+		SELECT MAX(previous.time_stamp) FROM location_snapshots AS previous
+		WHERE previous.device_id = location_snapshots.device_id AND previous.time_stamp < location_snapshots.time_stamp
+	) AS previous
+	FROM location_snapshots
+	WHERE location_snapshots.device_id = ? AND location_snapshots.time_stamp >= ? AND location_snapshots.time_stamp < ?
+		AND location_snapshots.latitude BETWEEN ? AND ? AND location_snapshots.longitude BETWEEN ? AND ?
+		AND ` + filter + `
+	ORDER BY location_snapshots.time_stamp
+`
+	args = append([]interface{}{device.ID, from, to, box.South, box.North, box.West, box.East}, args...)
+	var found []struct {
+		LocationSnapshot
+		Previous sql.NullString
+	}
+	err := db.Select(&found, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots of device<%d> within %v: %v", device.ID, box, err)
+	}
+	last := ""
+	for _, snapshot := range found {
+		if !c.Permits(snapshot.LocationSnapshot) {
+			continue
+		}
+		follows := last != "" && snapshot.Previous.Valid && normalizeTimeStamp(snapshot.Previous.String) == last
+		if err := fn(c.Obscure(snapshot.LocationSnapshot), follows); err != nil {
+			return err
+		}
+		last = normalizeTimeStamp(snapshot.TimeStamp)
+	}
+	return nil
+}
+
+// MembersWithin returns the members of this cloak whose latest visible position is within
+// the radius in metres of the center, nearest first. Members are only ever matched by the
+// position the cloak allows to be seen, obscured and within its schedule, so that where a
+// query finds them gives nothing more away.
+func (c *Cloak) MembersWithin(center geo.Point, radius float64) ([]MemberLocation, error) {
+	members, err := c.Members()
+	if err != nil {
+		return nil, fmt.Errorf("could not get members of cloak<%s> near %v: %v", c.ID, center, err)
+	}
+	membersByID := make(map[int]Device, len(members))
+	for _, member := range members {
+		membersByID[member.ID] = member
+	}
+	visible, err := c.latestLocationsOf(members)
+	if err != nil {
+		return nil, err
+	}
+
+	var nearby []MemberLocation
+	for _, snapshot := range visible {
+		distance := geo.Distance(center, pointOf(snapshot))
+		if distance <= radius {
+			nearby = append(nearby, MemberLocation{Device: membersByID[snapshot.DeviceID], Snapshot: snapshot,
+				Distance: distance})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].Distance < nearby[j].Distance })
+	return nearby, nil
+}
+
+// NearestMembers returns up to n members of this cloak nearest to the center by their
+// latest visible position, nearest first. The search widens from a kilometre around the
+// center until enough members are found or it spans the globe.
+func (c *Cloak) NearestMembers(center geo.Point, n int) ([]MemberLocation, error) {
+	for radius := nearestFirstRadius; ; radius *= 4 {
+		nearby, err := c.MembersWithin(center, radius)
+		if err != nil {
+			return nil, err
+		}
+		if len(nearby) >= n || radius > math.Pi*geo.EarthRadius {
+			if len(nearby) > n {
+				nearby = nearby[:n]
+			}
+			return nearby, nil
+		}
+	}
+}
+
+// migrateSnapshotGeohashes indexes location_snapshots tables created before snapshots were
+// given geohashes, adding the column and its index if they are missing. The snapshots saved
+// without one are then given their geohash a batch at a time, so none are left out of the
+// spatial queries. It does nothing once every snapshot has a geohash.
+func migrateSnapshotGeohashes() error {
+	var columns int
+	err := db.Get(&columns, `
+	SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'location_snapshots' AND COLUMN_NAME = 'geohash'`)
+	if err != nil {
+		return fmt.Errorf("could not look for the geohash column of location_snapshots: %v", err)
+	}
+	if columns == 0 {
+		_, err := db.Exec("ALTER TABLE location_snapshots ADD COLUMN geohash VARCHAR(12) NOT NULL DEFAULT ''")
+		if err != nil {
+			return fmt.Errorf("could not add the geohash column to location_snapshots: %v", err)
+		}
+	}
+	var indexes int
+	err = db.Get(&indexes, `
+	SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'location_snapshots'
+		AND INDEX_NAME = 'idx_location_snapshots_geohash'`)
+	if err != nil {
+		return fmt.Errorf("could not look for the geohash index of location_snapshots: %v", err)
+	}
+	if indexes == 0 {
+		_, err := db.Exec("CREATE INDEX idx_location_snapshots_geohash ON location_snapshots (geohash)")
+		if err != nil {
+			return fmt.Errorf("could not index the geohashes of location_snapshots: %v", err)
+		}
+	}
+	backfilled := 0
+	for {
+		var snapshots []LocationSnapshot
+		err := db.Select(&snapshots, `
+		SELECT device_id, time_stamp, latitude, longitude FROM location_snapshots
+			WHERE geohash = '' LIMIT ?`, geohashBackfillBatch)
+		if err != nil {
+			return fmt.Errorf("could not read snapshots without a geohash: %v", err)
+		}
+		if err := backfillGeohashes(snapshots); err != nil {
+			return err
+		}
+		backfilled += len(snapshots)
+		if len(snapshots) < geohashBackfillBatch {
+			break
+		}
+	}
+	if backfilled > 0 {
+		log.Printf("gave %d location snapshots their geohash", backfilled)
+	}
+	return nil
+}
+
+// backfillGeohashes saves the geohash of each of the snapshots in a single transaction.
+func backfillGeohashes(snapshots []LocationSnapshot) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("could not backfill geohashes: %v", err)
+	}
+	defer tx.Rollback()
+	for _, snapshot := range snapshots {
+		_, err := tx.Exec("UPDATE location_snapshots SET geohash = ? WHERE device_id = ? AND time_stamp = ?",
+			geohashOf(snapshot), snapshot.DeviceID, snapshot.TimeStamp)
+		if err != nil {
+			return fmt.Errorf("could not backfill geohash of snapshot at <%s> for device<%d>: %v",
+				snapshot.TimeStamp, snapshot.DeviceID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not backfill geohashes: %v", err)
+	}
+	return nil
+}
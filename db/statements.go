@@ -0,0 +1,202 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mcctor/marauders/utils"
+)
+
+// Statement is a user's ledger over a billing period in one currency, issued as an invoice.
+// Statements are numbered in the order they are issued, without gaps, and are never
+// changed once issued. They are kept even if the user is deleted.
+type Statement struct {
+	Number      int
+	User        string
+	PeriodStart string `db:"period_start"`
+	PeriodEnd   string `db:"period_end"`
+	Currency    string
+	// Opening is what the user owed when the period started and Closing what they owed
+	// when it ended, in minor units.
+	Opening int64
+	Closing int64
+	Issued  string
+	// Lines are only filled in by User.Statement and IssueStatement
+	Lines []StatementLine `db:"-"`
+}
+
+// StatementLine is a transaction posted to the user's account during the period of a
+// statement. Its amount is what it added to what the user owes, so payments and credits
+// are negative.
+type StatementLine struct {
+	StatementNumber int `db:"statement_number"`
+	Line            int
+	TransactionID   int `db:"transaction_id"`
+	Type            string
+	Description     string
+	Amount          int64
+	Posted          string
+}
+
+// IssueStatement issues the statement of this user's ledger between start and end in the
+// currency. A statement that was already issued for the period is returned as it was.
+func (u *User) IssueStatement(start, end time.Time, currency string) (Statement, error) {
+	opening, lines, err := u.ledgerBetween(start, end, currency)
+	if err != nil {
+		return Statement{}, err
+	}
+	return u.issueStatement(start, end, currency, opening, lines)
+}
+
+// Statements returns the statements issued to this user, latest first.
+func (u *User) Statements(lim int) (statements []Statement, err error) {
+	err = db.Select(&statements, "SELECT * FROM statements WHERE user = ? ORDER BY number DESC LIMIT ?",
+		u.Username, lim)
+	if err != nil {
+		return statements, fmt.Errorf("could not get statements of user<%s>: %v", u.Username, err)
+	}
+	return statements, nil
+}
+
+// Statement returns the statement of this user with the passed number along with its lines.
+// The returned error wraps sql.ErrNoRows if there is none.
+func (u *User) Statement(number int) (statement Statement, err error) {
+	err = db.Get(&statement, "SELECT * FROM statements WHERE number = ? AND user = ?", number, u.Username)
+	if err != nil {
+		return statement, fmt.Errorf("could not get statement<%d> of user<%s>: %w", number, u.Username, err)
+	}
+	err = db.Select(&statement.Lines,
+		"SELECT * FROM statement_lines WHERE statement_number = ? ORDER BY line", number)
+	if err != nil {
+		return statement, fmt.Errorf("could not get lines of statement<%d>: %v", number, err)
+	}
+	return statement, nil
+}
+
+// IssueStatements issues the statement between start and end of every user with something
+// to state in each currency they were billed in: a balance carried over or a transaction
+// posted during the period.
+func IssueStatements(start, end time.Time) error {
+	var accounts []struct {
+		User     string
+		Currency string
+	}
+	err := db.Select(&accounts, "SELECT DISTINCT user, currency FROM ledger_transactions WHERE created < ?",
+		end.UTC().Format(utils.TimeFormat))
+	if err != nil {
+		return fmt.Errorf("failed to list ledger accounts for statements: %v", err)
+	}
+	for _, account := range accounts {
+		user := &User{Username: account.User}
+		opening, lines, err := user.ledgerBetween(start, end, account.Currency)
+		if err != nil {
+			return err
+		}
+		if opening == 0 && len(lines) == 0 {
+			continue
+		}
+		if _, err := user.issueStatement(start, end, account.Currency, opening, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ledgerBetween returns what this user owed in the currency at start, and the transactions
+// posted to their account from then until end, oldest first.
+func (u *User) ledgerBetween(start, end time.Time, currency string) (opening int64, lines []StatementLine,
+	err error) {
+	from, to := start.UTC().Format(utils.TimeFormat), end.UTC().Format(utils.TimeFormat)
+	var owed sql.NullInt64
+	err = db.Get(&owed, `
+	SELECT SUM(ledger_entries.amount) FROM ledger_entries
+	INNER JOIN ledger_transactions ON ledger_transactions.id = ledger_entries.transaction_id
+	WHERE ledger_entries.account = ? AND ledger_entries.currency = ? AND ledger_transactions.created < ?`,
+		userAccount+u.Username, currency, from)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not get opening balance of user<%s>: %v", u.Username, err)
+	}
+	err = db.Select(&lines, `
+	SELECT ledger_transactions.id AS transaction_id, ledger_transactions.type, ledger_transactions.description,
+		ledger_entries.amount, ledger_transactions.created AS posted
+	FROM ledger_entries
+	INNER JOIN ledger_transactions ON ledger_transactions.id = ledger_entries.transaction_id
+	WHERE ledger_entries.account = ? AND ledger_entries.currency = ?
+		AND ledger_transactions.created >= ? AND ledger_transactions.created < ?
+	ORDER BY ledger_transactions.created, ledger_transactions.id`, userAccount+u.Username, currency, from, to)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not get ledger of user<%s> from %s to %s: %v", u.Username, from, to, err)
+	}
+	for i := range lines {
+		lines[i].Line = i + 1
+	}
+	return owed.Int64, lines, nil
+}
+
+// issueStatement saves the statement under the next number. The sequence is locked while
+// the statement is saved, so numbers are handed out in order without gaps and a period is
+// never issued twice.
+func (u *User) issueStatement(start, end time.Time, currency string, opening int64,
+	lines []StatementLine) (Statement, error) {
+	statement := Statement{
+		User:        u.Username,
+		PeriodStart: start.UTC().Format(utils.TimeFormat),
+		PeriodEnd:   end.UTC().Format(utils.TimeFormat),
+		Currency:    currency,
+		Opening:     opening,
+		Closing:     opening,
+	}
+	for _, line := range lines {
+		statement.Closing += line.Amount
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return Statement{}, fmt.Errorf("could not issue statement for user<%s>: %v", u.Username, err)
+	}
+	defer tx.Rollback()
+	err = tx.Get(&statement.Number, "SELECT next FROM statement_sequence WHERE id = 1 FOR UPDATE")
+	if err != nil {
+		return Statement{}, fmt.Errorf("could not number statement for user<%s>: %v", u.Username, err)
+	}
+	var issued int
+	err = tx.Get(&issued, "SELECT number FROM statements WHERE user = ? AND period_start = ? AND currency = ?",
+		u.Username, statement.PeriodStart, currency)
+	if err == nil {
+		tx.Rollback()
+		return u.Statement(issued)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Statement{}, fmt.Errorf("could not issue statement for user<%s>: %v", u.Username, err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO statements (number, user, period_start, period_end, currency, opening, closing)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, statement.Number, statement.User, statement.PeriodStart,
+		statement.PeriodEnd, statement.Currency, statement.Opening, statement.Closing)
+	if err != nil {
+		return Statement{}, fmt.Errorf("could not issue statement for user<%s>: %v", u.Username, err)
+	}
+	for _, line := range lines {
+		line.StatementNumber = statement.Number
+		_, err = tx.Exec(`
+		INSERT INTO statement_lines (statement_number, line, transaction_id, type, description, amount, posted)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, line.StatementNumber, line.Line, line.TransactionID, line.Type,
+			line.Description, line.Amount, line.Posted)
+		if err != nil {
+			return Statement{}, fmt.Errorf("could not save lines of statement<%d>: %v", statement.Number, err)
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+	if _, err = tx.Exec("UPDATE statement_sequence SET next = next + 1 WHERE id = 1"); err != nil {
+		return Statement{}, fmt.Errorf("could not number statement for user<%s>: %v", u.Username, err)
+	}
+	if err := tx.Get(&statement.Issued, "SELECT issued FROM statements WHERE number = ?", statement.Number); err != nil {
+		return Statement{}, fmt.Errorf("could not issue statement for user<%s>: %v", u.Username, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Statement{}, fmt.Errorf("could not issue statement for user<%s>: %v", u.Username, err)
+	}
+	return statement, nil
+}
@@ -2,10 +2,14 @@ package tests
 
 import (
 	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
 	"github.com/mcctor/marauders/utils"
 )
 
@@ -17,6 +21,12 @@ func TestCreateNewUser(t *testing.T) {
 			t.Fatal("\t\tShould be able to successfully create a new user:", failMark, err)
 		}
 		t.Log("\t\tShould be able to successfully create a new user:", passMark, john)
+
+		// the rest of the tests create more devices and cloaks than the free plan allows
+		if _, err := john.Subscribe(db.PlanBusiness, time.Now().UTC().Add(-time.Hour), time.Time{}); err != nil {
+			t.Fatal("\t\tShould be able to subscribe a new user to a plan:", failMark, err)
+		}
+		t.Log("\t\tShould be able to subscribe a new user to a plan:", passMark)
 	}
 }
 
@@ -95,48 +105,61 @@ func TestGetDevicesForUser(t *testing.T) {
 	}
 }
 
-func TestGetUserBillings(t *testing.T) {
-	t.Log("Given the need to test the successful fetching of an existing user's bills.")
+func TestGetUserLedgerTransactions(t *testing.T) {
+	t.Log("Given the need to test the successful fetching of an existing user's ledger transactions.")
 	{
 		existingUsername := "john"
 		john, _ := db.GetUser(existingUsername)
 
-		johnBills, err := john.Billings(5)
+		johnTransactions, err := john.LedgerTransactions(5)
 		if err != nil {
-			t.Fatal("\t\tShould successfully fetch an existing user's bills:", failMark, err)
+			t.Fatal("\t\tShould successfully fetch an existing user's transactions:", failMark, err)
 		}
-		t.Log("\t\tShould successfully fetch an existing user's bills:", passMark, johnBills)
+		t.Log("\t\tShould successfully fetch an existing user's transactions:", passMark, johnTransactions)
 	}
 }
 
 func TestCreditUser(t *testing.T) {
-	t.Log("Given the need to test the successful crediting of an existing user's billing account.")
+	t.Log("Given the need to test the successful crediting of an existing user's ledger account.")
 	{
 		existingUsername := "john"
 		john, _ := db.GetUser(existingUsername)
 
-		newBill, err := john.CreditUserAmount(100)
+		credit, err := john.Credit(db.Money{Amount: 10000, Currency: db.DefaultCurrency}, "welcome credit",
+			"test-credit")
 		if err != nil {
 			t.Fatal("\t\tShould successfully credit the given amount to a user's account:", failMark, err)
 		}
-		t.Log("\t\tShould successfully credit the given amount to a user's account:", passMark, newBill)
+		t.Log("\t\tShould successfully credit the given amount to a user's account:", passMark, credit)
 	}
 }
 
-func TestDebitUser(t *testing.T) {
-	t.Log("Given the need to test the successful debiting of an existing user's billing account.")
+func TestChargeUser(t *testing.T) {
+	t.Log("Given the need to test the successful charging of an existing user's ledger account.")
 	{
-		// sleep for a bit to avoid conflict in the time_stamp primary key
-		// for the billings table
-		time.Sleep(1 * time.Second)
-
 		existingUsername := "john"
 		john, _ := db.GetUser(existingUsername)
-		newBill, err := john.DebitUserAmount(100)
+		before, _ := john.Balance(db.DefaultCurrency)
+		amount := db.Money{Amount: 2550, Currency: db.DefaultCurrency}
+
+		charge, err := john.Charge(amount, "test charge", "test-charge")
 		if err != nil {
-			t.Fatal("\t\tShould successfully debit the given amount to a user's account:", failMark, err)
+			t.Fatal("\t\tShould successfully charge the given amount to a user's account:", failMark, err)
 		}
-		t.Log("\t\tShould successfully debit the given amount to a user's account:", passMark, newBill)
+		t.Log("\t\tShould successfully charge the given amount to a user's account:", passMark, charge)
+
+		again, err := john.Charge(amount, "test charge", "test-charge")
+		after, _ := john.Balance(db.DefaultCurrency)
+		if err != nil || again.ID != charge.ID || after.Amount != before.Amount+amount.Amount {
+			t.Fatal("\t\tShould only charge once for the same idempotency key:", failMark, err, before, after)
+		}
+		t.Log("\t\tShould only charge once for the same idempotency key:", passMark)
+
+		_, err = john.Charge(db.Money{Amount: 1, Currency: db.DefaultCurrency}, "test charge", "test-charge")
+		if !errors.Is(err, db.ErrIdempotencyKeyReused) {
+			t.Fatal("\t\tShould refuse a different charge with a used idempotency key:", failMark, err)
+		}
+		t.Log("\t\tShould refuse a different charge with a used idempotency key:", passMark)
 	}
 }
 
@@ -261,6 +284,24 @@ func TestUserDeletion(t *testing.T) {
 	}
 }
 
+func TestUserCreatedEvent(t *testing.T) {
+	t.Log("Given the need to test that creating a new user publishes an event.")
+	{
+		var created []string
+		unsubscribe := db.Subscribe(db.TopicUserCreated, func(event db.Event) {
+			created = append(created, event.(db.UserCreated).User.Username)
+		})
+		defer unsubscribe()
+
+		someone, _ := db.NewUser("eventful", "")
+		defer someone.Delete()
+		if len(created) != 1 || created[0] != "eventful" {
+			t.Fatal("\t\tShould publish a single event for the created user:", failMark, created)
+		}
+		t.Log("\t\tShould publish a single event for the created user:", passMark, created)
+	}
+}
+
 func TestUserAuthToken(t *testing.T) {
 	existingUsername := "john"
 	john, _ := db.GetUser(existingUsername)
@@ -319,7 +360,7 @@ func TestUserDevice(t *testing.T) {
 
 	t.Log("Given the need to test for the successful creation of a location snapshot for an existing device.")
 	{
-		err := device.NewLocationSnapshot(time.Now().Format(utils.TimeFormat), 9.123, -12.244)
+		err := device.NewLocationSnapshot(time.Now().UTC().Format(utils.TimeFormat), 9.123, -12.244)
 		if err != nil {
 			t.Fatal("\t\tShould successfully create a new location snapshot:", failMark, err)
 		}
@@ -536,6 +577,28 @@ func TestUserCloak(t *testing.T) {
 		t.Log("\t\tShould be able to fetch the location snapshots for a user filtered by this cloak's rules:",
 			passMark, locSnaps)
 	}
+
+	t.Log("Given the need to test that an existing cloak is visible to its creator.")
+	{
+		cloak, _ := db.GetCloakByID(existingCloak.ID)
+
+		visible, err := cloak.CanBeViewedBy(john.Username)
+		if err != nil || !visible {
+			t.Fatal("\t\tShould be visible to the user who created it:", failMark, err)
+		}
+		t.Log("\t\tShould be visible to the user who created it", passMark)
+	}
+
+	t.Log("Given the need to test the obscuring of a location snapshot to an existing cloak's accuracy.")
+	{
+		cloak, _ := db.GetCloakByID(existingCloak.ID)
+
+		obscured := cloak.Obscure(db.LocationSnapshot{Latitude: 9.123, Longitude: -12.244})
+		if obscured.Latitude != 9.1 || obscured.Longitude != -12.2 {
+			t.Fatal("\t\tShould round off coordinates to city accuracy:", failMark, obscured)
+		}
+		t.Log("\t\tShould round off coordinates to city accuracy:", passMark, obscured)
+	}
 }
 
 func TestInviteDeviceByLink(t *testing.T) {
@@ -554,4 +617,735 @@ func TestInviteDeviceByLink(t *testing.T) {
 		}
 		t.Log("\t\tShould be able to successfully invite a device through a valid link", passMark)
 	}
-}
\ No newline at end of file
+}
+func TestUserGeofence(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(321)
+	home := geo.Point{Latitude: -1.2921, Longitude: 36.8219}
+
+	geofence, err := john.NewCircleGeofence("home", home, 100)
+	t.Log("Given the need to test the successful creation of a geofence for an existing user.")
+	{
+		if err != nil {
+			t.Fatal("\t\tShould be able to create a new circle geofence:", failMark, err)
+		}
+		t.Log("\t\tShould be able to create a new circle geofence:", passMark, geofence)
+
+		tiny, err := john.NewCircleGeofence("desk", home, 20)
+		if err != nil || tiny.Hysteresis != 10 {
+			t.Fatal("\t\tShould keep the hysteresis of a small geofence within half its radius:", failMark, tiny, err)
+		}
+		t.Log("\t\tShould keep the hysteresis of a small geofence within half its radius:", passMark, tiny)
+	}
+
+	t.Log("Given the need to test the recording of enter and exit events as a device moves.")
+	{
+		start := time.Now().UTC().Add(-2 * time.Hour)
+		path := []geo.Point{
+			{Latitude: -1.2950, Longitude: 36.8219}, // about 320m south, outside
+			{Latitude: -1.2921, Longitude: 36.8219}, // at the center, enters
+			{Latitude: -1.2930, Longitude: 36.8219}, // about 100m south, on the edge
+			{Latitude: -1.2950, Longitude: 36.8219}, // outside again, exits
+		}
+		for i, point := range path {
+			timeStamp := start.Add(time.Duration(i) * time.Minute).Format(utils.TimeFormat)
+			_ = device.NewLocationSnapshot(timeStamp, point.Latitude, point.Longitude)
+		}
+
+		events, err := geofence.Events(10)
+		if err != nil {
+			t.Fatal("\t\tShould be able to fetch the events of the geofence:", failMark, err)
+		}
+		if len(events) != 2 || events[0].Kind != db.GeofenceExit || events[1].Kind != db.GeofenceEnter {
+			t.Fatal("\t\tShould record a single enter followed by a single exit:", failMark, events)
+		}
+		t.Log("\t\tShould record a single enter followed by a single exit:", passMark, events)
+	}
+
+	t.Log("Given the need to test that a cloak's geofence only sees what the cloak shares.")
+	{
+		wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+		sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+		duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+		cloak, _ := john.NewCloak("streetwise", "roughly where", wakeTime, sleepTime, duration,
+			"street", 5, true, true, false, true)
+		member, _ := john.NewDevice(1501)
+		_ = member.AssociateToCloak(cloak.ID)
+		shared, err := cloak.NewCircleGeofence("home", home, 500)
+		if err != nil {
+			t.Fatal("\t\tShould be able to create a geofence for a cloak:", failMark, err)
+		}
+		start := time.Now().UTC().Add(-time.Hour)
+		_ = member.NewLocationSnapshot(start.Format(utils.TimeFormat), -1.3021, 36.8219)
+		_ = member.NewLocationSnapshot(start.Add(time.Minute).Format(utils.TimeFormat), -1.2921, 36.8219)
+
+		events, err := shared.Events(10)
+		if err != nil || len(events) != 1 || events[0].Latitude != -1.292 || events[0].Longitude != 36.822 {
+			t.Fatal("\t\tShould only record the position as obscured by the cloak:", failMark, err, events)
+		}
+		t.Log("\t\tShould only record the position as obscured by the cloak:", passMark, events)
+	}
+}
+
+func TestCloakNightSchedule(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 22:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 06:00:00")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := john.NewCloak("night owls", "after dark", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	device, _ := john.NewDevice(1401)
+	_ = device.AssociateToCloak(cloak.ID)
+	_ = device.NewLocationSnapshot("2024-03-01 23:30:00", -1.2921, 36.8219)
+	_ = device.NewLocationSnapshot("2024-03-02 05:00:00", -1.2921, 36.8219)
+	_ = device.NewLocationSnapshot("2024-03-02 12:00:00", -1.2921, 36.8219)
+
+	t.Log("Given the need to test sharing history through a schedule that wraps past midnight.")
+	{
+		snapshots, err := cloak.LocationSnapshotsForMember(device, 10)
+		if err != nil || len(snapshots) != 2 {
+			t.Fatal("\t\tShould only share the snapshots recorded between wake and sleep:", failMark, err, snapshots)
+		}
+		for _, snapshot := range snapshots {
+			if !cloak.Permits(snapshot) {
+				t.Fatal("\t\tShould only share the snapshots recorded between wake and sleep:", failMark, snapshot)
+			}
+		}
+		t.Log("\t\tShould only share the snapshots recorded between wake and sleep:", passMark)
+	}
+}
+
+func TestCloakProximity(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := john.NewCloak("family", "close by", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	first, _ := john.NewDevice(601)
+	second, _ := john.NewDevice(602)
+	_ = first.AssociateToCloak(cloak.ID)
+	_ = second.AssociateToCloak(cloak.ID)
+
+	t.Log("Given the need to test the successful configuration of a proximity rule for a cloak.")
+	{
+		rule, err := cloak.SetProximityRule(200)
+		if err != nil {
+			t.Fatal("\t\tShould be able to set a proximity rule:", failMark, err)
+		}
+		t.Log("\t\tShould be able to set a proximity rule:", passMark, rule)
+	}
+
+	t.Log("Given the need to test the recording of an event when two members come close.")
+	{
+		start := time.Now().UTC().Add(-time.Hour)
+		_ = first.NewLocationSnapshot(start.Format(utils.TimeFormat), -1.2921, 36.8219)
+		_ = second.NewLocationSnapshot(start.Add(time.Minute).Format(utils.TimeFormat), -1.3021, 36.8219)
+		_ = second.NewLocationSnapshot(start.Add(2*time.Minute).Format(utils.TimeFormat), -1.2925, 36.8219)
+
+		events, err := cloak.ProximityEvents(10)
+		if err != nil {
+			t.Fatal("\t\tShould be able to fetch the proximity events of the cloak:", failMark, err)
+		}
+		if len(events) != 1 || events[0].Kind != db.ProximityNear {
+			t.Fatal("\t\tShould record a single near event:", failMark, events)
+		}
+		t.Log("\t\tShould record a single near event:", passMark, events)
+	}
+}
+
+func TestIngestFilter(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(702)
+	start := time.Now().UTC().Add(-30 * time.Minute)
+	_ = device.NewLocationSnapshot(start.Format(utils.TimeFormat), -1.2921, 36.8219)
+
+	t.Log("Given the need to test the rejection of snapshots that cannot be right.")
+	{
+		cases := []struct {
+			reason    string
+			timeStamp string
+			latitude  float64
+			longitude float64
+		}{
+			{db.RejectOutOfRange, start.Add(time.Minute).Format(utils.TimeFormat), 91, 36.8219},
+			{db.RejectNullIsland, start.Add(time.Minute).Format(utils.TimeFormat), 0, 0},
+			{db.RejectDuplicate, start.Format(utils.TimeFormat), -1.2921, 36.8219},
+			{db.RejectTooFast, start.Add(time.Second).Format(utils.TimeFormat), 51.5072, -0.1276},
+			{db.RejectFuture, time.Now().UTC().Add(time.Hour).Format(utils.TimeFormat), -1.2921, 36.8219},
+		}
+		for _, c := range cases {
+			err := device.NewLocationSnapshot(c.timeStamp, c.latitude, c.longitude)
+			rejection, ok := err.(*db.RejectedSnapshotError)
+			if !ok || rejection.Reason != c.reason {
+				t.Fatal("\t\tShould reject the snapshot as", c.reason, failMark, err)
+			}
+			t.Log("\t\tShould reject the snapshot as", c.reason, passMark)
+		}
+	}
+
+	t.Log("Given the need to test that rejected snapshots are kept with their reason.")
+	{
+		rejected, err := device.RejectedSnapshots(10)
+		if err != nil || len(rejected) != 5 {
+			t.Fatal("\t\tShould keep every rejected snapshot:", failMark, err, rejected)
+		}
+		t.Log("\t\tShould keep every rejected snapshot:", passMark, rejected)
+	}
+}
+
+func TestIngestFilterRecovery(t *testing.T) {
+	john, _ := db.GetUser("john")
+	traveller, _ := john.NewDevice(706)
+	start := time.Now().UTC().Add(-12 * time.Hour)
+	_ = traveller.NewLocationSnapshot(start.Format(utils.TimeFormat), -1.2921, 36.8219)
+
+	t.Log("Given the need to test that a device may turn up far away after a long gap.")
+	{
+		landed := start.Add(9 * time.Hour).Format(utils.TimeFormat)
+		if err := traveller.NewLocationSnapshot(landed, 51.5072, -0.1276); err != nil {
+			t.Fatal("\t\tShould accept a snapshot after a flight:", failMark, err)
+		}
+		t.Log("\t\tShould accept a snapshot after a flight:", passMark)
+	}
+
+	t.Log("Given the need to test that a single bad fix does not lock a device out.")
+	{
+		// a bad fix long enough after the last one is saved without a speed check
+		bad := start.Add(11 * time.Hour)
+		_ = traveller.NewLocationSnapshot(bad.Format(utils.TimeFormat), 30.0, -40.0)
+		var err error
+		for i := 1; i <= db.IngestFilter.ReanchorAfter+1; i++ {
+			recorded := bad.Add(time.Duration(i) * time.Minute).Format(utils.TimeFormat)
+			err = traveller.NewLocationSnapshot(recorded, 51.5072, -0.1276)
+		}
+		if err != nil {
+			t.Fatal("\t\tShould re-anchor on the device's true position:", failMark, err)
+		}
+		t.Log("\t\tShould re-anchor on the device's true position:", passMark)
+	}
+}
+
+func TestImportLocationSnapshots(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(703)
+	start := time.Now().UTC().AddDate(-1, 0, 0)
+	snapshots := []db.LocationSnapshot{
+		{TimeStamp: start.Format(utils.TimeFormat), Latitude: -1.2921, Longitude: 36.8219},
+		{TimeStamp: start.Add(time.Minute).Format(utils.TimeFormat), Latitude: -1.2925, Longitude: 36.8221},
+	}
+
+	t.Log("Given the need to test that a dry run saves nothing.")
+	{
+		imported, _, err := device.ImportLocationSnapshots(snapshots, true)
+		history, _ := device.LocationSnapshots(10)
+		if err != nil || imported != 2 || len(history) != 0 {
+			t.Fatal("\t\tShould count the snapshots without saving them:", failMark, err, imported, history)
+		}
+		t.Log("\t\tShould count the snapshots without saving them:", passMark)
+	}
+
+	t.Log("Given the need to test that imports skip time stamps already in the history.")
+	{
+		_, _, _ = device.ImportLocationSnapshots(snapshots[:1], false)
+		imported, duplicates, err := device.ImportLocationSnapshots(snapshots, false)
+		if err != nil || imported != 1 || duplicates != 1 {
+			t.Fatal("\t\tShould import only the new snapshot:", failMark, err, imported, duplicates)
+		}
+		t.Log("\t\tShould import only the new snapshot:", passMark)
+	}
+}
+
+func TestDeviceCredential(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(704)
+
+	t.Log("Given the need to test that a device is found by its credential.")
+	{
+		secret, err := device.NewCredential()
+		found, lookupErr := db.GetDeviceByCredential(secret)
+		if err != nil || lookupErr != nil || found.ID != device.ID {
+			t.Fatal("\t\tShould find the device the credential was issued to:", failMark, err, lookupErr)
+		}
+		t.Log("\t\tShould find the device the credential was issued to:", passMark)
+	}
+
+	t.Log("Given the need to test that a revoked credential no longer works.")
+	{
+		secret, _ := device.NewCredential()
+		_ = device.RevokeCredential()
+		_, err := db.GetDeviceByCredential(secret)
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatal("\t\tShould not find a device by a revoked credential:", failMark, err)
+		}
+		t.Log("\t\tShould not find a device by a revoked credential:", passMark)
+	}
+}
+
+func TestDeviceIdentifier(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(705)
+
+	t.Log("Given the need to test that a device is found by its registered identifier.")
+	{
+		_, err := device.RegisterIdentifier(db.ProtocolOsmAnd, "tracker-705")
+		found, lookupErr := db.GetDeviceByIdentifier(db.ProtocolOsmAnd, "tracker-705")
+		if err != nil || lookupErr != nil || found.ID != device.ID {
+			t.Fatal("\t\tShould find the device by its identifier:", failMark, err, lookupErr)
+		}
+		t.Log("\t\tShould find the device by its identifier:", passMark)
+	}
+
+	t.Log("Given the need to test that identifiers are scoped to their protocol.")
+	{
+		_, err := db.GetDeviceByIdentifier(db.ProtocolIMEI, "tracker-705")
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatal("\t\tShould not find the device under another protocol:", failMark, err)
+		}
+		t.Log("\t\tShould not find the device under another protocol:", passMark)
+	}
+}
+
+func TestSpatialQueries(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := john.NewCloak("spatial", "where is everyone", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	near, _ := john.NewDevice(801)
+	far, _ := john.NewDevice(802)
+	_ = near.AssociateToCloak(cloak.ID)
+	_ = far.AssociateToCloak(cloak.ID)
+	recorded := time.Now().UTC().Add(-time.Hour).Format(utils.TimeFormat)
+	_ = near.NewLocationSnapshot(recorded, -1.2921, 36.8219)
+	_ = far.NewLocationSnapshot(recorded, -4.0435, 39.6682)
+	center := geo.Point{Latitude: -1.2930, Longitude: 36.8220}
+	// members of a permitted cloak see the cloak's members, but share nothing through it
+	onlookers, _ := john.NewCloak("onlookers", "just watching", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	onlooker, _ := john.NewDevice(803)
+	_ = onlooker.AssociateToCloak(onlookers.ID)
+	_ = onlooker.NewLocationSnapshot(recorded, -1.2931, 36.8221)
+	_ = cloak.AddPermittedCloak(onlookers.ID)
+
+	t.Log("Given the need to test finding the members within a radius of a point.")
+	{
+		nearby, err := cloak.MembersWithin(center, 1000)
+		if err != nil || len(nearby) != 1 || nearby[0].Device.ID != near.ID {
+			t.Fatal("\t\tShould only find the member within the radius:", failMark, err, nearby)
+		}
+		t.Log("\t\tShould only find the member within the radius:", passMark)
+	}
+
+	t.Log("Given the need to test finding the nearest members to a point.")
+	{
+		nearest, err := cloak.NearestMembers(center, 2)
+		if err != nil || len(nearest) != 2 || nearest[0].Device.ID != near.ID {
+			t.Fatal("\t\tShould find both members, nearest first:", failMark, err, nearest)
+		}
+		t.Log("\t\tShould find both members, nearest first:", passMark)
+	}
+
+	t.Log("Given the need to test that members are only found by their obscured position.")
+	{
+		vague, _ := john.NewCloak("vague", "somewhere in town", wakeTime, sleepTime, duration,
+			"city", 5, true, true, false, true)
+		_ = near.AssociateToCloak(vague.ID)
+		exact, _ := vague.MembersWithin(geo.Point{Latitude: -1.2921, Longitude: 36.8219}, 100)
+		rounded, err := vague.MembersWithin(geo.Point{Latitude: -1.3, Longitude: 36.8}, 100)
+		if err != nil || len(exact) != 0 || len(rounded) != 1 || rounded[0].Snapshot.Latitude != -1.3 {
+			t.Fatal("\t\tShould not be found by the exact position:", failMark, err, exact, rounded)
+		}
+		t.Log("\t\tShould not be found by the exact position:", passMark)
+	}
+
+	t.Log("Given the need to test reading only the part of a member's track within a box.")
+	{
+		start := time.Now().UTC().Add(-time.Hour)
+		_ = near.NewLocationSnapshot(start.Add(20*time.Minute).Format(utils.TimeFormat), -1.3421, 36.8219)
+		_ = near.NewLocationSnapshot(start.Add(40*time.Minute).Format(utils.TimeFormat), -1.2925, 36.8219)
+		_ = near.NewLocationSnapshot(start.Add(50*time.Minute).Format(utils.TimeFormat), -1.2929, 36.8219)
+		var follows []bool
+		err := cloak.EachLocationSnapshotForMemberWithin(near, geo.BoxAround(center, 1000),
+			start.Add(-time.Minute).Format(utils.TimeFormat), time.Now().UTC().Format(utils.TimeFormat),
+			func(snapshot db.LocationSnapshot, follow bool) error {
+				follows = append(follows, follow)
+				return nil
+			})
+		if err != nil || len(follows) != 3 || follows[0] || follows[1] || !follows[2] {
+			t.Fatal("\t\tShould only read the snapshots within the box, telling where the track left it:",
+				failMark, err, follows)
+		}
+		t.Log("\t\tShould only read the snapshots within the box, telling where the track left it:", passMark)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := john.NewCloak("forgetful", "short memory", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	device, _ := john.NewDevice(901)
+	_ = device.AssociateToCloak(cloak.ID)
+	_ = device.NewLocationSnapshot(time.Now().UTC().Add(-10*24*time.Hour).Format(utils.TimeFormat), -1.2921, 36.8219)
+	_ = device.NewLocationSnapshot(time.Now().UTC().Add(-time.Hour).Format(utils.TimeFormat), -1.2921, 36.8219)
+
+	t.Log("Given the need to test capping a user's retention by a cloak's.")
+	{
+		_ = john.SetRetention(30)
+		_ = cloak.SetMaxRetention(7)
+		period, err := device.RetentionPeriod()
+		if err != nil || period != 7*24*time.Hour {
+			t.Fatal("\t\tShould keep history for the shorter of the two periods:", failMark, err, period)
+		}
+		t.Log("\t\tShould keep history for the shorter of the two periods:", passMark)
+
+		bystander, _ := db.NewUser("bystander", "bystander@somewhere.com")
+		theirs, _ := bystander.NewDevice(902)
+		_ = theirs.AssociateToCloak(cloak.ID)
+		period, err = theirs.RetentionPeriod()
+		if err != nil || period != 0 {
+			t.Fatal("\t\tShould not cap the history of devices the cloak's creator does not own:", failMark, err, period)
+		}
+		t.Log("\t\tShould not cap the history of devices the cloak's creator does not own:", passMark)
+	}
+
+	t.Log("Given the need to test purging expired history.")
+	{
+		if _, err := db.PurgeExpiredSnapshots(); err != nil {
+			t.Fatal("\t\tShould be able to purge expired snapshots:", failMark, err)
+		}
+		snapshots, _ := device.LocationSnapshots(10)
+		if len(snapshots) != 1 {
+			t.Fatal("\t\tShould only keep the recent snapshot:", failMark, snapshots)
+		}
+		t.Log("\t\tShould only keep the recent snapshot:", passMark)
+
+		runs, err := john.RetentionRuns(10)
+		if err != nil || len(runs) == 0 || runs[0].DeviceID != device.ID || runs[0].Purged != 1 {
+			t.Fatal("\t\tShould record the purge:", failMark, err, runs)
+		}
+		t.Log("\t\tShould record the purge:", passMark)
+	}
+	_ = john.ClearRetention()
+}
+
+func TestBatchedIngest(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+	device, _ := john.NewDevice(1001)
+	recorded := time.Now().UTC().Add(-time.Hour)
+
+	t.Log("Given the need to test recording snapshots through batched ingest.")
+	{
+		stop := db.StartBatchedIngest()
+		var wg sync.WaitGroup
+		errs := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				snapshot := db.LocationSnapshot{
+					TimeStamp: recorded.Add(time.Duration(i) * time.Second).Format(utils.TimeFormat),
+					Latitude:  -1.2921,
+					Longitude: 36.8219,
+				}
+				errs <- device.RecordLocationSnapshot(snapshot)
+			}(i)
+		}
+		wg.Wait()
+		stop()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal("\t\tShould be able to record snapshots in a batch:", failMark, err)
+			}
+		}
+		snapshots, _ := device.LocationSnapshots(10)
+		if len(snapshots) != 3 {
+			t.Fatal("\t\tShould have saved every snapshot in the batch:", failMark, snapshots)
+		}
+		t.Log("\t\tShould have saved every snapshot in the batch:", passMark)
+	}
+}
+
+// benchmarkStart and benchmarkRecorded give every benchmarked snapshot its own time stamp,
+// as benchmarks are run more than once.
+var (
+	benchmarkStart    = time.Now().UTC().Add(-24 * time.Hour)
+	benchmarkRecorded int64
+)
+
+// benchmarkRecordLocationSnapshot records snapshots of the device from parallel
+// goroutines, as many devices reporting at once would.
+func benchmarkRecordLocationSnapshot(b *testing.B, deviceID int) {
+	john, _ := db.GetUser("john")
+	_, _ = john.NewDevice(deviceID)
+	device, err := db.GetDeviceByID(deviceID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			offset := time.Duration(atomic.AddInt64(&benchmarkRecorded, 1)) * time.Millisecond
+			snapshot := db.LocationSnapshot{
+				TimeStamp: benchmarkStart.Add(offset).Format(utils.TimeFormatMilli),
+				Latitude:  -1.2921,
+				Longitude: 36.8219,
+			}
+			if err := device.RecordLocationSnapshot(snapshot); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRecordLocationSnapshot(b *testing.B) {
+	benchmarkRecordLocationSnapshot(b, 1002)
+}
+
+func BenchmarkRecordLocationSnapshotBatched(b *testing.B) {
+	stop := db.StartBatchedIngest()
+	defer stop()
+	benchmarkRecordLocationSnapshot(b, 1003)
+}
+
+func TestLatestLocations(t *testing.T) {
+	existingUsername := "john"
+	john, _ := db.GetUser(existingUsername)
+
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := john.NewCloak("latest", "where is everyone now", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	device, _ := john.NewDevice(1101)
+	_ = device.AssociateToCloak(cloak.ID)
+	recent := time.Now().UTC().Add(-time.Hour)
+	_ = device.RecordLocationSnapshot(db.LocationSnapshot{
+		TimeStamp: recent.Format(utils.TimeFormat), Latitude: -1.2921, Longitude: 36.8219,
+		Battery: sql.NullInt64{Int64: 80, Valid: true},
+	})
+	// arrives late, so it should not replace the latest location
+	_ = device.NewLocationSnapshot(recent.Add(-time.Minute).Format(utils.TimeFormat), -1.2925, 36.8215)
+	// members of a permitted cloak see the cloak's members, but share nothing through it
+	onlookers, _ := john.NewCloak("bystanders", "just watching", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	onlooker, _ := john.NewDevice(1102)
+	_ = onlooker.AssociateToCloak(onlookers.ID)
+	_ = onlooker.NewLocationSnapshot(recent.Format(utils.TimeFormat), -1.2931, 36.8221)
+	_ = cloak.AddPermittedCloak(onlookers.ID)
+
+	t.Log("Given the need to test getting the latest location of a device.")
+	{
+		latest, err := device.LatestLocationSnapshot()
+		if err != nil || latest.Latitude != -1.2921 || latest.Battery.Int64 != 80 {
+			t.Fatal("\t\tShould get the most recent snapshot with its readings:", failMark, err, latest)
+		}
+		t.Log("\t\tShould get the most recent snapshot with its readings:", passMark)
+	}
+
+	t.Log("Given the need to test getting the latest locations of a cloak's members.")
+	{
+		locations, err := cloak.LatestMemberLocations()
+		if err != nil || len(locations) != 1 || locations[0].DeviceID != device.ID {
+			t.Fatal("\t\tShould get the latest location of every member:", failMark, err, locations)
+		}
+		t.Log("\t\tShould get the latest location of every member:", passMark)
+	}
+}
+
+func TestUsageMetering(t *testing.T) {
+	metered, _ := db.NewUser("metered", "metered@somewhere.com")
+	wakeTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 00:00:00")
+	sleepTime, _ := time.Parse(utils.TimeFormat, "2001-01-01 23:59:59")
+	duration, _ := time.Parse(utils.TimeFormat, "2019-01-01 00:00:00")
+	cloak, _ := metered.NewCloak("metered", "counted", wakeTime, sleepTime, duration,
+		"pinpoint", 5, true, true, false, true)
+	device, _ := metered.NewDevice(1201)
+	_ = device.AssociateToCloak(cloak.ID)
+	_ = device.NewLocationSnapshot(time.Now().UTC().Add(-time.Hour).Format(utils.TimeFormat), -1.2921, 36.8219)
+	// the history of a device is gone along with it, but what it stored was still used
+	gone, _ := metered.NewDevice(1202)
+	_ = gone.NewLocationSnapshot(time.Now().UTC().Add(-time.Hour).Format(utils.TimeFormat), -1.2921, 36.8219)
+	_ = gone.Delete()
+	start, end := db.BillingPeriodOf(time.Now().UTC())
+
+	t.Log("Given the need to test metering a user's usage over a period.")
+	{
+		usage, err := metered.Usage(start, end)
+		if err != nil || usage.Snapshots != 2 || usage.ActiveDevices != 2 || usage.Cloaks != 1 || usage.Members != 1 {
+			t.Fatal("\t\tShould count every kind of billable usage, including history no longer kept:",
+				failMark, err, usage)
+		}
+		t.Log("\t\tShould count every kind of billable usage, including history no longer kept:", passMark)
+	}
+
+	t.Log("Given the need to test closing a usage period only bills it once.")
+	{
+		statement, err := metered.CloseUsagePeriod(start, end)
+		if err != nil || !statement.Billed || statement.Amount != db.Pricing.Charge(statement.Usage).Amount {
+			t.Fatal("\t\tShould bill the charge of the period:", failMark, err, statement)
+		}
+		billed, _ := metered.Balance(db.Pricing.Currency)
+		if _, err := metered.CloseUsagePeriod(start, end); err != nil {
+			t.Fatal("\t\tShould be able to close a period again:", failMark, err)
+		}
+		rebilled, _ := metered.Balance(db.Pricing.Currency)
+		if billed.Amount != statement.Amount || rebilled != billed {
+			t.Fatal("\t\tShould not bill a closed period again:", failMark, billed, rebilled)
+		}
+		t.Log("\t\tShould not bill a closed period again:", passMark)
+	}
+}
+
+func TestEntitlements(t *testing.T) {
+	freeloader, _ := db.NewUser("freeloader", "freeloader@somewhere.com")
+	free := db.Plans[db.PlanFree]
+
+	t.Log("Given the need to test that a user is held to the limits of the free plan.")
+	{
+		for i := 0; i < free.MaxDevices; i++ {
+			if _, err := freeloader.NewDevice(1301 + i); err != nil {
+				t.Fatal("\t\tShould be able to create devices up to the limit:", failMark, err)
+			}
+		}
+		_, err := freeloader.NewDevice(1301 + free.MaxDevices)
+		if !errors.Is(err, db.ErrUpgradeRequired) {
+			t.Fatal("\t\tShould require an upgrade for a device over the limit:", failMark, err)
+		}
+		t.Log("\t\tShould require an upgrade for a device over the limit:", passMark)
+
+		if err := freeloader.SetRetention(free.RetentionDays + 1); !errors.Is(err, db.ErrUpgradeRequired) {
+			t.Fatal("\t\tShould require an upgrade for longer retention:", failMark, err)
+		}
+		t.Log("\t\tShould require an upgrade for longer retention:", passMark)
+
+		days, custom, err := freeloader.Retention()
+		if err != nil || custom || days != int(db.Retention.Default/(24*time.Hour)) {
+			t.Fatal("\t\tShould not shorten a retention the user did not choose:", failMark, err, days)
+		}
+		t.Log("\t\tShould not shorten a retention the user did not choose:", passMark)
+	}
+
+	t.Log("Given the need to test that a paid plan lifts the limits of the free plan.")
+	{
+		_, _ = freeloader.Subscribe(db.PlanPro, time.Now().UTC().Add(-time.Minute), time.Now().UTC().Add(time.Hour))
+		if _, err := freeloader.NewDevice(1301 + free.MaxDevices); err != nil {
+			t.Fatal("\t\tShould be able to create a device once upgraded:", failMark, err)
+		}
+		usage, err := freeloader.PlanUsage()
+		if err != nil || usage.Devices != free.MaxDevices+1 {
+			t.Fatal("\t\tShould count the devices against the plan:", failMark, err, usage)
+		}
+		t.Log("\t\tShould count the devices against the plan:", passMark)
+	}
+
+	t.Log("Given the need to test that concurrent requests cannot take a user past a limit.")
+	{
+		racer, _ := db.NewUser("racer", "racer@somewhere.com")
+		var wg sync.WaitGroup
+		var created int32
+		for i := 0; i < free.MaxDevices+3; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := racer.NewDevice(1601 + i); err == nil {
+					atomic.AddInt32(&created, 1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if int(created) != free.MaxDevices {
+			t.Fatal("\t\tShould create only as many devices as the plan allows:", failMark, created)
+		}
+		t.Log("\t\tShould create only as many devices as the plan allows:", passMark)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	t.Log("Given the need to test issuing a monthly statement of a user's ledger.")
+	{
+		john, _ := db.GetUser("john")
+		start, end := time.Now().UTC().Add(-time.Minute), time.Now().UTC().Add(time.Hour)
+		amount := db.Money{Amount: 1999, Currency: db.DefaultCurrency}
+		if _, err := john.Charge(amount, "statement charge", "statement-charge"); err != nil {
+			t.Fatal("\t\tShould be able to charge the user:", failMark, err)
+		}
+
+		statement, err := john.IssueStatement(start, end, db.DefaultCurrency)
+		if err != nil {
+			t.Fatal("\t\tShould be able to issue a statement:", failMark, err)
+		}
+		balance, _ := john.Balance(db.DefaultCurrency)
+		if statement.Closing != balance.Amount || len(statement.Lines) == 0 {
+			t.Fatal("\t\tShould carry the opening balance over to the closing one:", failMark, statement)
+		}
+		t.Log("\t\tShould carry the opening balance over to the closing one:", passMark, statement.Number)
+
+		again, err := john.IssueStatement(start, end, db.DefaultCurrency)
+		if err != nil || again.Number != statement.Number || len(again.Lines) != len(statement.Lines) {
+			t.Fatal("\t\tShould only issue a period once:", failMark, err, again.Number)
+		}
+		t.Log("\t\tShould only issue a period once:", passMark)
+
+		_, err = john.Statement(statement.Number + 1000)
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatal("\t\tShould not find a statement that was never issued:", failMark, err)
+		}
+		t.Log("\t\tShould not find a statement that was never issued:", passMark)
+	}
+}
+
+func TestCheckouts(t *testing.T) {
+	t.Log("Given the need to test topping up a user's account with a payment provider.")
+	{
+		john, _ := db.GetUser("john")
+		amount := db.Money{Amount: 2500, Currency: db.DefaultCurrency}
+		checkout, err := john.NewCheckout("stub", "stub_test", "http://localhost/pay/", amount)
+		if err != nil || checkout.Status != db.CheckoutOpen {
+			t.Fatal("\t\tShould open a checkout:", failMark, err, checkout.Status)
+		}
+		t.Log("\t\tShould open a checkout:", passMark)
+
+		_, err = db.PayCheckout("stub", checkout.ID, db.Money{Amount: 1, Currency: db.DefaultCurrency})
+		if !errors.Is(err, db.ErrCheckoutMismatch) {
+			t.Fatal("\t\tShould refuse a payment of another amount:", failMark, err)
+		}
+		t.Log("\t\tShould refuse a payment of another amount:", passMark)
+
+		before, _ := john.Balance(db.DefaultCurrency)
+		for i := 0; i < 2; i++ {
+			if checkout, err = db.PayCheckout("stub", checkout.ID, amount); err != nil {
+				t.Fatal("\t\tShould pay the checkout:", failMark, err)
+			}
+		}
+		after, _ := john.Balance(db.DefaultCurrency)
+		if checkout.Status != db.CheckoutPaid || after.Amount != before.Amount-amount.Amount {
+			t.Fatal("\t\tShould credit the user once however often it is paid:", failMark, before, after)
+		}
+		t.Log("\t\tShould credit the user once however often it is paid:", passMark)
+
+		if checkout, err = db.FailCheckout("stub", checkout.ID); err != nil || checkout.Status != db.CheckoutPaid {
+			t.Fatal("\t\tShould not fail a paid checkout:", failMark, err, checkout.Status)
+		}
+		t.Log("\t\tShould not fail a paid checkout:", passMark)
+	}
+}
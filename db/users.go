@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mcctor/marauders/geo"
 )
 
 type User struct {
@@ -19,9 +22,20 @@ type User struct {
 
 // NewDevice creates a new device with the passed deviceID
 // associated to the owning user of this struct and returns its
-// device struct.
+// device struct. An *UpgradeRequiredError is returned if the user
+// already owns as many devices as their plan allows.
 func (u *User) NewDevice(deviceID int) (Device, error) {
-	return newDeviceFor(u.Username, deviceID)
+	var device Device
+	insert := func(tx *sqlx.Tx) (err error) {
+		device, err = newDeviceFor(tx, u.Username, deviceID)
+		return err
+	}
+	err := insertWithinEntitlement(u.Username, func(plan Plan) int { return plan.MaxDevices }, "devices",
+		insert, "SELECT COUNT(*) FROM devices WHERE user = ?", u.Username)
+	if err != nil {
+		return Device{}, err
+	}
+	return device, nil
 }
 
 // Devices returns a slice of devices that are owned by the user this
@@ -30,23 +44,14 @@ func (u *User) Devices() (ownedDevices []Device, err error) {
 	return getDevicesFor(u.Username)
 }
 
-// Billings returns a slice of bills that have been charged to the user
-// this struct represents. The length of the slice is limited to the
-// limit passed to the function
-func (u *User) Billings(lim int) (billings []Billing, err error) {
-	return getBillsFor(u.Username, lim)
-}
-
-// CreditUserAmount method credits the user represented by this struct
-// the amount passed.
-func (u *User) CreditUserAmount(amount float64) (Billing, error) {
-	return billUserFor(u.Username, amount, true)
-}
-
-// DebitUserAmount method debits the user represented by this struct
-// the amount passed.
-func (u *User) DebitUserAmount(amount float64) (Billing, error) {
-	return billUserFor(u.Username, amount, false)
+// Device returns the device with the passed deviceID if it is owned by the user this
+// struct represents.
+func (u *User) Device(deviceID int) (device Device, err error) {
+	err = db.Get(&device, "SELECT * FROM devices WHERE id = ? AND user = ?", deviceID, u.Username)
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to get device<%d> for user<%s>: %v", deviceID, u.Username, err)
+	}
+	return device, nil
 }
 
 // NewAuthToken creates a new authentication token for the user
@@ -74,11 +79,22 @@ func (u *User) Password() (*Password, error) {
 }
 
 // NewCloak creates a new cloak that is associated to this struct's user
-// using the passed in parameters.
+// using the passed in parameters. An *UpgradeRequiredError is returned
+// if the user already has as many cloaks as their plan allows.
 func (u *User) NewCloak(name, description string, wake, sleep, duration time.Time, accuracy string,
 	memberLimit int, memberVisible, creatorVisible, everyoneVisible, isPrivate bool) (*Cloak, error) {
-	return newCloak(u.Username, name, description, wake, sleep, duration, accuracy,
-		memberLimit, memberVisible, creatorVisible, everyoneVisible, isPrivate)
+	var cloak *Cloak
+	insert := func(tx *sqlx.Tx) (err error) {
+		cloak, err = newCloak(tx, u.Username, name, description, wake, sleep, duration, accuracy,
+			memberLimit, memberVisible, creatorVisible, everyoneVisible, isPrivate)
+		return err
+	}
+	err := insertWithinEntitlement(u.Username, func(plan Plan) int { return plan.MaxCloaks }, "cloaks",
+		insert, "SELECT COUNT(*) FROM cloaks WHERE user = ?", u.Username)
+	if err != nil {
+		return &Cloak{}, err
+	}
+	return cloak, nil
 }
 
 // Cloaks returns all the cloaks that are owned by the user
@@ -92,6 +108,23 @@ func (u *User) InviteLinks() ([]*CloakInviteLink, error) {
 	return getCloakInviteLinksFor(u.Username)
 }
 
+// NewCircleGeofence creates a personal geofence for this user, covering the circle of the
+// passed radius in metres around the center. It applies to all the devices of the user.
+func (u *User) NewCircleGeofence(name string, center geo.Point, radius float64) (*Geofence, error) {
+	return newCircleGeofence(u.Username, sql.NullString{}, name, center, radius, DefaultGeofenceHysteresis)
+}
+
+// NewPolygonGeofence creates a personal geofence for this user, covering the area within
+// the passed polygon. It applies to all the devices of the user.
+func (u *User) NewPolygonGeofence(name string, polygon geo.Polygon) (*Geofence, error) {
+	return newPolygonGeofence(u.Username, sql.NullString{}, name, polygon, DefaultGeofenceHysteresis)
+}
+
+// Geofences returns all the geofences created by this user, including those of their cloaks.
+func (u *User) Geofences() ([]*Geofence, error) {
+	return getGeofencesFor(u.Username)
+}
+
 // Update function commits the struct fields for User into
 // the users table. It can only be used in updating every other field
 // except for the username column.
@@ -161,11 +194,12 @@ func NewUser(username, email string) (*User, error) {
 	if err != nil {
 		return &User{}, fmt.Errorf("failed to create new user<%s>: %v", username, err)
 	}
-	_, err = newBillings(username)
-	if err != nil {
+	// users signing up now start on the free plan rather than being grandfathered
+	if _, err := newUser.Subscribe(PlanFree, time.Now(), time.Time{}); err != nil {
 		return &User{}, fmt.Errorf("failed to create new user<%s>: %v", username, err)
 	}
 
+	publish(UserCreated{User: newUser})
 	return newUser, nil
 }
 
@@ -0,0 +1,40 @@
+package geo
+
+import "math"
+
+// EarthRadius is the mean radius of the earth in metres.
+const EarthRadius = 6371008.8
+
+// Point is a position on the earth given in decimal degrees.
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Distance returns the great-circle distance in metres between the two points,
+// computed with the haversine formula.
+func Distance(from, to Point) float64 {
+	lat1, lat2 := radians(from.Latitude), radians(to.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(to.Longitude - from.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// IsValid checks whether the point lies within the range of valid latitudes and longitudes.
+func (p Point) IsValid() bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+// project maps the point onto a flat plane in metres centred on the origin. It is only
+// accurate over the short distances it is used for.
+func project(origin, p Point) (x, y float64) {
+	x = radians(p.Longitude-origin.Longitude) * math.Cos(radians(origin.Latitude)) * EarthRadius
+	y = radians(p.Latitude-origin.Latitude) * EarthRadius
+	return
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
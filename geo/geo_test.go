@@ -0,0 +1,135 @@
+package geo
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+func TestDistance(t *testing.T) {
+	t.Log("Given the need to test the distance between two points a degree of longitude apart on the equator.")
+	{
+		distance := Distance(Point{0, 0}, Point{0, 1})
+		if math.Abs(distance-111195) > 1 {
+			t.Fatal("\t\tShould be about 111195 metres:", failMark, distance)
+		}
+		t.Log("\t\tShould be about 111195 metres:", passMark, distance)
+	}
+}
+
+func TestPolygon(t *testing.T) {
+	square := Polygon{{0, 0}, {0, 0.01}, {0.01, 0.01}, {0.01, 0}}
+
+	t.Log("Given the need to test whether points lie inside a polygon.")
+	{
+		if !square.Contains(Point{0.005, 0.005}) || square.Contains(Point{0.02, 0.005}) {
+			t.Fatal("\t\tShould only contain points within its edges:", failMark)
+		}
+		t.Log("\t\tShould only contain points within its edges", passMark)
+	}
+
+	t.Log("Given the need to test how deep a polygon is.")
+	{
+		// a strip about 40 metres wide and a kilometre long
+		strip := Polygon{{0, 0}, {0, 0.01}, {0.00036, 0.01}, {0.00036, 0}}
+		depth := strip.Depth()
+		if depth > 20.1 || depth < 15 {
+			t.Fatal("\t\tShould be about half the width of the strip:", failMark, depth)
+		}
+		t.Log("\t\tShould be about half the width of the strip:", passMark, depth)
+	}
+
+	t.Log("Given the need to test the distance from a point inside a polygon to its edge.")
+	{
+		distance := square.SignedDistance(Point{0.005, 0.001})
+		if math.Abs(distance+111.2) > 1 {
+			t.Fatal("\t\tShould be about 111 metres inside the edge:", failMark, distance)
+		}
+		t.Log("\t\tShould be about 111 metres inside the edge:", passMark, distance)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	// a straight line along the equator with one point 1 km off it
+	track := []Point{{0, 0}, {0, 0.001}, {0, 0.002}, {0.009, 0.003}, {0, 0.004}, {0, 0.005}}
+
+	t.Log("Given the need to test simplifying a track.")
+	{
+		kept := Simplify(track, 10)
+		if len(kept) != 5 || kept[0] != 0 || kept[1] != 2 || kept[2] != 3 || kept[4] != 5 {
+			t.Fatal("\t\tShould keep the ends and the points shaping the detour:", failMark, kept)
+		}
+		t.Log("\t\tShould keep the ends and the points shaping the detour:", passMark, kept)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for i := 0; i < 600; i++ {
+		times = append(times, start.Add(time.Duration(i)*time.Second))
+	}
+
+	t.Log("Given the need to test downsampling a track to one point per minute.")
+	{
+		kept := Downsample(times, time.Minute)
+		if len(kept) != 11 || kept[0] != 0 || kept[len(kept)-1] != 599 {
+			t.Fatal("\t\tShould keep a point a minute and the last point:", failMark, len(kept))
+		}
+		t.Log("\t\tShould keep a point a minute and the last point:", passMark, len(kept))
+	}
+}
+
+func TestGeohash(t *testing.T) {
+	t.Log("Given the need to test encoding a point as a geohash.")
+	{
+		if hash := Geohash(Point{57.64911, 10.40744}, 11); hash != "u4pruydqqvj" {
+			t.Fatal("\t\tShould match the reference geohash:", failMark, hash)
+		}
+		t.Log("\t\tShould match the reference geohash:", passMark)
+	}
+
+	t.Log("Given the need to test covering a box with geohash cells.")
+	{
+		center := Point{-1.2921, 36.8219}
+		box := BoxAround(center, 1000)
+		cells := CoveringGeohashes(box, 16)
+		if len(cells) == 0 || len(cells) > 16 {
+			t.Fatal("\t\tShould cover the box with at most the allowed cells:", failMark, cells)
+		}
+		t.Log("\t\tShould cover the box with at most the allowed cells:", passMark, cells)
+
+		corners := []Point{center, {box.South, box.West}, {box.North, box.East}, {box.South, box.East}}
+		for _, corner := range corners {
+			hash, covered := Geohash(corner, MaxGeohashPrecision), false
+			for _, cell := range cells {
+				covered = covered || strings.HasPrefix(hash, cell)
+			}
+			if !covered {
+				t.Fatal("\t\tShould cover every point within the box:", failMark, corner)
+			}
+		}
+		t.Log("\t\tShould cover every point within the box:", passMark)
+	}
+}
+
+func TestTileOf(t *testing.T) {
+	t.Log("Given the need to test finding the tile a point lies in.")
+	{
+		tile := TileOf(Point{51.5074, -0.1278}, 10)
+		if tile != (Tile{Z: 10, X: 511, Y: 340}) {
+			t.Fatal("\t\tShould match the slippy map tile:", failMark, tile)
+		}
+		t.Log("\t\tShould match the slippy map tile:", passMark, tile)
+		if !tile.Bounds().Contains(Point{51.5074, -0.1278}) {
+			t.Fatal("\t\tShould have bounds holding the point:", failMark, tile.Bounds())
+		}
+		t.Log("\t\tShould have bounds holding the point:", passMark)
+	}
+}
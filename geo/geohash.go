@@ -0,0 +1,138 @@
+package geo
+
+import (
+	"math"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// MaxGeohashPrecision is the longest geohash encoded, with cells about 4 cm across.
+const MaxGeohashPrecision = 12
+
+// Box is an area bounded by two parallels and two meridians. Boxes crossing the
+// antimeridian are not supported.
+type Box struct {
+	South, West, North, East float64
+}
+
+// Contains checks whether the point lies within the box, edges included.
+func (b Box) Contains(p Point) bool {
+	return p.Latitude >= b.South && p.Latitude <= b.North && p.Longitude >= b.West && p.Longitude <= b.East
+}
+
+// IsValid checks whether the box has its edges the right way round and within range.
+func (b Box) IsValid() bool {
+	return b.South <= b.North && b.West <= b.East &&
+		Point{b.South, b.West}.IsValid() && Point{b.North, b.East}.IsValid()
+}
+
+// Widen returns the box grown by the passed number of degrees on every side, clamped to
+// the poles and the antimeridian.
+func (b Box) Widen(latitude, longitude float64) Box {
+	return Box{
+		South: math.Max(-90, b.South-latitude),
+		West:  math.Max(-180, b.West-longitude),
+		North: math.Min(90, b.North+latitude),
+		East:  math.Min(180, b.East+longitude),
+	}
+}
+
+// BoxAround returns the smallest box holding the circle of the passed radius in metres
+// around the center, clamped to the poles and the antimeridian.
+func BoxAround(center Point, radius float64) Box {
+	latDelta := radius / EarthRadius * 180 / math.Pi
+	lonDelta := 180.0
+	if cos := math.Cos(radians(center.Latitude)); cos > 1e-9 {
+		lonDelta = math.Min(180, latDelta/cos)
+	}
+	return Box{
+		South: math.Max(-90, center.Latitude-latDelta),
+		West:  math.Max(-180, center.Longitude-lonDelta),
+		North: math.Min(90, center.Latitude+latDelta),
+		East:  math.Min(180, center.Longitude+lonDelta),
+	}
+}
+
+// Geohash encodes the point as a geohash of the passed number of characters. Points
+// sharing a prefix lie within the cell that prefix encodes.
+func Geohash(p Point, precision int) string {
+	south, north, west, east := -90.0, 90.0, -180.0, 180.0
+	var hash strings.Builder
+	bit, char, evenBit := 0, 0, true
+	for hash.Len() < precision {
+		// bits alternate between longitude and latitude, starting with longitude
+		if evenBit {
+			mid := (west + east) / 2
+			if p.Longitude >= mid {
+				char, west = char<<1|1, mid
+			} else {
+				char, east = char<<1, mid
+			}
+		} else {
+			mid := (south + north) / 2
+			if p.Latitude >= mid {
+				char, south = char<<1|1, mid
+			} else {
+				char, north = char<<1, mid
+			}
+		}
+		evenBit = !evenBit
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[char])
+			bit, char = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// CoveringGeohashes returns geohash cells that together cover the box, at the finest
+// precision for which no more than maxCells are needed. A point within the box has a
+// geohash starting with one of them.
+func CoveringGeohashes(b Box, maxCells int) []string {
+	precision := MaxGeohashPrecision
+	for ; precision > 1; precision-- {
+		if rows, columns := geohashSpan(b, precision); rows*columns <= maxCells {
+			break
+		}
+	}
+	return CoveringGeohashesAt(b, precision)
+}
+
+// CoveringGeohashesAt returns the geohash cells of the passed precision that together
+// cover the box.
+func CoveringGeohashesAt(b Box, precision int) []string {
+	latCell, lonCell := geohashCellSize(precision)
+	rows, columns := geohashSpan(b, precision)
+	firstRow, firstColumn := math.Floor((b.South+90)/latCell), math.Floor((b.West+180)/lonCell)
+
+	var cells []string
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			// encode the center of each cell, which is clear of the cell's edges
+			center := Point{
+				Latitude:  math.Min(90, (firstRow+float64(row)+0.5)*latCell-90),
+				Longitude: math.Min(180, (firstColumn+float64(column)+0.5)*lonCell-180),
+			}
+			cells = append(cells, Geohash(center, precision))
+		}
+	}
+	return cells
+}
+
+// geohashSpan returns how many rows and columns of cells of the passed precision the
+// box stretches over.
+func geohashSpan(b Box, precision int) (rows, columns int) {
+	latCell, lonCell := geohashCellSize(precision)
+	rows = int(math.Min(math.Floor((b.North+90)/latCell), math.Ceil(180/latCell)-1)-
+		math.Floor((b.South+90)/latCell)) + 1
+	columns = int(math.Min(math.Floor((b.East+180)/lonCell), math.Ceil(360/lonCell)-1)-
+		math.Floor((b.West+180)/lonCell)) + 1
+	return
+}
+
+// geohashCellSize returns the height and width in degrees of a geohash cell.
+func geohashCellSize(precision int) (lat, lon float64) {
+	bits := 5 * precision
+	return 180 / math.Pow(2, float64(bits/2)), 360 / math.Pow(2, float64(bits-bits/2))
+}
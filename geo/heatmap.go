@@ -0,0 +1,39 @@
+package geo
+
+import "sort"
+
+// Heatmap counts points into the tiles of a zoom level.
+type Heatmap struct {
+	Zoom   int
+	counts map[Tile]int
+}
+
+// HeatCell is a tile of a heatmap and the number of points that fell in it.
+type HeatCell struct {
+	Tile  Tile
+	Count int
+}
+
+func NewHeatmap(zoom int) *Heatmap {
+	return &Heatmap{Zoom: zoom, counts: make(map[Tile]int)}
+}
+
+// Add counts the point in the tile it lies in.
+func (h *Heatmap) Add(p Point) {
+	h.counts[TileOf(p, h.Zoom)]++
+}
+
+// Cells returns the tiles any point fell in, from north west to south east.
+func (h *Heatmap) Cells() []HeatCell {
+	cells := make([]HeatCell, 0, len(h.counts))
+	for tile, count := range h.counts {
+		cells = append(cells, HeatCell{Tile: tile, Count: count})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Tile.Y != cells[j].Tile.Y {
+			return cells[i].Tile.Y < cells[j].Tile.Y
+		}
+		return cells[i].Tile.X < cells[j].Tile.X
+	})
+	return cells
+}
@@ -0,0 +1,84 @@
+package geo
+
+import "math"
+
+// Polygon is a closed ring of points. The last point is joined back to the first.
+type Polygon []Point
+
+// Contains checks whether the point lies inside the polygon, using the even-odd rule.
+func (poly Polygon) Contains(p Point) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) &&
+			p.Longitude < (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// DistanceToEdge returns the distance in metres from the point to the nearest edge
+// of the polygon.
+func (poly Polygon) DistanceToEdge(p Point) float64 {
+	nearest := math.Inf(1)
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		nearest = math.Min(nearest, distanceToSegment(p, poly[j], poly[i]))
+	}
+	return nearest
+}
+
+// SignedDistance returns the distance in metres from the point to the edge of the
+// polygon, negative when the point lies inside it.
+func (poly Polygon) SignedDistance(p Point) float64 {
+	distance := poly.DistanceToEdge(p)
+	if poly.Contains(p) {
+		return -distance
+	}
+	return distance
+}
+
+// depthSamples is how many points along each side of its bounding box a polygon is sampled
+// at to find its depth.
+const depthSamples = 32
+
+// Depth returns how far in metres the point deepest inside the polygon is from its edge,
+// half the width of the widest part of it. It is found by sampling a grid over the polygon,
+// so it may come out a little shallower than it is, never deeper.
+func (poly Polygon) Depth() float64 {
+	if len(poly) == 0 {
+		return 0
+	}
+	south, north, west, east := poly[0].Latitude, poly[0].Latitude, poly[0].Longitude, poly[0].Longitude
+	for _, p := range poly {
+		south, north = math.Min(south, p.Latitude), math.Max(north, p.Latitude)
+		west, east = math.Min(west, p.Longitude), math.Max(east, p.Longitude)
+	}
+	depth := 0.0
+	for i := 0; i <= depthSamples; i++ {
+		for j := 0; j <= depthSamples; j++ {
+			p := Point{
+				Latitude:  south + (north-south)*float64(i)/depthSamples,
+				Longitude: west + (east-west)*float64(j)/depthSamples,
+			}
+			if poly.Contains(p) {
+				depth = math.Max(depth, poly.DistanceToEdge(p))
+			}
+		}
+	}
+	return depth
+}
+
+// distanceToSegment returns the distance in metres from the point to the nearest point
+// on the segment between a and b.
+func distanceToSegment(p, a, b Point) float64 {
+	ax, ay := project(p, a)
+	bx, by := project(p, b)
+	dx, dy := bx-ax, by-ay
+
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSq))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
@@ -0,0 +1,64 @@
+package geo
+
+import "time"
+
+// Simplify reduces a track to the points needed to keep its shape within the tolerance
+// in metres, using the Douglas-Peucker algorithm. It returns the indices of the points
+// kept, in order, always including the first and the last.
+func Simplify(track []Point, tolerance float64) []int {
+	if len(track) < 3 {
+		return allIndices(len(track))
+	}
+	keep := make([]bool, len(track))
+	keep[0], keep[len(track)-1] = true, true
+	spans := [][2]int{{0, len(track) - 1}}
+	for len(spans) > 0 {
+		first, last := spans[len(spans)-1][0], spans[len(spans)-1][1]
+		spans = spans[:len(spans)-1]
+
+		farthest, farthestDistance := -1, tolerance
+		for i := first + 1; i < last; i++ {
+			if distance := distanceToSegment(track[i], track[first], track[last]); distance > farthestDistance {
+				farthest, farthestDistance = i, distance
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		spans = append(spans, [2]int{first, farthest}, [2]int{farthest, last})
+	}
+
+	var kept []int
+	for i, isKept := range keep {
+		if isKept {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// Downsample thins a track to at most one point per interval, keeping the first point
+// recorded in each. The times have to be in order, either oldest or latest first. It
+// returns the indices of the points kept, always including the first and the last.
+func Downsample(times []time.Time, interval time.Duration) []int {
+	if len(times) < 3 || interval <= 0 {
+		return allIndices(len(times))
+	}
+	kept := []int{0}
+	for i := 1; i < len(times)-1; i++ {
+		if times[i].Truncate(interval) != times[kept[len(kept)-1]].Truncate(interval) {
+			kept = append(kept, i)
+		}
+	}
+	kept = append(kept, len(times)-1)
+	return kept
+}
+
+func allIndices(n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
@@ -0,0 +1,64 @@
+package geo
+
+import "math"
+
+const (
+	// MaxZoom is the deepest zoom level tiles are cut at.
+	MaxZoom = 22
+	// maxMercatorLatitude is how far north and south the web mercator projection reaches.
+	maxMercatorLatitude = 85.05112878
+)
+
+// Tile is a square of the web mercator projection used by slippy maps. At zoom level z
+// the world is cut into 2^z by 2^z tiles, numbered from the north west corner.
+type Tile struct {
+	Z, X, Y int
+}
+
+// TileOf returns the tile at the zoom level the point lies in.
+func TileOf(p Point, zoom int) Tile {
+	x, y := mercator(p, zoom)
+	last := float64(int(1)<<uint(zoom)) - 1
+	return Tile{
+		Z: zoom,
+		X: int(math.Max(0, math.Min(last, math.Floor(x)))),
+		Y: int(math.Max(0, math.Min(last, math.Floor(y)))),
+	}
+}
+
+// IsValid checks whether the tile exists at its zoom level.
+func (t Tile) IsValid() bool {
+	n := 1 << uint(t.Z)
+	return t.Z >= 0 && t.Z <= MaxZoom && t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Bounds returns the area the tile covers.
+func (t Tile) Bounds() Box {
+	north, west := tileCorner(t.X, t.Y, t.Z)
+	south, east := tileCorner(t.X+1, t.Y+1, t.Z)
+	return Box{South: south, West: west, North: north, East: east}
+}
+
+// Project returns where the point falls within the tile, as fractions of its width and
+// height from its north west corner. Points outside the tile fall outside 0 to 1.
+func (t Tile) Project(p Point) (x, y float64) {
+	x, y = mercator(p, t.Z)
+	return x - float64(t.X), y - float64(t.Y)
+}
+
+// mercator returns the position of the point in units of tiles at the zoom level.
+func mercator(p Point, zoom int) (x, y float64) {
+	n := float64(int(1) << uint(zoom))
+	latitude := radians(math.Max(-maxMercatorLatitude, math.Min(maxMercatorLatitude, p.Latitude)))
+	x = (p.Longitude + 180) / 360 * n
+	y = (1 - math.Log(math.Tan(latitude)+1/math.Cos(latitude))/math.Pi) / 2 * n
+	return
+}
+
+// tileCorner returns the latitude and longitude of the north west corner of a tile.
+func tileCorner(x, y, zoom int) (latitude, longitude float64) {
+	n := float64(int(1) << uint(zoom))
+	longitude = float64(x)/n*360 - 180
+	latitude = math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+	return
+}
@@ -0,0 +1,114 @@
+package geocode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mcctor/marauders/geo"
+)
+
+// columns of the GeoNames geoname table
+const (
+	geonamesName       = 1
+	geonamesLatitude   = 4
+	geonamesLongitude  = 5
+	geonamesCountry    = 8
+	geonamesPopulation = 14
+	geonamesColumns    = 15
+)
+
+// LoadCities adds the cities of a tab separated GeoNames dump to the geocoder.
+func (g *Geocoder) LoadCities(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		columns := strings.Split(scanner.Text(), "\t")
+		if len(columns) < geonamesColumns {
+			return fmt.Errorf("geonames line %d has %d columns", line, len(columns))
+		}
+		latitude, err := strconv.ParseFloat(columns[geonamesLatitude], 64)
+		if err != nil {
+			return fmt.Errorf("geonames line %d: bad latitude: %v", line, err)
+		}
+		longitude, err := strconv.ParseFloat(columns[geonamesLongitude], 64)
+		if err != nil {
+			return fmt.Errorf("geonames line %d: bad longitude: %v", line, err)
+		}
+		population, _ := strconv.Atoi(columns[geonamesPopulation])
+		g.addCity(City{
+			Name:        columns[geonamesName],
+			CountryCode: columns[geonamesCountry],
+			Point:       geo.Point{Latitude: latitude, Longitude: longitude},
+			Population:  population,
+		})
+	}
+	return scanner.Err()
+}
+
+type countryFeature struct {
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// LoadCountries adds the countries of a GeoJSON FeatureCollection of Polygon and
+// MultiPolygon features to the geocoder. Names and codes are read from the properties
+// Natural Earth and most other boundary datasets use. Holes in polygons are ignored.
+func (g *Geocoder) LoadCountries(r io.Reader) error {
+	var collection struct {
+		Features []countryFeature `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return fmt.Errorf("bad country boundaries: %v", err)
+	}
+	for _, feature := range collection.Features {
+		var rings [][][][2]float64
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+				return fmt.Errorf("bad country boundary: %v", err)
+			}
+			rings = append(rings, polygon)
+		case "MultiPolygon":
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &rings); err != nil {
+				return fmt.Errorf("bad country boundary: %v", err)
+			}
+		default:
+			continue
+		}
+
+		country := Country{
+			Code: firstProperty(feature.Properties, "ISO_A2", "iso_a2", "ISO2", "code"),
+			Name: firstProperty(feature.Properties, "ADMIN", "NAME", "name", "admin"),
+		}
+		for _, polygon := range rings {
+			if len(polygon) == 0 {
+				continue
+			}
+			// the first ring is the outer boundary, the rest are holes
+			outer := make(geo.Polygon, len(polygon[0]))
+			for i, position := range polygon[0] {
+				outer[i] = geo.Point{Latitude: position[1], Longitude: position[0]}
+			}
+			country.Polygons = append(country.Polygons, outer)
+		}
+		g.addCountry(country)
+	}
+	return nil
+}
+
+func firstProperty(properties map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if value, ok := properties[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
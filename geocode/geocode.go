@@ -0,0 +1,167 @@
+// Package geocode names the city and country a point lies in, offline, from a GeoNames
+// cities dump and a GeoJSON file of country boundaries.
+package geocode
+
+import (
+	"math"
+	"os"
+
+	"github.com/mcctor/marauders/geo"
+)
+
+const (
+	LevelCity    = "city"
+	LevelCountry = "country"
+
+	// cityIndexPrecision is the length of the geohash cells cities are indexed by, about
+	// 40 by 20 kilometres.
+	cityIndexPrecision = 4
+	// maxCityDistance is how far in metres a point may be from a city to be named after it.
+	maxCityDistance = 50000.0
+)
+
+// Default is the geocoder used across the server. It is nil, and names no places, unless
+// a dataset is loaded at start up.
+var Default *Geocoder
+
+// Place is where a point lies. Either part may be empty if the datasets do not cover it.
+type Place struct {
+	City        string
+	Country     string
+	CountryCode string
+}
+
+// Label names the place at the passed level of detail, country or city.
+func (p Place) Label(level string) string {
+	if level == LevelCountry || p.City == "" {
+		return p.Country
+	}
+	if p.Country == "" {
+		return p.City
+	}
+	return p.City + ", " + p.Country
+}
+
+// City is a populated place from the GeoNames dataset.
+type City struct {
+	Name        string
+	CountryCode string
+	Point       geo.Point
+	Population  int
+}
+
+// Country is the land within a country's borders.
+type Country struct {
+	Code     string
+	Name     string
+	Polygons []geo.Polygon
+	bounds   []geo.Box
+}
+
+// Geocoder looks up the places points lie in.
+type Geocoder struct {
+	cities    []City
+	cityCells map[string][]int
+	countries []Country
+	// countryNames names countries by code, for cities lying outside every boundary
+	countryNames map[string]string
+}
+
+// Load builds a geocoder from a GeoNames cities file, such as cities15000.txt, and a
+// GeoJSON FeatureCollection of country boundaries.
+func Load(citiesPath, countriesPath string) (*Geocoder, error) {
+	geocoder := New()
+	countriesFile, err := os.Open(countriesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer countriesFile.Close()
+	if err := geocoder.LoadCountries(countriesFile); err != nil {
+		return nil, err
+	}
+	citiesFile, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer citiesFile.Close()
+	if err := geocoder.LoadCities(citiesFile); err != nil {
+		return nil, err
+	}
+	return geocoder, nil
+}
+
+// New returns a geocoder with no places, to be filled with LoadCities and LoadCountries.
+func New() *Geocoder {
+	return &Geocoder{cityCells: make(map[string][]int), countryNames: make(map[string]string)}
+}
+
+// Lookup returns the place the point lies in. A nil geocoder finds no place.
+func (g *Geocoder) Lookup(p geo.Point) Place {
+	if g == nil {
+		return Place{}
+	}
+	var place Place
+	if country, ok := g.countryOf(p); ok {
+		place.Country, place.CountryCode = country.Name, country.Code
+	}
+	if city, ok := g.nearestCity(p); ok {
+		place.City = city.Name
+		if place.CountryCode == "" {
+			place.CountryCode, place.Country = city.CountryCode, g.countryNames[city.CountryCode]
+		}
+	}
+	return place
+}
+
+func (g *Geocoder) countryOf(p geo.Point) (Country, bool) {
+	for _, country := range g.countries {
+		for i, polygon := range country.Polygons {
+			if country.bounds[i].Contains(p) && polygon.Contains(p) {
+				return country, true
+			}
+		}
+	}
+	return Country{}, false
+}
+
+// nearestCity returns the city nearest to the point within the maximum city distance.
+func (g *Geocoder) nearestCity(p geo.Point) (City, bool) {
+	box := geo.BoxAround(p, maxCityDistance)
+	nearest, nearestDistance := -1, math.Inf(1)
+	for _, cell := range geo.CoveringGeohashesAt(box, cityIndexPrecision) {
+		for _, i := range g.cityCells[cell] {
+			if distance := geo.Distance(p, g.cities[i].Point); distance < nearestDistance {
+				nearest, nearestDistance = i, distance
+			}
+		}
+	}
+	if nearest < 0 || nearestDistance > maxCityDistance {
+		return City{}, false
+	}
+	return g.cities[nearest], true
+}
+
+func (g *Geocoder) addCity(city City) {
+	cell := geo.Geohash(city.Point, cityIndexPrecision)
+	g.cityCells[cell] = append(g.cityCells[cell], len(g.cities))
+	g.cities = append(g.cities, city)
+}
+
+func (g *Geocoder) addCountry(country Country) {
+	for _, polygon := range country.Polygons {
+		country.bounds = append(country.bounds, boundsOf(polygon))
+	}
+	g.countries = append(g.countries, country)
+	if _, ok := g.countryNames[country.Code]; !ok {
+		g.countryNames[country.Code] = country.Name
+	}
+}
+
+func boundsOf(polygon geo.Polygon) geo.Box {
+	bounds := geo.Box{South: 90, West: 180, North: -90, East: -180}
+	for _, p := range polygon {
+		bounds.South, bounds.North = math.Min(bounds.South, p.Latitude), math.Max(bounds.North, p.Latitude)
+		bounds.West, bounds.East = math.Min(bounds.West, p.Longitude), math.Max(bounds.East, p.Longitude)
+	}
+	return bounds
+}
@@ -0,0 +1,58 @@
+package geocode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mcctor/marauders/geo"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+const (
+	testCities = "184745\tNairobi\tNairobi\t\t-1.28333\t36.81667\tP\tPPLC\tKE\t\t30\t\t\t\t2750547\t\t1684\tAfrica/Nairobi\t2019-09-05\n" +
+		"186301\tMombasa\tMombasa\t\t-4.05466\t39.66359\tP\tPPLA\tKE\t\t19\t\t\t\t799668\t\t24\tAfrica/Nairobi\t2019-09-05\n"
+	testCountries = `{"type": "FeatureCollection", "features": [{"type": "Feature",
+		"properties": {"ADMIN": "Kenya", "ISO_A2": "KE"},
+		"geometry": {"type": "Polygon", "coordinates": [[[33.9, -4.7], [41.9, -4.7], [41.9, 5.0], [33.9, 5.0], [33.9, -4.7]]]}}]}`
+)
+
+func TestLookup(t *testing.T) {
+	geocoder := New()
+	if err := geocoder.LoadCountries(strings.NewReader(testCountries)); err != nil {
+		t.Fatal(err)
+	}
+	if err := geocoder.LoadCities(strings.NewReader(testCities)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Given the need to test naming the place a point lies in.")
+	{
+		place := geocoder.Lookup(geo.Point{Latitude: -1.2921, Longitude: 36.8219})
+		if place.Label(LevelCity) != "Nairobi, Kenya" || place.Label(LevelCountry) != "Kenya" {
+			t.Fatal("\t\tShould name the nearest city and the country:", failMark, place)
+		}
+		t.Log("\t\tShould name the nearest city and the country:", passMark, place)
+	}
+
+	t.Log("Given the need to test a point far from any city.")
+	{
+		place := geocoder.Lookup(geo.Point{Latitude: 3.0, Longitude: 40.0})
+		if place.City != "" || place.Country != "Kenya" {
+			t.Fatal("\t\tShould only name the country:", failMark, place)
+		}
+		t.Log("\t\tShould only name the country:", passMark, place)
+	}
+
+	t.Log("Given the need to test a geocoder with no datasets.")
+	{
+		var geocoder *Geocoder
+		if place := geocoder.Lookup(geo.Point{Latitude: -1.2921, Longitude: 36.8219}); place != (Place{}) {
+			t.Fatal("\t\tShould find no place:", failMark, place)
+		}
+		t.Log("\t\tShould find no place:", passMark)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/mcctor/marauders/db"
+)
+
+// authenticatedDevice returns the device whose credential was passed as the password of
+// HTTP basic auth, or in the Token header for clients that cannot do basic auth. If the
+// request carries no valid credential, an error response is written and false returned.
+func authenticatedDevice(writer http.ResponseWriter, request *http.Request) (db.Device, bool) {
+	secret := request.Header.Get("Token")
+	if _, password, ok := request.BasicAuth(); ok {
+		secret = password
+	}
+	if secret == "" {
+		writer.Header().Set("WWW-Authenticate", "Basic realm=\"marauders\"")
+		http.Error(writer, "{\"status\": \"request unauthorized, no device credential\"}", http.StatusUnauthorized)
+		return db.Device{}, false
+	}
+	device, err := db.GetDeviceByCredential(secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"unknown device credential\"}", http.StatusForbidden)
+		return db.Device{}, false
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return db.Device{}, false
+	}
+	return device, true
+}
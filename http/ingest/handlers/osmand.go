@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+// knotsToMetresPerSecond converts the speed OsmAnd clients report, in knots.
+const knotsToMetresPerSecond = 0.514444
+
+// osmAnd records a location reported over the OsmAnd protocol, spoken by the OsmAnd app,
+// the Traccar client and many cheap tracker apps. The fields come as query or form
+// parameters, and the device is found by the id parameter, which has to be registered as
+// the device's osmand identifier. The protocol carries no other credential, so like any
+// server speaking it, the identifier is all that vouches for the device.
+func osmAnd(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		http.Error(writer, "", http.StatusBadRequest)
+		return
+	}
+	id := request.Form.Get("id")
+	if id == "" {
+		id = request.Form.Get("deviceid")
+	}
+	device, err := db.GetDeviceByIdentifier(db.ProtocolOsmAnd, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+
+	snapshot, ok := osmAndSnapshot(request)
+	if !ok {
+		http.Error(writer, "", http.StatusBadRequest)
+		return
+	}
+	err = device.RecordLocationSnapshot(snapshot)
+	var rejection *db.RejectedSnapshotError
+	// snapshots turned away are dropped rather than retried by the app
+	if err != nil && !errors.As(err, &rejection) && !errors.Is(err, db.ErrUpgradeRequired) {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// osmAndSnapshot reads a location snapshot from the parameters of an OsmAnd request. The
+// position is required, and the time defaults to when the request was received.
+func osmAndSnapshot(request *http.Request) (snapshot db.LocationSnapshot, ok bool) {
+	form := request.Form
+	latitude, err := strconv.ParseFloat(form.Get("lat"), 64)
+	if err != nil {
+		return snapshot, false
+	}
+	longitude, err := strconv.ParseFloat(form.Get("lon"), 64)
+	if err != nil {
+		return snapshot, false
+	}
+	recorded := time.Now()
+	if stamp := form.Get("timestamp"); stamp != "" {
+		if recorded, ok = parseOsmAndTime(stamp); !ok {
+			return snapshot, false
+		}
+	}
+
+	snapshot = db.LocationSnapshot{
+		TimeStamp: recorded.UTC().Format(utils.TimeFormatMilli),
+		Latitude:  latitude,
+		Longitude: longitude,
+		Altitude:  formFloat(form.Get("altitude")),
+		Accuracy:  formFloat(form.Get("accuracy")),
+		Bearing:   formFloat(form.Get("bearing")),
+		Provider:  sql.NullString{String: db.ProviderGPS, Valid: true},
+	}
+	if speed := formFloat(form.Get("speed")); speed.Valid {
+		snapshot.Speed = sql.NullFloat64{Float64: speed.Float64 * knotsToMetresPerSecond, Valid: true}
+	}
+	if battery := formFloat(form.Get("batt")); battery.Valid {
+		snapshot.Battery = sql.NullInt64{Int64: int64(battery.Float64), Valid: true}
+	}
+	return snapshot, true
+}
+
+// parseOsmAndTime reads the timestamp parameter, which clients send either as seconds or
+// milliseconds since the epoch, or as a formatted time.
+func parseOsmAndTime(stamp string) (time.Time, bool) {
+	if epoch, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+		// anything past the year 33658 in seconds is taken to be in milliseconds
+		if epoch > 1e12 {
+			return time.Unix(0, epoch*int64(time.Millisecond)), true
+		}
+		return time.Unix(epoch, 0), true
+	}
+	for _, layout := range []string{time.RFC3339Nano, utils.TimeFormat} {
+		if recorded, err := time.Parse(layout, stamp); err == nil {
+			return recorded, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func formFloat(value string) sql.NullFloat64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: parsed, Valid: true}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+// ownTracksMessage is the part of an OwnTracks message that is read. Only messages of
+// the location type are recorded, the rest are acknowledged and ignored.
+type ownTracksMessage struct {
+	Type      string   `json:"_type"`
+	Latitude  *float64 `json:"lat"`
+	Longitude *float64 `json:"lon"`
+	TimeStamp int64    `json:"tst"`
+	Accuracy  *float64 `json:"acc"`
+	Altitude  *float64 `json:"alt"`
+	// Velocity in kilometres per hour
+	Velocity  *float64 `json:"vel"`
+	Course    *float64 `json:"cog"`
+	Battery   *int64   `json:"batt"`
+	TrackerID string   `json:"tid"`
+}
+
+// ownTracksFriend is a location message describing another device, as OwnTracks expects
+// in the response to a publish.
+type ownTracksFriend struct {
+	Type      string  `json:"_type"`
+	TrackerID string  `json:"tid"`
+	Topic     string  `json:"topic"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	TimeStamp int64   `json:"tst"`
+	Accuracy  float64 `json:"acc,omitempty"`
+}
+
+// ownTracks records a location published by the OwnTracks app in HTTP mode, and answers
+// with the latest locations of the members of cloaks the device shares, for the app to
+// show as friends. Points turned away by the ingest filter are acknowledged all the same,
+// as the app would otherwise keep resending them.
+func ownTracks(writer http.ResponseWriter, request *http.Request) {
+	device, ok := authenticatedDevice(writer, request)
+	if !ok {
+		return
+	}
+	var message ownTracksMessage
+	if err := json.NewDecoder(request.Body).Decode(&message); err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if message.Type != "location" {
+		writer.Write([]byte("[]"))
+		return
+	}
+	if message.Latitude == nil || message.Longitude == nil || message.TimeStamp == 0 {
+		http.Error(writer, "{\"status\": \"location should have lat, lon and tst\"}", http.StatusBadRequest)
+		return
+	}
+
+	err := device.RecordLocationSnapshot(message.snapshot())
+	var rejection *db.RejectedSnapshotError
+	// snapshots turned away are dropped rather than retried by the app
+	if err != nil && !errors.As(err, &rejection) && !errors.Is(err, db.ErrUpgradeRequired) {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+
+	locations, err := device.FellowMemberLocations()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	friends := make([]ownTracksFriend, 0, len(locations))
+	for _, location := range locations {
+		friends = append(friends, newOwnTracksFriend(location))
+	}
+	json.NewEncoder(writer).Encode(friends)
+}
+
+func (message ownTracksMessage) snapshot() db.LocationSnapshot {
+	snapshot := db.LocationSnapshot{
+		TimeStamp: time.Unix(message.TimeStamp, 0).UTC().Format(utils.TimeFormatMilli),
+		Latitude:  *message.Latitude,
+		Longitude: *message.Longitude,
+		Accuracy:  nullFloat(message.Accuracy),
+		Altitude:  nullFloat(message.Altitude),
+		Bearing:   nullFloat(message.Course),
+	}
+	if message.Velocity != nil {
+		snapshot.Speed = sql.NullFloat64{Float64: *message.Velocity / 3.6, Valid: true}
+	}
+	if message.Battery != nil {
+		snapshot.Battery = sql.NullInt64{Int64: *message.Battery, Valid: true}
+	}
+	return snapshot
+}
+
+func newOwnTracksFriend(location db.LocationSnapshot) ownTracksFriend {
+	recorded, _ := time.Parse(utils.TimeFormat, location.TimeStamp)
+	// OwnTracks shows the tracker id, at most two characters, on the friend's marker
+	trackerID := strconv.Itoa(location.DeviceID)
+	if len(trackerID) > 2 {
+		trackerID = trackerID[len(trackerID)-2:]
+	}
+	return ownTracksFriend{
+		Type:      "location",
+		TrackerID: trackerID,
+		Topic:     fmt.Sprintf("owntracks/marauders/%d", location.DeviceID),
+		Latitude:  location.Latitude,
+		Longitude: location.Longitude,
+		TimeStamp: recorded.Unix(),
+		Accuracy:  location.Accuracy.Float64,
+	}
+}
+
+func nullFloat(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *value, Valid: true}
+}
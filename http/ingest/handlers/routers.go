@@ -0,0 +1,13 @@
+// Package handlers accepts location reports from third party tracking apps, each in the
+// protocol the app speaks, and feeds them into the location history of the reporting device.
+package handlers
+
+import marauderhttp "github.com/mcctor/marauders/http"
+
+func init() {
+	ingestRouter := marauderhttp.Router.PathPrefix("/v1/ingest").Subrouter()
+	ingestRouter.HandleFunc("/owntracks/", ownTracks).
+		Methods("POST")
+	ingestRouter.HandleFunc("/osmand/", osmAnd).
+		Methods("GET", "POST")
+}
@@ -21,10 +21,19 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Unwrap returns the underlying writer, so that handlers can reach its connection through
+// an http.ResponseController.
+func (w gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func ApplyGzipCompression(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		if !strings.Contains(request.Header.Get("Accept-Encoding"), "gzip") {
+		// websocket connections have to be hijacked, which the gzip writer does not allow
+		if !strings.Contains(request.Header.Get("Accept-Encoding"), "gzip") ||
+			strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
 			next.ServeHTTP(writer, request)
+			return
 		}
 		writer.Header().Set("Content-Encoding", "gzip")
 		gzipper := gzip.NewWriter(writer)
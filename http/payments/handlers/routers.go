@@ -0,0 +1,13 @@
+// Package handlers receives the webhooks payment providers report payments to, and serves
+// the checkout pages of the stub provider.
+package handlers
+
+import marauderhttp "github.com/mcctor/marauders/http"
+
+func init() {
+	paymentsRouter := marauderhttp.Router.PathPrefix("/v1/payments").Subrouter()
+	paymentsRouter.HandleFunc("/webhooks/{provider}/", webhook).
+		Methods("POST")
+	paymentsRouter.HandleFunc("/stub/checkouts/{checkout_id}/", stubCheckout).
+		Methods("GET", "POST")
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/payments"
+)
+
+// stubCheckout is the page a checkout of the stub provider is paid at. Posting the outcome
+// form parameter as paid or declined settles the checkout and has the stub deliver the
+// event to the webhook, as a real provider would once the user paid.
+func stubCheckout(writer http.ResponseWriter, request *http.Request) {
+	stub, ok := payments.Providers["stub"].(*payments.Stub)
+	if !ok {
+		http.Error(writer, "{\"status\": \"stub payments are not enabled\"}", http.StatusNotFound)
+		return
+	}
+	checkout, err := db.GetCheckout(stub.Name(), mux.Vars(request)["checkout_id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"no checkout with given id\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+
+	if request.Method == http.MethodPost {
+		outcome := request.FormValue("outcome")
+		if outcome != "paid" && outcome != "declined" {
+			http.Error(writer, "{\"status\": \"outcome should be one of paid or declined\"}", http.StatusBadRequest)
+			return
+		}
+		if _, err := stub.Settle(checkout, outcome == "paid"); err != nil {
+			log.Print(err)
+			http.Error(writer, "{\"status\": \"event could not be delivered\"}", http.StatusBadGateway)
+			return
+		}
+		if checkout, err = db.GetCheckout(stub.Name(), checkout.ID); err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+	}
+	fmt.Fprintf(writer, "{\"status\": %q, \"amount\": %q, \"user\": %q}",
+		checkout.Status, checkout.Money().String(), checkout.User)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/payments"
+)
+
+// webhook applies an event reported by a payment provider to its checkout. Providers
+// retry events until they are answered with 200 OK, so only events that can never be
+// applied are refused with a client error.
+func webhook(writer http.ResponseWriter, request *http.Request) {
+	provider, ok := payments.Providers[mux.Vars(request)["provider"]]
+	if !ok {
+		http.Error(writer, "{\"status\": \"no payment provider with given name\"}", http.StatusNotFound)
+		return
+	}
+	event, err := provider.Event(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"event is not signed by the provider\"}", http.StatusBadRequest)
+		return
+	}
+	checkout, err := payments.Apply(provider, event)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"no checkout with given id\"}", http.StatusNotFound)
+		return
+	} else if errors.Is(err, db.ErrCheckoutMismatch) {
+		log.Printf("event<%s> of provider<%s> does not match its checkout", event.ID, provider.Name())
+		http.Error(writer, "{\"status\": \"amount does not match the checkout\"}", http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		log.Printf("could not apply event<%s> of provider<%s>: %v", event.ID, provider.Name(), err)
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(writer, "{\"status\": %q}", checkout.Status)
+}
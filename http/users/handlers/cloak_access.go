@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/mcctor/marauders/db"
+)
+
+// visibleCloak fetches the cloak with the passed cloakID. If the cloak does not exist, or the
+// user is not allowed to see its members, an error response is written and false returned.
+func visibleCloak(writer http.ResponseWriter, cloakID, username string) (*db.Cloak, bool) {
+	cloak, err := db.GetCloakByID(cloakID)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no cloak with given id\"}", http.StatusNotFound)
+		return nil, false
+	}
+	visible, err := cloak.CanBeViewedBy(username)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !visible {
+		http.Error(writer, "{\"status\": \"cloak is not visible to user\"}", http.StatusForbidden)
+		return nil, false
+	}
+	return cloak, true
+}
+
+// ownedCloak fetches the cloak with the passed cloakID. If the cloak does not exist or was
+// not created by the user, an error response is written and false returned.
+func ownedCloak(writer http.ResponseWriter, cloakID, username string) (*db.Cloak, bool) {
+	cloak, err := db.GetCloakByID(cloakID)
+	if err != nil || cloak.User != username {
+		http.Error(writer, "{\"status\": \"no owned cloak with given id\"}", http.StatusNotFound)
+		return nil, false
+	}
+	return cloak, true
+}
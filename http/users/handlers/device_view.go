@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+)
+
+// deviceView is a device whose location history is being looked at, either by the user
+// who owns it or by a user seeing it through one of the cloaks it has joined.
+type deviceView struct {
+	Device db.Device
+	// Cloak is nil when the device is being looked at by its owner
+	Cloak *db.Cloak
+}
+
+// snapshotsBetween returns the part of the device's location history recorded between the
+// from and to time stamps that the viewer is allowed to see, oldest first.
+func (view deviceView) snapshotsBetween(from, to string) ([]db.LocationSnapshot, error) {
+	if view.Cloak == nil {
+		return view.Device.LocationSnapshotsBetween(from, to)
+	}
+	return view.Cloak.LocationSnapshotsForMemberBetween(view.Device, from, to)
+}
+
+// eachSnapshotBetween calls fn with each snapshot recorded between the from and to time
+// stamps that the viewer is allowed to see, oldest first.
+func (view deviceView) eachSnapshotBetween(from, to string, fn func(db.LocationSnapshot) error) error {
+	if view.Cloak == nil {
+		return view.Device.EachLocationSnapshotBetween(from, to, fn)
+	}
+	return view.Cloak.EachLocationSnapshotForMemberBetween(view.Device, from, to, fn)
+}
+
+// latestSnapshots returns up to lim of the most recent snapshots of the device that the
+// viewer is allowed to see, latest first.
+func (view deviceView) latestSnapshots(lim int) ([]db.LocationSnapshot, error) {
+	if view.Cloak == nil {
+		return view.Device.LocationSnapshots(lim)
+	}
+	return view.Cloak.LocationSnapshotsForMember(view.Device, lim)
+}
+
+// latestSnapshot returns the most recent snapshot of the device that the viewer is allowed
+// to see. The returned error wraps sql.ErrNoRows if there is none.
+func (view deviceView) latestSnapshot() (db.LocationSnapshot, error) {
+	if view.Cloak == nil {
+		return view.Device.LatestLocationSnapshot()
+	}
+	return view.Cloak.LatestMemberLocation(view.Device)
+}
+
+// resolveDeviceView returns the device named by the device_id of the request, as seen by
+// the requesting user. Under a cloak_id the device has to be a member of a cloak visible
+// to the user, otherwise it has to be owned by the user. If the device cannot be seen, an
+// error response is written and false returned.
+func resolveDeviceView(writer http.ResponseWriter, request *http.Request) (deviceView, bool) {
+	vars := mux.Vars(request)
+	deviceID, err := strconv.Atoi(vars["device_id"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no device with given id\"}", http.StatusNotFound)
+		return deviceView{}, false
+	}
+
+	if cloakID, ok := vars["cloak_id"]; ok {
+		cloak, ok := visibleCloak(writer, cloakID, vars["username"])
+		if !ok {
+			return deviceView{}, false
+		}
+		isMember, err := cloak.HasMember(deviceID)
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return deviceView{}, false
+		}
+		if !isMember {
+			http.Error(writer, "{\"status\": \"no member with given device id\"}", http.StatusNotFound)
+			return deviceView{}, false
+		}
+		device, err := db.GetDeviceByID(deviceID)
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return deviceView{}, false
+		}
+		return deviceView{Device: device, Cloak: cloak}, true
+	}
+
+	user, err := db.GetUser(vars["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return deviceView{}, false
+	}
+	device, err := user.Device(deviceID)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no device with given id\"}", http.StatusNotFound)
+		return deviceView{}, false
+	}
+	return deviceView{Device: device}, true
+}
@@ -17,7 +17,25 @@ func init() {
 	usersRouter.HandleFunc("/{username}/billings/", userBillings).
 		Methods("GET")
 
-	usersRouter.HandleFunc("/{username}/billings/{billing_id}/", userBilling).
+	usersRouter.HandleFunc("/{username}/billings/{billing_id:[0-9]+}/", userBilling).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/billings/checkouts/", userCheckouts).
+		Methods("GET", "POST")
+
+	usersRouter.HandleFunc("/{username}/billings/statements/", userStatements).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/billings/statements/{statement_number:[0-9]+}/", userStatement).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/plan/", userPlan).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/usage/", userUsage).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/usage/statements/", userUsageStatements).
 		Methods("GET")
 
 	usersRouter.HandleFunc("/{username}/cloaks/", userCloaks).
@@ -26,6 +44,21 @@ func init() {
 	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/", userCloak).
 		Methods("GET", "PUT", "DELETE")
 
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/proximity/", userCloakProximity).
+		Methods("GET", "PUT", "DELETE")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/proximity-events/", userCloakProximityEvents).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/retention/", userCloakRetention).
+		Methods("GET", "PUT", "DELETE")
+
+	usersRouter.HandleFunc("/{username}/retention/", userRetention).
+		Methods("GET", "PUT", "DELETE")
+
+	usersRouter.HandleFunc("/{username}/retention/runs/", userRetentionRuns).
+		Methods("GET")
+
 	usersRouter.HandleFunc("/{username}/invitation-links/", userInvitationLinks).
 		Methods("GET", "POST")
 
@@ -41,9 +74,78 @@ func init() {
 	usersRouter.HandleFunc("/{username}/devices/{device_id}/", userDevice).
 		Methods("GET", "DELETE")
 
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/credential/", userDeviceCredential).
+		Methods("POST", "DELETE")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/identifiers/", userDeviceIdentifiers).
+		Methods("GET", "POST")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/identifiers/{protocol}/{identifier}/",
+		userDeviceIdentifier).Methods("DELETE")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/latest/", userDeviceLatest).
+		Methods("GET")
+
 	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/", userDeviceLocData).
 		Methods("GET", "POST")
 
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/timeline/", userDeviceTimeline).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/export/", userDeviceExport).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/import/", userDeviceImport).
+		Methods("POST")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/heatmap/", userDeviceHeatmap).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/devices/{device_id}/location-history/rejected/",
+		userDeviceRejectedSnapshots).Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/{device_id}/location-history/",
+		userDeviceLocData).Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/{device_id}/location-history/timeline/",
+		userDeviceTimeline).Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/{device_id}/location-history/export/",
+		userDeviceExport).Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/{device_id}/latest/", userDeviceLatest).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/latest/", userCloakLatest).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/heatmap/", userCloakHeatmap).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt",
+		userCloakTile).Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/nearby/", userCloakMembersNearby).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/cloaks/{cloak_id}/members/nearest/", userCloakMembersNearest).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/location-history/within/", userLocationsWithin).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/stream/", userStream).
+		Methods("GET")
+
+	usersRouter.HandleFunc("/{username}/geofences/", userGeofences).
+		Methods("GET", "POST")
+
+	usersRouter.HandleFunc("/{username}/geofences/{geofence_id}/", userGeofence).
+		Methods("GET", "DELETE")
+
+	usersRouter.HandleFunc("/{username}/geofences/{geofence_id}/events/", userGeofenceEvents).
+		Methods("GET")
+
 	// register middleware that ensures only owning users can access the private endpoints
 	usersRouter.Use(marauderhttp.ApplyOwnerPermission)
 }
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/mcctor/marauders/utils"
+)
+
+const defaultResultLimit = 100
+
+// parseTemplateFields reads the collection+json template in the body of the request
+// and returns the values of its data fields by name.
+func parseTemplateFields(request *http.Request) (map[string]string, error) {
+	bodyBytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %v", err)
+	}
+	var body struct {
+		Template utils.ItemTemplate `json:"template"`
+	}
+	err = json.Unmarshal(bodyBytes, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	fields := make(map[string]string)
+	for _, field := range body.Template.Data {
+		fields[field.Name] = field.Value
+	}
+	return fields, nil
+}
+
+// resultLimit returns the limit query parameter of the request, or the default
+// limit if it is missing or invalid.
+func resultLimit(request *http.Request) int {
+	lim, err := strconv.Atoi(request.URL.Query().Get("limit"))
+	if err != nil || lim <= 0 {
+		return defaultResultLimit
+	}
+	return lim
+}
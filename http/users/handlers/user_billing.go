@@ -1,7 +1,41 @@
 package handlers
 
-import "net/http"
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
 
-func userBilling(writer http.ResponseWriter, request *http.Request) {
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
 
+// userBilling returns a ledger transaction of the user along with its entries.
+func userBilling(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	user, err := db.GetUser(vars["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	transactionID, err := strconv.Atoi(vars["billing_id"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no billing with given id\"}", http.StatusNotFound)
+		return
+	}
+	transaction, err := user.LedgerTransaction(transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"no billing with given id\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedTransaction, err := serializers.LedgerTransactionSerializer(user.Username, transaction)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedTransaction)
 }
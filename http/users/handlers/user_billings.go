@@ -1,7 +1,39 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
 
-func userBillings(writer http.ResponseWriter, request *http.Request) {
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
 
+// userBillings returns the latest ledger transactions of the user along with their balance
+// in the currency query parameter, or the default currency.
+func userBillings(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	currency := request.URL.Query().Get("currency")
+	if currency == "" {
+		currency = db.DefaultCurrency
+	}
+	balance, err := user.Balance(currency)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	transactions, err := user.LedgerTransactions(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedTransactions, err := serializers.LedgerTransactionItemsSerializer(user.Username, balance, transactions)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedTransactions)
 }
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+	"github.com/mcctor/marauders/payments"
+)
+
+// amountPattern matches an amount in major units with up to two decimal places.
+var amountPattern = regexp.MustCompile(`^[0-9]{1,9}(\.[0-9]{1,2})?$`)
+
+// userCheckouts lists the latest top ups of the user, or opens a new one on POST with the
+// payment provider, amount and currency fields of the template. The checkout is paid at
+// the URL it links to, and the user's account is credited once the provider reports it paid.
+func userCheckouts(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	if request.Method == http.MethodPost {
+		userCheckoutsPostHandler(writer, request, user)
+		return
+	}
+	checkouts, err := user.Checkouts(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedCheckouts, err := serializers.CheckoutItemsSerializer(user.Username, checkouts)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedCheckouts)
+}
+
+func userCheckoutsPostHandler(writer http.ResponseWriter, request *http.Request, user *db.User) {
+	fields, err := parseTemplateFields(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return
+	}
+	provider, ok := payments.Providers[fields["provider"]]
+	if !ok {
+		http.Error(writer, "{\"status\": \"no payment provider with given name\"}", http.StatusBadRequest)
+		return
+	}
+	amount, ok := parseAmount(fields["amount"])
+	if !ok {
+		http.Error(writer, "{\"status\": \"amount should be a positive number with up to two decimals\"}",
+			http.StatusBadRequest)
+		return
+	}
+	currency := strings.ToUpper(fields["currency"])
+	if currency == "" {
+		currency = db.DefaultCurrency
+	}
+	checkout, err := payments.Open(provider, user, db.Money{Amount: amount, Currency: currency})
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedCheckouts, err := serializers.CheckoutItemsSerializer(user.Username, []db.Checkout{checkout})
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(serializedCheckouts)
+}
+
+// parseAmount returns an amount given in major units, such as 12.50, in minor units.
+func parseAmount(value string) (int64, bool) {
+	if !amountPattern.MatchString(value) {
+		return 0, false
+	}
+	parts := strings.SplitN(value+".", ".", 3)
+	units, _ := strconv.ParseInt(parts[0], 10, 64)
+	cents, _ := strconv.ParseInt((parts[1] + "00")[:2], 10, 64)
+	amount := units*100 + cents
+	return amount, amount > 0
+}
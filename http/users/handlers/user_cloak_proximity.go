@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+func userCloakProximity(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		userCloakProximityGetHandler(writer, request)
+	case http.MethodPut:
+		userCloakProximityPutHandler(writer, request)
+	case http.MethodDelete:
+		userCloakProximityDeleteHandler(writer, request)
+	}
+}
+
+func userCloakProximityGetHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	rule, err := cloak.ProximityRule()
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"cloak has no proximity rule\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRule, err := serializers.ProximityRuleSerializer(vars["username"], rule)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRule)
+}
+
+func userCloakProximityPutHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := ownedCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	fields, err := parseTemplateFields(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return
+	}
+	distance, err := strconv.ParseFloat(fields["distance"], 64)
+	if err != nil || distance <= 0 {
+		http.Error(writer, "{\"status\": \"distance should be a positive number of metres\"}",
+			http.StatusBadRequest)
+		return
+	}
+	rule, err := cloak.SetProximityRule(distance)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRule, err := serializers.ProximityRuleSerializer(vars["username"], rule)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRule)
+}
+
+func userCloakProximityDeleteHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := ownedCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	if err := cloak.RemoveProximityRule(); err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func userCloakProximityEvents(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	events, err := cloak.ProximityEvents(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedEvents, err := serializers.ProximityEventItemsSerializer(vars["username"], cloak.ID, events)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedEvents)
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/mvt"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	// tileBuffer is how far in extent units past its edges a tile holds geometry, so that
+	// markers and lines are not cut off where tiles meet
+	tileBuffer = 64
+	// tileSize is the width in pixels a tile is drawn at, which sets how much tracks are
+	// simplified at each zoom level
+	tileSize = 256
+	// defaultTrackPeriod is how far back tracks go when no from query parameter is given
+	defaultTrackPeriod = 24 * time.Hour
+)
+
+// userCloakTile returns a Mapbox Vector Tile of the members of a cloak, with a members
+// layer holding their latest positions and a tracks layer holding their tracks between
+// the from and to query parameters. Both are seen as the cloak lets them be, and tracks
+// are simplified to about a pixel at the tile's zoom level.
+func userCloakTile(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	z, _ := strconv.Atoi(vars["z"])
+	x, _ := strconv.Atoi(vars["x"])
+	y, _ := strconv.Atoi(vars["y"])
+	tile := geo.Tile{Z: z, X: x, Y: y}
+	if !tile.IsValid() {
+		http.Error(writer, "{\"status\": \"no such tile\"}", http.StatusNotFound)
+		return
+	}
+	to := time.Now().UTC()
+	from := to.Add(-defaultTrackPeriod)
+	query := request.URL.Query()
+	if raw := query.Get("from"); raw != "" {
+		parsed, err := time.Parse(utils.TimeFormat, raw)
+		if err != nil {
+			http.Error(writer, "{\"status\": \"from should be formatted as YYYY-MM-DD HH:MM:SS\"}",
+				http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if raw := query.Get("to"); raw != "" {
+		parsed, err := time.Parse(utils.TimeFormat, raw)
+		if err != nil {
+			http.Error(writer, "{\"status\": \"to should be formatted as YYYY-MM-DD HH:MM:SS\"}",
+				http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+
+	members, err := tileMembers(cloak, tile)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	tracks, err := tileTracks(cloak, tile, from.Format(utils.TimeFormat), to.Format(utils.TimeFormat))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	writer.Write(mvt.Encode(members, tracks))
+}
+
+// tileMembers returns a layer with a point for every member of the cloak whose latest
+// visible position lies within the tile.
+func tileMembers(cloak *db.Cloak, tile geo.Tile) (mvt.Layer, error) {
+	layer := mvt.Layer{Name: "members", Extent: mvt.DefaultExtent}
+	bounds := tile.Bounds()
+	center := geo.Point{Latitude: (bounds.South + bounds.North) / 2, Longitude: (bounds.West + bounds.East) / 2}
+	radius := geo.Distance(center, geo.Point{Latitude: bounds.North, Longitude: bounds.West})
+	nearby, err := cloak.MembersWithin(center, radius)
+	if err != nil {
+		return layer, err
+	}
+	for _, member := range nearby {
+		coordinate, inside := tileCoordinate(tile, member.Snapshot)
+		if !inside {
+			continue
+		}
+		layer.Features = append(layer.Features, mvt.Feature{
+			ID:     uint64(member.Device.ID),
+			Points: []mvt.Coordinate{coordinate},
+			Properties: map[string]interface{}{
+				"device_id":  member.Device.ID,
+				"user":       member.Device.User,
+				"time_stamp": member.Snapshot.TimeStamp,
+				"place":      member.Snapshot.PlaceLabel(),
+			},
+		})
+	}
+	return layer, nil
+}
+
+// tileTracks returns a layer with the tracks of the members of the cloak over the period,
+// clipped to the tile and its buffer. Only snapshots within the tile or its neighbours are
+// read, so a stretch between two fixes further apart than a tile is not drawn through it.
+func tileTracks(cloak *db.Cloak, tile geo.Tile, from, to string) (mvt.Layer, error) {
+	layer := mvt.Layer{Name: "tracks", Extent: mvt.DefaultExtent}
+	members, err := cloak.Members()
+	if err != nil {
+		return layer, err
+	}
+	bounds := tile.Bounds()
+	box := bounds.Widen(bounds.North-bounds.South, bounds.East-bounds.West)
+	// a pixel at the equator, in metres
+	tolerance := 2 * math.Pi * geo.EarthRadius / (tileSize * math.Pow(2, float64(tile.Z)))
+	for _, member := range members {
+		var stretch []geo.Point
+		addStretch := func() {
+			var line []mvt.Coordinate
+			for _, i := range geo.Simplify(stretch, tolerance) {
+				coordinate, _ := tileCoordinate(tile, db.LocationSnapshot{
+					Latitude: stretch[i].Latitude, Longitude: stretch[i].Longitude})
+				line = append(line, coordinate)
+			}
+			for _, clipped := range mvt.ClipLine(line, -tileBuffer, mvt.DefaultExtent+tileBuffer) {
+				layer.Features = append(layer.Features, mvt.Feature{
+					Line:       clipped,
+					Properties: map[string]interface{}{"device_id": member.ID},
+				})
+			}
+			stretch = nil
+		}
+		err := cloak.EachLocationSnapshotForMemberWithin(member, box, from, to,
+			func(snapshot db.LocationSnapshot, follows bool) error {
+				if !follows {
+					addStretch()
+				}
+				stretch = append(stretch, geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude})
+				return nil
+			})
+		if err != nil {
+			return layer, err
+		}
+		addStretch()
+	}
+	return layer, nil
+}
+
+// tileCoordinate returns where the snapshot falls within the tile in extent units, and
+// whether that is within the tile or its buffer.
+func tileCoordinate(tile geo.Tile, snapshot db.LocationSnapshot) (mvt.Coordinate, bool) {
+	x, y := tile.Project(geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude})
+	coordinate := mvt.Coordinate{
+		X: int(math.Round(x * mvt.DefaultExtent)),
+		Y: int(math.Round(y * mvt.DefaultExtent)),
+	}
+	inside := coordinate.X >= -tileBuffer && coordinate.X <= mvt.DefaultExtent+tileBuffer &&
+		coordinate.Y >= -tileBuffer && coordinate.Y <= mvt.DefaultExtent+tileBuffer
+	return coordinate, inside
+}
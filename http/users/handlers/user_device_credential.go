@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+// userDeviceCredential issues a credential for a device to report its location through
+// the ingest endpoints with, or revokes it. A newly issued secret is only ever returned in
+// the response to the request that issued it.
+func userDeviceCredential(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	switch request.Method {
+	case http.MethodPost:
+		secret, err := view.Device.NewCredential()
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		serializedCredential, err := serializers.DeviceCredentialSerializer(
+			marauderhttp.ServerAddr+request.URL.Path, view.Device, secret)
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write(serializedCredential)
+	case http.MethodDelete:
+		if err := view.Device.RevokeCredential(); err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/tracks"
+	"github.com/mcctor/marauders/utils"
+)
+
+// exportWriteTimeout is how long an export may go without writing a snapshot. Exports run
+// past the server's write timeout, as the deadline is pushed back with every snapshot.
+const exportWriteTimeout = 30 * time.Second
+
+// userDeviceExport streams the location history of a device between the from and to query
+// parameters as a file in the format given by the format query parameter, one of gpx, kml,
+// geojson or csv.
+func userDeviceExport(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	query := request.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if _, err := time.Parse(utils.TimeFormat, from); err != nil {
+		http.Error(writer, "{\"status\": \"from should be formatted as YYYY-MM-DD HH:MM:SS\"}", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(utils.TimeFormat, to); err != nil {
+		http.Error(writer, "{\"status\": \"to should be formatted as YYYY-MM-DD HH:MM:SS\"}", http.StatusBadRequest)
+		return
+	}
+	encoder, err := tracks.NewEncoder(query.Get("format"), writer)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"format should be one of gpx, kml, geojson or csv\"}",
+			http.StatusBadRequest)
+		return
+	}
+
+	name := fmt.Sprintf("device-%d", view.Device.ID)
+	writer.Header().Set("Content-Type", encoder.ContentType())
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", name+"."+encoder.Extension()))
+
+	controller := http.NewResponseController(writer)
+	extendable := true
+	extendDeadline := func() {
+		if !extendable {
+			return
+		}
+		if err := controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+			log.Printf("could not extend the export deadline of device<%d>: %v", view.Device.ID, err)
+			extendable = false
+		}
+	}
+	extendDeadline()
+
+	// once the first bytes are out the status can no longer change, so failures past this
+	// point can only cut the file short
+	if err := encoder.Begin(name); err != nil {
+		return
+	}
+	err = view.eachSnapshotBetween(from, to, func(snapshot db.LocationSnapshot) error {
+		extendDeadline()
+		return encoder.Encode(snapshot)
+	})
+	if err != nil {
+		log.Printf("export of device<%d> cut short: %v", view.Device.ID, err)
+		return
+	}
+	encoder.End()
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+// userDeviceIdentifiers lists or registers the identifiers a device reports itself with
+// over the protocols of third party trackers.
+func userDeviceIdentifiers(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	href := marauderhttp.ServerAddr + request.URL.Path
+	switch request.Method {
+	case http.MethodGet:
+		identifiers, err := view.Device.Identifiers()
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		serializedIdentifiers, err := serializers.DeviceIdentifierItemsSerializer(href, identifiers)
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.Write(serializedIdentifiers)
+	case http.MethodPost:
+		fields, err := parseTemplateFields(request)
+		if err != nil {
+			http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+			return
+		}
+		identifier, err := view.Device.RegisterIdentifier(fields["protocol"], fields["identifier"])
+		if err != nil {
+			http.Error(writer, "{\"status\": \"identifier is unknown or already registered\"}",
+				http.StatusBadRequest)
+			return
+		}
+		serializedIdentifier, err := serializers.DeviceIdentifierItemsSerializer(href, []db.DeviceIdentifier{*identifier})
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		setContentCreatedHeader(fmt.Sprintf("%s%s/%s/", href, identifier.Protocol, identifier.Identifier), writer)
+		writer.Write(serializedIdentifier)
+	}
+}
+
+func userDeviceIdentifier(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(request)
+	if err := view.Device.RemoveIdentifier(vars["protocol"], vars["identifier"]); err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
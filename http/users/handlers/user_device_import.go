@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mcctor/marauders/tracks"
+)
+
+// importTimeout is how long an import may go without saving a batch. Imports run past the
+// server's read and write timeouts, as the deadlines are pushed back with every batch.
+const importTimeout = 30 * time.Second
+
+// userDeviceImport loads a track posted as the request body into the location history of
+// a device. The format query parameter is either gpx or takeout, and dry_run=true reports
+// what would be imported without saving it. Progress is streamed back as one JSON report
+// per line, the last line being the final report or an error.
+func userDeviceImport(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	query := request.URL.Query()
+	format := query.Get("format")
+	if format != tracks.FormatGPX && format != tracks.FormatTakeout {
+		http.Error(writer, "{\"status\": \"format should be one of gpx or takeout\"}", http.StatusBadRequest)
+		return
+	}
+
+	controller := http.NewResponseController(writer)
+	extendable := true
+	extendDeadlines := func() {
+		if !extendable {
+			return
+		}
+		deadline := time.Now().Add(importTimeout)
+		if err := controller.SetReadDeadline(deadline); err != nil {
+			log.Printf("could not extend the import deadline of device<%d>: %v", view.Device.ID, err)
+			extendable = false
+			return
+		}
+		if err := controller.SetWriteDeadline(deadline); err != nil {
+			log.Printf("could not extend the import deadline of device<%d>: %v", view.Device.ID, err)
+			extendable = false
+		}
+	}
+	extendDeadlines()
+
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(writer)
+	flusher, _ := writer.(http.Flusher)
+	importer := tracks.Importer{
+		Device: view.Device,
+		DryRun: query.Get("dry_run") == "true",
+		Progress: func(report tracks.ImportReport) {
+			extendDeadlines()
+			encoder.Encode(report)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		},
+	}
+	report, err := importer.Import(format, request.Body)
+	if err != nil {
+		fmt.Fprintf(writer, "{\"status\": %q}\n", err.Error())
+		return
+	}
+	encoder.Encode(report)
+}
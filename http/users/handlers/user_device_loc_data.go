@@ -1,7 +1,184 @@
 package handlers
 
-import "net/http"
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+	"github.com/mcctor/marauders/utils"
+)
 
 func userDeviceLocData(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		userDeviceLocDataGetHandler(writer, request)
+	case http.MethodPost:
+		userDeviceLocDataPostHandler(writer, request)
+	}
+}
+
+// userDeviceLocDataGetHandler returns the location history of a device, either the latest
+// snapshots or, given from and to query parameters, those recorded in between. The history
+// can be thinned with the downsample query parameter, keeping a point per that many
+// minutes, and the simplify one, dropping points that stray less than that many metres
+// from the shape of the track. How many points were dropped is sent in X-Dropped-Points.
+func userDeviceLocDataGetHandler(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	query := request.URL.Query()
+	var snapshots []db.LocationSnapshot
+	var err error
+	if query.Get("from") != "" && query.Get("to") != "" {
+		snapshots, err = view.snapshotsBetween(query.Get("from"), query.Get("to"))
+	} else {
+		snapshots, err = view.latestSnapshots(resultLimit(request))
+	}
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	thinned, err := thinSnapshots(snapshots, query.Get("downsample"), query.Get("simplify"))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("{\"status\": %q}", err.Error()), http.StatusBadRequest)
+		return
+	}
+	writer.Header().Set("X-Dropped-Points", strconv.Itoa(len(snapshots)-len(thinned)))
+	snapshots = thinned
+
+	serializedSnapshots, err := serializers.LocationSnapshotItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, snapshots)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedSnapshots)
+}
+
+// thinSnapshots downsamples the snapshots to a point per the passed number of minutes and
+// then simplifies them to the passed tolerance in metres, skipping either step if its
+// parameter is empty. The first and last snapshots are always kept.
+func thinSnapshots(snapshots []db.LocationSnapshot, downsample, simplify string) ([]db.LocationSnapshot, error) {
+	if downsample != "" {
+		minutes, err := strconv.ParseFloat(downsample, 64)
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("downsample should be a positive number of minutes")
+		}
+		times := make([]time.Time, len(snapshots))
+		for i, snapshot := range snapshots {
+			if times[i], err = time.Parse(utils.TimeFormat, snapshot.TimeStamp); err != nil {
+				return nil, fmt.Errorf("bad time stamp in location history")
+			}
+		}
+		snapshots = pickSnapshots(snapshots, geo.Downsample(times, time.Duration(minutes*float64(time.Minute))))
+	}
+	if simplify != "" {
+		tolerance, err := strconv.ParseFloat(simplify, 64)
+		if err != nil || tolerance < 0 {
+			return nil, fmt.Errorf("simplify should be a tolerance in metres")
+		}
+		track := make([]geo.Point, len(snapshots))
+		for i, snapshot := range snapshots {
+			track[i] = geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude}
+		}
+		snapshots = pickSnapshots(snapshots, geo.Simplify(track, tolerance))
+	}
+	return snapshots, nil
+}
+
+func pickSnapshots(snapshots []db.LocationSnapshot, indices []int) []db.LocationSnapshot {
+	picked := make([]db.LocationSnapshot, len(indices))
+	for i, index := range indices {
+		picked[i] = snapshots[index]
+	}
+	return picked
+}
+
+func userDeviceLocDataPostHandler(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	if view.Cloak != nil {
+		http.Error(writer, "{\"status\": \"only the owner of a device can record its location\"}",
+			http.StatusForbidden)
+		return
+	}
+	fields, err := parseTemplateFields(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return
+	}
+	snapshot, err := locationSnapshotFromFields(fields)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("{\"status\": %q}", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	err = view.Device.RecordLocationSnapshot(snapshot)
+	var rejection *db.RejectedSnapshotError
+	if errors.As(err, &rejection) {
+		http.Error(writer, fmt.Sprintf("{\"status\": \"location snapshot rejected\", \"reason\": %q}",
+			rejection.Reason), http.StatusUnprocessableEntity)
+		return
+	} else if upgradeRequired(writer, err) {
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// locationSnapshotFromFields builds a location snapshot out of the fields of a posted
+// template. The time stamp and coordinates are required, the rest of the readings optional.
+func locationSnapshotFromFields(fields map[string]string) (snapshot db.LocationSnapshot, err error) {
+	snapshot.TimeStamp = fields["time_stamp"]
+	if snapshot.TimeStamp == "" {
+		return snapshot, fmt.Errorf("time_stamp is required")
+	}
+	snapshot.Latitude, err = strconv.ParseFloat(fields["latitude"], 64)
+	if err != nil {
+		return snapshot, fmt.Errorf("latitude should be a number")
+	}
+	snapshot.Longitude, err = strconv.ParseFloat(fields["longitude"], 64)
+	if err != nil {
+		return snapshot, fmt.Errorf("longitude should be a number")
+	}
 
+	optionalFloats := map[string]*sql.NullFloat64{
+		"altitude": &snapshot.Altitude,
+		"accuracy": &snapshot.Accuracy,
+		"speed":    &snapshot.Speed,
+		"bearing":  &snapshot.Bearing,
+	}
+	for name, reading := range optionalFloats {
+		if fields[name] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[name], 64)
+		if err != nil {
+			return snapshot, fmt.Errorf("%s should be a number", name)
+		}
+		*reading = sql.NullFloat64{Float64: value, Valid: true}
+	}
+	if fields["battery"] != "" {
+		battery, err := strconv.ParseInt(fields["battery"], 10, 64)
+		if err != nil {
+			return snapshot, fmt.Errorf("battery should be a whole percentage")
+		}
+		snapshot.Battery = sql.NullInt64{Int64: battery, Valid: true}
+	}
+	if fields["provider"] != "" {
+		snapshot.Provider = sql.NullString{String: fields["provider"], Valid: true}
+	}
+	return snapshot, nil
 }
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+// userDeviceRejectedSnapshots lists the location snapshots of a device that were turned away
+// by the ingest filter, to help look into devices reporting bad positions.
+func userDeviceRejectedSnapshots(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	rejected, err := view.Device.RejectedSnapshots(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRejected, err := serializers.RejectedSnapshotItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, rejected)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRejected)
+}
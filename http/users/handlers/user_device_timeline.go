@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+	"github.com/mcctor/marauders/timeline"
+	"github.com/mcctor/marauders/utils"
+)
+
+const dateFormat = "2006-01-02"
+
+// userDeviceTimeline returns the stays and trips of a device over a single day, given by
+// the date query parameter and defaulting to the current day.
+func userDeviceTimeline(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	date := request.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().UTC().Format(dateFormat)
+	}
+	day, err := time.Parse(dateFormat, date)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"date should be formatted as YYYY-MM-DD\"}", http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := view.snapshotsBetween(day.Format(utils.TimeFormat),
+		day.AddDate(0, 0, 1).Format(utils.TimeFormat))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	segments, err := timeline.Build(snapshots)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedTimeline, err := serializers.TimelineSerializer(marauderhttp.ServerAddr+request.URL.Path, segments)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedTimeline)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+func userGeofences(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		userGeofencesGetHandler(writer, request)
+	case http.MethodPost:
+		userGeofencesPostHandler(writer, request)
+	}
+}
+
+func userGeofencesGetHandler(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	geofences, err := user.Geofences()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedGeofences, err := serializers.GeofenceItemsSerializer(user.Username, geofences)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedGeofences)
+}
+
+func userGeofencesPostHandler(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	fields, err := parseTemplateFields(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return
+	}
+	geofence, err := createGeofenceFromFields(user, fields)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("{\"status\": %q}", err.Error()), http.StatusBadRequest)
+		return
+	}
+	serializedGeofence, err := serializers.GeofenceItemsSerializer(user.Username, []*db.Geofence{geofence})
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+
+	setContentCreatedHeader(fmt.Sprintf("%s%s/geofences/%s/", userConst.Href, user.Username, geofence.ID), writer)
+	writer.Write(serializedGeofence)
+}
+
+// createGeofenceFromFields creates a circle or polygon geofence from the fields of a posted
+// template. When a cloak_id is given the geofence belongs to that cloak, which has to be
+// owned by the user.
+func createGeofenceFromFields(user *db.User, fields map[string]string) (*db.Geofence, error) {
+	name := fields["name"]
+	var cloak *db.Cloak
+	if fields["cloak_id"] != "" {
+		var err error
+		cloak, err = db.GetCloakByID(fields["cloak_id"])
+		if err != nil || cloak.User != user.Username {
+			return nil, fmt.Errorf("no owned cloak with given cloak_id")
+		}
+	}
+
+	switch fields["kind"] {
+	case db.GeofenceCircle:
+		latitude, latErr := strconv.ParseFloat(fields["latitude"], 64)
+		longitude, lonErr := strconv.ParseFloat(fields["longitude"], 64)
+		radius, radErr := strconv.ParseFloat(fields["radius"], 64)
+		if latErr != nil || lonErr != nil || radErr != nil {
+			return nil, fmt.Errorf("circle needs a numeric latitude, longitude and radius")
+		}
+		center := geo.Point{Latitude: latitude, Longitude: longitude}
+		if cloak != nil {
+			return cloak.NewCircleGeofence(name, center, radius)
+		}
+		return user.NewCircleGeofence(name, center, radius)
+	case db.GeofencePolygon:
+		var polygon geo.Polygon
+		if err := json.Unmarshal([]byte(fields["vertices"]), &polygon); err != nil {
+			return nil, fmt.Errorf("polygon needs vertices as a json list of points")
+		}
+		if cloak != nil {
+			return cloak.NewPolygonGeofence(name, polygon)
+		}
+		return user.NewPolygonGeofence(name, polygon)
+	}
+	return nil, fmt.Errorf("kind should be one of %s or %s", db.GeofenceCircle, db.GeofencePolygon)
+}
+
+func userGeofence(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	geofence, err := db.GetGeofenceByID(vars["geofence_id"])
+	if err != nil || geofence.User != vars["username"] {
+		http.Error(writer, "{\"status\": \"no geofence with given id\"}", http.StatusNotFound)
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		serializedGeofence, err := serializers.GeofenceItemsSerializer(geofence.User, []*db.Geofence{geofence})
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.Write(serializedGeofence)
+	case http.MethodDelete:
+		if err := geofence.Delete(); err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func userGeofenceEvents(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	geofence, err := db.GetGeofenceByID(vars["geofence_id"])
+	if err != nil || geofence.User != vars["username"] {
+		http.Error(writer, "{\"status\": \"no geofence with given id\"}", http.StatusNotFound)
+		return
+	}
+	// the events of a cloak's geofence are the positions of its members, which only those
+	// the cloak is visible to may see
+	if geofence.CloakID.Valid {
+		if _, ok := visibleCloak(writer, geofence.CloakID.String, vars["username"]); !ok {
+			return
+		}
+	}
+	events, err := geofence.Events(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedEvents, err := serializers.GeofenceEventItemsSerializer(geofence, events)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedEvents)
+}
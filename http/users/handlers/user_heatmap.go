@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	heatmapGeoJSON = "geojson"
+	heatmapArray   = "array"
+)
+
+type heatmapQuery struct {
+	zoom     int
+	from, to string
+	format   string
+}
+
+// userCloakHeatmap counts the snapshots of the members of a cloak, as the cloak lets
+// them be seen, into the tiles of a zoom level.
+func userCloakHeatmap(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	query, ok := parseHeatmapQuery(writer, request)
+	if !ok {
+		return
+	}
+	members, err := cloak.Members()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	heatmap := geo.NewHeatmap(query.zoom)
+	for _, member := range members {
+		err := cloak.EachLocationSnapshotForMemberBetween(member, query.from, query.to, countInto(heatmap))
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeHeatmap(writer, heatmap, query.format)
+}
+
+// userDeviceHeatmap counts the snapshots of a single device the user may see into the
+// tiles of a zoom level.
+func userDeviceHeatmap(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	query, ok := parseHeatmapQuery(writer, request)
+	if !ok {
+		return
+	}
+	heatmap := geo.NewHeatmap(query.zoom)
+	if err := view.eachSnapshotBetween(query.from, query.to, countInto(heatmap)); err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writeHeatmap(writer, heatmap, query.format)
+}
+
+// parseHeatmapQuery reads the zoom, from, to and format query parameters. If any is
+// invalid, an error response is written and false returned.
+func parseHeatmapQuery(writer http.ResponseWriter, request *http.Request) (heatmapQuery, bool) {
+	values := request.URL.Query()
+	zoom, err := strconv.Atoi(values.Get("zoom"))
+	if err != nil || zoom < 0 || zoom > geo.MaxZoom {
+		http.Error(writer, "{\"status\": \"zoom should be a level from 0 to 22\"}", http.StatusBadRequest)
+		return heatmapQuery{}, false
+	}
+	query := heatmapQuery{zoom: zoom, from: values.Get("from"), to: values.Get("to"), format: values.Get("format")}
+	if _, err := time.Parse(utils.TimeFormat, query.from); err != nil {
+		http.Error(writer, "{\"status\": \"from should be formatted as YYYY-MM-DD HH:MM:SS\"}", http.StatusBadRequest)
+		return heatmapQuery{}, false
+	}
+	if _, err := time.Parse(utils.TimeFormat, query.to); err != nil {
+		http.Error(writer, "{\"status\": \"to should be formatted as YYYY-MM-DD HH:MM:SS\"}", http.StatusBadRequest)
+		return heatmapQuery{}, false
+	}
+	if query.format == "" {
+		query.format = heatmapGeoJSON
+	}
+	if query.format != heatmapGeoJSON && query.format != heatmapArray {
+		http.Error(writer, "{\"status\": \"format should be geojson or array\"}", http.StatusBadRequest)
+		return heatmapQuery{}, false
+	}
+	return query, true
+}
+
+func countInto(heatmap *geo.Heatmap) func(db.LocationSnapshot) error {
+	return func(snapshot db.LocationSnapshot) error {
+		heatmap.Add(geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude})
+		return nil
+	}
+}
+
+// writeHeatmap writes the heatmap either as a GeoJSON FeatureCollection of square cells
+// with a count property, or compactly as the zoom level and an array of x, y and count
+// triples.
+func writeHeatmap(writer http.ResponseWriter, heatmap *geo.Heatmap, format string) {
+	cells := heatmap.Cells()
+	var body interface{}
+	contentType := "application/geo+json"
+	if format == heatmapArray {
+		contentType = "application/json"
+		triples := make([][3]int, len(cells))
+		for i, cell := range cells {
+			triples[i] = [3]int{cell.Tile.X, cell.Tile.Y, cell.Count}
+		}
+		body = map[string]interface{}{"zoom": heatmap.Zoom, "cells": triples}
+	} else {
+		features := make([]interface{}, len(cells))
+		for i, cell := range cells {
+			bounds := cell.Tile.Bounds()
+			features[i] = map[string]interface{}{
+				"type": "Feature",
+				"geometry": map[string]interface{}{
+					"type": "Polygon",
+					"coordinates": [][][2]float64{{
+						{bounds.West, bounds.South}, {bounds.East, bounds.South}, {bounds.East, bounds.North},
+						{bounds.West, bounds.North}, {bounds.West, bounds.South},
+					}},
+				},
+				"properties": map[string]interface{}{
+					"x": cell.Tile.X, "y": cell.Tile.Y, "count": cell.Count,
+				},
+			}
+		}
+		body = map[string]interface{}{"type": "FeatureCollection", "zoom": heatmap.Zoom, "features": features}
+	}
+	serializedHeatmap, err := json.Marshal(body)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Write(serializedHeatmap)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+// userDeviceLatest returns the last known location of a device, as the requesting user is
+// allowed to see it.
+func userDeviceLatest(writer http.ResponseWriter, request *http.Request) {
+	view, ok := resolveDeviceView(writer, request)
+	if !ok {
+		return
+	}
+	latest, err := view.latestSnapshot()
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"device has no known location\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedLatest, err := serializers.LatestLocationItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, []db.LocationSnapshot{latest})
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedLatest)
+}
+
+// userCloakLatest returns the last known location of every member of a cloak, as the cloak
+// allows them to be seen.
+func userCloakLatest(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	latest, err := cloak.LatestMemberLocations()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedLatest, err := serializers.LatestLocationItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, latest)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedLatest)
+}
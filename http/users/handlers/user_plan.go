@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+// userPlan returns the plan the user is subscribed to along with how much of its limits
+// they are using.
+func userPlan(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	plan, err := user.Plan()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	usage, err := user.PlanUsage()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedPlan, err := serializers.PlanSerializer(user.Username, plan, usage)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedPlan)
+}
+
+// upgradeRequired writes a Payment Required response if err is an *db.UpgradeRequiredError,
+// and returns whether it was.
+func upgradeRequired(writer http.ResponseWriter, err error) bool {
+	var upgrade *db.UpgradeRequiredError
+	if !errors.As(err, &upgrade) {
+		return false
+	}
+	http.Error(writer, fmt.Sprintf("{\"status\": \"upgrade required\", \"plan\": %q, \"limit\": %q}",
+		upgrade.Plan, upgrade.Limit), http.StatusPaymentRequired)
+	return true
+}
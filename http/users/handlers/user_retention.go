@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+func userRetention(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	switch request.Method {
+	case http.MethodPut:
+		days, ok := retentionDaysOf(writer, request)
+		if !ok {
+			return
+		}
+		err := user.SetRetention(days)
+		if upgradeRequired(writer, err) {
+			return
+		} else if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if err := user.ClearRetention(); err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+	}
+	days, custom, err := user.Retention()
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRetention, err := serializers.UserRetentionSerializer(user.Username, days, custom)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRetention)
+}
+
+func userRetentionRuns(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	runs, err := user.RetentionRuns(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRuns, err := serializers.RetentionRunItemsSerializer(user.Username, runs)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRuns)
+}
+
+func userCloakRetention(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		userCloakRetentionGetHandler(writer, request)
+	case http.MethodPut:
+		userCloakRetentionPutHandler(writer, request)
+	case http.MethodDelete:
+		userCloakRetentionDeleteHandler(writer, request)
+	}
+}
+
+func userCloakRetentionGetHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	days, err := cloak.MaxRetention()
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"cloak has no retention limit\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRetention, err := serializers.CloakRetentionSerializer(vars["username"], cloak.ID, days)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRetention)
+}
+
+func userCloakRetentionPutHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := ownedCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	days, ok := retentionDaysOf(writer, request)
+	if !ok {
+		return
+	}
+	if err := cloak.SetMaxRetention(days); err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedRetention, err := serializers.CloakRetentionSerializer(vars["username"], cloak.ID, days)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedRetention)
+}
+
+func userCloakRetentionDeleteHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	cloak, ok := ownedCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return
+	}
+	if err := cloak.RemoveMaxRetention(); err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// retentionDaysOf reads the days template field of the request. If it is not a positive
+// number, an error response is written and false returned.
+func retentionDaysOf(writer http.ResponseWriter, request *http.Request) (int, bool) {
+	fields, err := parseTemplateFields(request)
+	if err != nil {
+		http.Error(writer, "{\"status\": \"bad formatted json\"}", http.StatusBadRequest)
+		return 0, false
+	}
+	days, err := strconv.Atoi(fields["days"])
+	if err != nil || days <= 0 {
+		http.Error(writer, "{\"status\": \"days should be a positive number\"}", http.StatusBadRequest)
+		return 0, false
+	}
+	return days, true
+}
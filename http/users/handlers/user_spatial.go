@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	marauderhttp "github.com/mcctor/marauders/http"
+	"github.com/mcctor/marauders/http/users/serializers"
+)
+
+const defaultNearestCount = 5
+
+// userLocationsWithin returns the snapshots of the user's devices within the box given by
+// the south, west, north and east query parameters, recorded between from and to.
+func userLocationsWithin(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	query := request.URL.Query()
+	var box geo.Box
+	edges := map[string]*float64{
+		"south": &box.South, "west": &box.West, "north": &box.North, "east": &box.East,
+	}
+	for name, edge := range edges {
+		if *edge, err = strconv.ParseFloat(query.Get(name), 64); err != nil {
+			http.Error(writer, fmt.Sprintf("{\"status\": \"%s should be a number\"}", name), http.StatusBadRequest)
+			return
+		}
+	}
+	if !box.IsValid() {
+		http.Error(writer, "{\"status\": \"not a valid bounding box\"}", http.StatusBadRequest)
+		return
+	}
+	if query.Get("from") == "" || query.Get("to") == "" {
+		http.Error(writer, "{\"status\": \"from and to are required\"}", http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := user.LocationSnapshotsWithin(box, query.Get("from"), query.Get("to"), resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedSnapshots, err := serializers.LocationSnapshotItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, snapshots)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedSnapshots)
+}
+
+// userCloakMembersNearby returns the members of a cloak within the radius query parameter
+// in metres of the point given by the latitude and longitude parameters.
+func userCloakMembersNearby(writer http.ResponseWriter, request *http.Request) {
+	cloak, center, ok := spatialQueryOf(writer, request)
+	if !ok {
+		return
+	}
+	radius, err := strconv.ParseFloat(request.URL.Query().Get("radius"), 64)
+	if err != nil || radius <= 0 {
+		http.Error(writer, "{\"status\": \"radius should be a positive number of metres\"}", http.StatusBadRequest)
+		return
+	}
+	nearby, err := cloak.MembersWithin(center, radius)
+	writeMemberLocations(writer, request, cloak, nearby, err)
+}
+
+// userCloakMembersNearest returns the count query parameter of the members of a cloak
+// nearest to the point given by the latitude and longitude parameters.
+func userCloakMembersNearest(writer http.ResponseWriter, request *http.Request) {
+	cloak, center, ok := spatialQueryOf(writer, request)
+	if !ok {
+		return
+	}
+	count := defaultNearestCount
+	if raw := request.URL.Query().Get("count"); raw != "" {
+		var err error
+		if count, err = strconv.Atoi(raw); err != nil || count <= 0 {
+			http.Error(writer, "{\"status\": \"count should be a positive number\"}", http.StatusBadRequest)
+			return
+		}
+	}
+	nearest, err := cloak.NearestMembers(center, count)
+	writeMemberLocations(writer, request, cloak, nearest, err)
+}
+
+// spatialQueryOf returns the cloak and the point a query about its members is made around.
+// If either is missing, an error response is written and false returned.
+func spatialQueryOf(writer http.ResponseWriter, request *http.Request) (*db.Cloak, geo.Point, bool) {
+	vars := mux.Vars(request)
+	cloak, ok := visibleCloak(writer, vars["cloak_id"], vars["username"])
+	if !ok {
+		return nil, geo.Point{}, false
+	}
+	query := request.URL.Query()
+	latitude, latErr := strconv.ParseFloat(query.Get("latitude"), 64)
+	longitude, lonErr := strconv.ParseFloat(query.Get("longitude"), 64)
+	center := geo.Point{Latitude: latitude, Longitude: longitude}
+	if latErr != nil || lonErr != nil || !center.IsValid() {
+		http.Error(writer, "{\"status\": \"latitude and longitude should be a valid position\"}",
+			http.StatusBadRequest)
+		return nil, geo.Point{}, false
+	}
+	return cloak, center, true
+}
+
+func writeMemberLocations(writer http.ResponseWriter, request *http.Request, cloak *db.Cloak,
+	locations []db.MemberLocation, err error) {
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedLocations, err := serializers.MemberLocationItemsSerializer(
+		marauderhttp.ServerAddr+request.URL.Path, mux.Vars(request)["username"], cloak.ID, locations)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedLocations)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/http/users/serializers"
+	"github.com/mcctor/marauders/invoices"
+)
+
+// userStatements returns the latest statements issued to the user.
+func userStatements(writer http.ResponseWriter, request *http.Request) {
+	user, err := db.GetUser(mux.Vars(request)["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	statements, err := user.Statements(resultLimit(request))
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	serializedStatements, err := serializers.StatementItemsSerializer(user.Username, statements)
+	if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+	writer.Write(serializedStatements)
+}
+
+// userStatement returns a statement of the user along with its lines, or downloads it as
+// an invoice when the format query parameter is csv or pdf.
+func userStatement(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	user, err := db.GetUser(vars["username"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no user with given username\"}", http.StatusNotFound)
+		return
+	}
+	number, err := strconv.Atoi(vars["statement_number"])
+	if err != nil {
+		http.Error(writer, "{\"status\": \"no statement with given number\"}", http.StatusNotFound)
+		return
+	}
+	statement, err := user.Statement(number)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "{\"status\": \"no statement with given number\"}", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, "", http.StatusInternalServerError)
+		return
+	}
+
+	switch format := request.URL.Query().Get("format"); format {
+	case "":
+		serializedStatement, err := serializers.StatementSerializer(user.Username, statement)
+		if err != nil {
+			http.Error(writer, "", http.StatusInternalServerError)
+			return
+		}
+		writer.Write(serializedStatement)
+	case invoices.FormatCSV, invoices.FormatPDF:
+		writer.Header().Set("Content-Type", invoices.ContentType(format))
+		writer.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=%q", invoices.Number(statement)+"."+format))
+		if err := invoices.Write(format, writer, statement); err != nil {
+			log.Printf("invoice of statement<%d> cut short: %v", statement.Number, err)
+		}
+	default:
+		http.Error(writer, "{\"status\": \"format should be one of csv or pdf\"}", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/stream"
+)
+
+const (
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = (streamPongWait * 9) / 10
+	streamReplayLim  = 500
+)
+
+// streamSource identifies a device whose updates are shared through a particular cloak.
+type streamSource struct {
+	cloakID  string
+	deviceID int
+}
+
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// userStream upgrades the request to a websocket over which the location updates of
+// the requested cloaks are pushed as they are recorded. A client that reconnects can
+// pass the time stamp of the last update it received as the since query parameter.
+func userStream(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	username := vars["username"]
+	query := request.URL.Query()
+
+	cloakIDs := query["cloak"]
+	if len(cloakIDs) == 0 {
+		http.Error(writer, "{\"status\": \"no cloak to subscribe to\"}", http.StatusBadRequest)
+		return
+	}
+	var cloaks []*db.Cloak
+	for _, cloakID := range cloakIDs {
+		cloak, ok := visibleCloak(writer, cloakID, username)
+		if !ok {
+			return
+		}
+		cloaks = append(cloaks, cloak)
+	}
+
+	conn, err := streamUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := stream.DefaultHub.Subscribe(username, cloaks)
+	defer stream.DefaultHub.Unsubscribe(sub)
+
+	lastSent := make(map[streamSource]string)
+	if since := query.Get("since"); since != "" {
+		updates, err := stream.Replay(cloaks, since, streamReplayLim)
+		if err != nil {
+			closeStream(conn, websocket.CloseInternalServerErr, "could not replay updates")
+			return
+		}
+		for _, update := range updates {
+			if !writeStreamUpdate(conn, update, lastSent) {
+				return
+			}
+		}
+	}
+
+	disconnected := make(chan struct{})
+	go readStream(conn, disconnected)
+
+	ticker := time.NewTicker(streamPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case update, ok := <-sub.Updates:
+			if !ok {
+				if sub.Lagged() {
+					closeStream(conn, websocket.ClosePolicyViolation, "too slow, resume with since")
+				}
+				return
+			}
+			if !writeStreamUpdate(conn, update, lastSent) {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+// readStream discards the messages sent by the client, keeping the connection alive for
+// as long as it keeps answering pings. The passed channel is closed once it goes away.
+func readStream(conn *websocket.Conn, disconnected chan struct{}) {
+	defer close(disconnected)
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamUpdate sends the update unless an update at least as recent has already been
+// sent for the same device through the same cloak, which happens when a replay overlaps with live updates.
+func writeStreamUpdate(conn *websocket.Conn, update stream.Update, lastSent map[streamSource]string) bool {
+	source := streamSource{update.CloakID, update.DeviceID}
+	if update.TimeStamp <= lastSent[source] {
+		return true
+	}
+	conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	if err := conn.WriteJSON(update); err != nil {
+		return false
+	}
+	lastSent[source] = update.TimeStamp
+	return true
+}
+
+func closeStream(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(streamWriteWait))
+}
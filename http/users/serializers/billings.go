@@ -0,0 +1,89 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func LedgerTransactionItemsSerializer(username string, balance db.Money,
+	transactions []db.LedgerTransaction) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/billings/", userConst.Href, username)
+	// the balance comes first, followed by the transactions it is derived from
+	items := []utils.CollectionItem{{
+		Href: href,
+		Data: []utils.DataField{
+			{Prompt: "balance owed", Name: "balance", Value: formatAmount(balance.Amount)},
+			{Prompt: "currency", Name: "currency", Value: balance.Currency},
+		},
+		Links: []utils.CollectionLink{
+			{Href: href + "statements/", Rel: "statements", Render: "link"},
+			{Href: href + "checkouts/", Rel: "top ups", Render: "link"},
+		},
+	}}
+	for _, transaction := range transactions {
+		items = append(items, utils.CollectionItem{
+			Href:  fmt.Sprintf("%s%d/", href, transaction.ID),
+			Data:  ledgerTransactionData(transaction),
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items:   items,
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{
+				{Href: href, Rel: "search", Prompt: "balance in a currency",
+					Data: []utils.DataField{{Prompt: "currency", Name: "currency", Value: ""}}},
+			},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func LedgerTransactionSerializer(username string, transaction db.LedgerTransaction) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/billings/%d/", userConst.Href, username, transaction.ID)
+	items := []utils.CollectionItem{{
+		Href:  href,
+		Data:  ledgerTransactionData(transaction),
+		Links: []utils.CollectionLink{},
+	}}
+	for _, entry := range transaction.Entries {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "entry id", Name: "entry_id", Value: strconv.Itoa(entry.ID)},
+				{Prompt: "account", Name: "account", Value: entry.Account},
+				{Prompt: "amount, debits positive", Name: "amount", Value: formatAmount(entry.Amount)},
+				{Prompt: "currency", Name: "currency", Value: entry.Currency},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func ledgerTransactionData(transaction db.LedgerTransaction) []utils.DataField {
+	return []utils.DataField{
+		{Prompt: "transaction id", Name: "id", Value: strconv.Itoa(transaction.ID)},
+		{Prompt: "type", Name: "type", Value: transaction.Type},
+		{Prompt: "description", Name: "description", Value: transaction.Description},
+		{Prompt: "amount", Name: "amount", Value: formatAmount(transaction.Amount)},
+		{Prompt: "currency", Name: "currency", Value: transaction.Currency},
+		{Prompt: "created", Name: "created", Value: transaction.Created},
+	}
+}
@@ -0,0 +1,53 @@
+package serializers
+
+import (
+	"fmt"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func CheckoutItemsSerializer(username string, checkouts []db.Checkout) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/billings/checkouts/", userConst.Href, username)
+	items := make([]utils.CollectionItem, 0, len(checkouts))
+	for _, checkout := range checkouts {
+		links := []utils.CollectionLink{{Href: checkout.URL, Rel: "pay", Render: "link"}}
+		if checkout.TransactionID.Valid {
+			links = append(links, utils.CollectionLink{
+				Href:   fmt.Sprintf("%s%s/billings/%d/", userConst.Href, username, checkout.TransactionID.Int64),
+				Rel:    "billing",
+				Render: "link",
+			})
+		}
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "payment provider", Name: "provider", Value: checkout.Provider},
+				{Prompt: "checkout id", Name: "id", Value: checkout.ID},
+				{Prompt: "amount", Name: "amount", Value: formatAmount(checkout.Amount)},
+				{Prompt: "currency", Name: "currency", Value: checkout.Currency},
+				{Prompt: "status", Name: "status", Value: checkout.Status},
+				{Prompt: "created", Name: "created", Value: checkout.Created},
+				{Prompt: "settled", Name: "settled", Value: checkout.Settled.String},
+			},
+			Links: links,
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items:   items,
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{
+				Data: []utils.DataField{
+					{Prompt: "payment provider", Name: "provider", Value: ""},
+					{Prompt: "amount to top up", Name: "amount", Value: ""},
+					{Prompt: "currency", Name: "currency", Value: db.DefaultCurrency},
+				},
+			},
+		},
+	})
+}
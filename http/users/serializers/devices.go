@@ -0,0 +1,57 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+func DeviceCredentialSerializer(href string, device db.Device, secret string) ([]byte, error) {
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: []utils.DataField{
+					{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(device.ID)},
+					{Prompt: "secret, shown only once", Name: "secret", Value: secret},
+				},
+				Links: []utils.CollectionLink{},
+			}},
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{}},
+		},
+	})
+}
+
+func DeviceIdentifierItemsSerializer(href string, identifiers []db.DeviceIdentifier) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, identifier := range identifiers {
+		items = append(items, utils.CollectionItem{
+			Href: fmt.Sprintf("%s%s/%s/", href, identifier.Protocol, identifier.Identifier),
+			Data: []utils.DataField{
+				{Prompt: "protocol", Name: "protocol", Value: identifier.Protocol},
+				{Prompt: "identifier", Name: "identifier", Value: identifier.Identifier},
+				{Prompt: "created", Name: "created", Value: identifier.Created},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items:   items,
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{
+				{Prompt: "protocol, osmand or imei", Name: "protocol", Value: ""},
+				{Prompt: "identifier", Name: "identifier", Value: ""},
+			}},
+		},
+	})
+}
@@ -0,0 +1,29 @@
+package serializers
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// formatCoordinate renders a coordinate or distance as the value of a data field.
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatNullCoordinate renders an optional coordinate or distance, leaving it empty when unset.
+func formatNullCoordinate(value sql.NullFloat64) string {
+	if !value.Valid {
+		return ""
+	}
+	return formatCoordinate(value.Float64)
+}
+
+// formatAmount renders an amount in minor units with two decimal places.
+func formatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
@@ -0,0 +1,87 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func GeofenceItemsSerializer(username string, geofences []*db.Geofence) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/geofences/", userConst.Href, username)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    serializeGeofenceItems(href, geofences),
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: geofenceCollectionTemplate(),
+		},
+	})
+}
+
+func GeofenceEventItemsSerializer(geofence *db.Geofence, events []db.GeofenceEvent) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/geofences/%s/events/", userConst.Href, geofence.User, geofence.ID)
+	var items []utils.CollectionItem
+	for _, event := range events {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "event", Name: "kind", Value: event.Kind},
+				{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(event.DeviceID)},
+				{Prompt: "time stamp", Name: "time_stamp", Value: event.TimeStamp},
+				{Prompt: "latitude", Name: "latitude", Value: formatCoordinate(event.Latitude)},
+				{Prompt: "longitude", Name: "longitude", Value: formatCoordinate(event.Longitude)},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func serializeGeofenceItems(href string, geofences []*db.Geofence) (serializedItems []utils.CollectionItem) {
+	for _, geofence := range geofences {
+		itemSlug := fmt.Sprintf("%s%s/", href, geofence.ID)
+		serializedItems = append(serializedItems, utils.CollectionItem{
+			Href: itemSlug,
+			Data: []utils.DataField{
+				{Prompt: "name", Name: "name", Value: geofence.Name},
+				{Prompt: "kind", Name: "kind", Value: geofence.Kind},
+				{Prompt: "cloak id", Name: "cloak_id", Value: geofence.CloakID.String},
+				{Prompt: "latitude", Name: "latitude", Value: formatNullCoordinate(geofence.Latitude)},
+				{Prompt: "longitude", Name: "longitude", Value: formatNullCoordinate(geofence.Longitude)},
+				{Prompt: "radius in metres", Name: "radius", Value: formatNullCoordinate(geofence.Radius)},
+				{Prompt: "vertices", Name: "vertices", Value: geofence.Vertices.String},
+			},
+			Links: []utils.CollectionLink{
+				{Href: itemSlug + "events/", Rel: "enter and exit events", Render: "link"},
+			},
+		})
+	}
+	return
+}
+
+func geofenceCollectionTemplate() (geofenceTemplate utils.ItemTemplate) {
+	geofenceTemplate.Data = []utils.DataField{
+		{Prompt: "name", Name: "name", Value: ""},
+		{Prompt: "kind, circle or polygon", Name: "kind", Value: ""},
+		{Prompt: "owning cloak id", Name: "cloak_id", Value: ""},
+		{Prompt: "center latitude", Name: "latitude", Value: ""},
+		{Prompt: "center longitude", Name: "longitude", Value: ""},
+		{Prompt: "radius in metres", Name: "radius", Value: ""},
+		{Prompt: "vertices", Name: "vertices", Value: ""},
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package serializers
+
+import (
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+func RejectedSnapshotItemsSerializer(href string, rejected []db.RejectedSnapshot) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, snapshot := range rejected {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "time stamp", Name: "time_stamp", Value: snapshot.TimeStamp},
+				{Prompt: "latitude", Name: "latitude", Value: formatCoordinate(snapshot.Latitude)},
+				{Prompt: "longitude", Name: "longitude", Value: formatCoordinate(snapshot.Longitude)},
+				{Prompt: "reason", Name: "reason", Value: snapshot.Reason},
+				{Prompt: "received", Name: "received", Value: snapshot.Received},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func LocationSnapshotItemsSerializer(href string, snapshots []db.LocationSnapshot) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, snapshot := range snapshots {
+		items = append(items, utils.CollectionItem{
+			Href:  href,
+			Data:  locationSnapshotData(snapshot),
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items:   items,
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{
+				{Href: href, Rel: "search", Prompt: "location history within a period",
+					Data: []utils.DataField{
+						{Prompt: "from", Name: "from", Value: ""},
+						{Prompt: "to", Name: "to", Value: ""},
+						{Prompt: "one point per minutes", Name: "downsample", Value: ""},
+						{Prompt: "simplify tolerance in metres", Name: "simplify", Value: ""},
+					}},
+			},
+			Template: locationSnapshotTemplate(),
+		},
+	})
+}
+
+// LatestLocationItemsSerializer serializes the latest location of each device, naming the
+// device each belongs to.
+func LatestLocationItemsSerializer(href string, snapshots []db.LocationSnapshot) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, snapshot := range snapshots {
+		data := []utils.DataField{
+			{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(snapshot.DeviceID)},
+		}
+		items = append(items, utils.CollectionItem{
+			Href:  href,
+			Data:  append(data, locationSnapshotData(snapshot)...),
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func locationSnapshotData(snapshot db.LocationSnapshot) []utils.DataField {
+	battery := ""
+	if snapshot.Battery.Valid {
+		battery = strconv.FormatInt(snapshot.Battery.Int64, 10)
+	}
+	return []utils.DataField{
+		{Prompt: "time stamp", Name: "time_stamp", Value: snapshot.TimeStamp},
+		{Prompt: "latitude", Name: "latitude", Value: formatCoordinate(snapshot.Latitude)},
+		{Prompt: "longitude", Name: "longitude", Value: formatCoordinate(snapshot.Longitude)},
+		{Prompt: "altitude in metres", Name: "altitude", Value: formatNullCoordinate(snapshot.Altitude)},
+		{Prompt: "accuracy radius in metres", Name: "accuracy", Value: formatNullCoordinate(snapshot.Accuracy)},
+		{Prompt: "speed in metres per second", Name: "speed", Value: formatNullCoordinate(snapshot.Speed)},
+		{Prompt: "bearing in degrees", Name: "bearing", Value: formatNullCoordinate(snapshot.Bearing)},
+		{Prompt: "battery percentage", Name: "battery", Value: battery},
+		{Prompt: "provider", Name: "provider", Value: snapshot.Provider.String},
+		{Prompt: "received", Name: "received", Value: snapshot.Received},
+		{Prompt: "place", Name: "place", Value: snapshot.PlaceLabel()},
+	}
+}
+
+func locationSnapshotTemplate() (snapshotTemplate utils.ItemTemplate) {
+	snapshotTemplate.Data = []utils.DataField{
+		{Prompt: "time stamp", Name: "time_stamp", Value: ""},
+		{Prompt: "latitude", Name: "latitude", Value: ""},
+		{Prompt: "longitude", Name: "longitude", Value: ""},
+		{Prompt: "altitude in metres", Name: "altitude", Value: ""},
+		{Prompt: "accuracy radius in metres", Name: "accuracy", Value: ""},
+		{Prompt: "speed in metres per second", Name: "speed", Value: ""},
+		{Prompt: "bearing in degrees", Name: "bearing", Value: ""},
+		{Prompt: "battery percentage", Name: "battery", Value: ""},
+		{Prompt: "provider, gps, network or fused", Name: "provider", Value: ""},
+	}
+	return
+}
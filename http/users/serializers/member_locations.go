@@ -0,0 +1,42 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func MemberLocationItemsSerializer(href, username, cloakID string, locations []db.MemberLocation) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, location := range locations {
+		memberHref := fmt.Sprintf("%s%s/cloaks/%s/members/%d/location-history/",
+			userConst.Href, username, cloakID, location.Device.ID)
+		items = append(items, utils.CollectionItem{
+			Href: memberHref,
+			Data: []utils.DataField{
+				{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(location.Device.ID)},
+				{Prompt: "time stamp", Name: "time_stamp", Value: location.Snapshot.TimeStamp},
+				{Prompt: "latitude", Name: "latitude", Value: formatCoordinate(location.Snapshot.Latitude)},
+				{Prompt: "longitude", Name: "longitude", Value: formatCoordinate(location.Snapshot.Longitude)},
+				{Prompt: "accuracy radius in metres", Name: "accuracy",
+					Value: formatNullCoordinate(location.Snapshot.Accuracy)},
+				{Prompt: "place", Name: "place", Value: location.Snapshot.PlaceLabel()},
+				{Prompt: "distance in metres", Name: "distance", Value: formatCoordinate(location.Distance)},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{}},
+		},
+	})
+}
@@ -0,0 +1,51 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func PlanSerializer(username string, plan db.Plan, usage db.PlanUsage) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/plan/", userConst.Href, username)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: []utils.DataField{
+					{Prompt: "plan", Name: "plan", Value: plan.Name},
+					{Prompt: "devices owned", Name: "devices", Value: strconv.Itoa(usage.Devices)},
+					{Prompt: "devices allowed", Name: "max_devices", Value: formatLimit(plan.MaxDevices)},
+					{Prompt: "cloaks created", Name: "cloaks", Value: strconv.Itoa(usage.Cloaks)},
+					{Prompt: "cloaks allowed", Name: "max_cloaks", Value: formatLimit(plan.MaxCloaks)},
+					{Prompt: "members of the largest cloak", Name: "members", Value: strconv.Itoa(usage.MostMembers)},
+					{Prompt: "members allowed per cloak", Name: "max_members",
+						Value: formatLimit(plan.MaxMembersPerCloak)},
+					{Prompt: "days of history kept", Name: "retention", Value: strconv.Itoa(usage.RetentionDays)},
+					{Prompt: "days of history allowed", Name: "max_retention", Value: formatLimit(plan.RetentionDays)},
+					{Prompt: "seconds between snapshots", Name: "min_snapshot_interval",
+						Value: formatLimit(int(plan.MinSnapshotInterval.Seconds()))},
+				},
+				Links: []utils.CollectionLink{
+					{Href: fmt.Sprintf("%s%s/retention/", userConst.Href, username), Rel: "retention", Render: "link"},
+				},
+			}},
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+// formatLimit renders a limit of a plan, leaving it empty when there is none.
+func formatLimit(limit int) string {
+	if limit == 0 {
+		return ""
+	}
+	return strconv.Itoa(limit)
+}
@@ -0,0 +1,63 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func ProximityRuleSerializer(username string, rule *db.ProximityRule) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/cloaks/%s/proximity/", userConst.Href, username, rule.CloakID)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: []utils.DataField{
+					{Prompt: "distance in metres", Name: "distance", Value: formatCoordinate(rule.Distance)},
+					{Prompt: "hysteresis in metres", Name: "hysteresis", Value: formatCoordinate(rule.Hysteresis)},
+				},
+				Links: []utils.CollectionLink{
+					{Href: fmt.Sprintf("%s%s/cloaks/%s/proximity-events/", userConst.Href, username, rule.CloakID),
+						Rel: "proximity events", Render: "link"},
+				},
+			}},
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{
+				{Prompt: "distance in metres", Name: "distance", Value: ""},
+			}},
+		},
+	})
+}
+
+func ProximityEventItemsSerializer(username, cloakID string, events []db.ProximityEvent) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/cloaks/%s/proximity-events/", userConst.Href, username, cloakID)
+	var items []utils.CollectionItem
+	for _, event := range events {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "event", Name: "kind", Value: event.Kind},
+				{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(event.DeviceID)},
+				{Prompt: "other device id", Name: "other_device_id", Value: strconv.Itoa(event.OtherDeviceID)},
+				{Prompt: "time stamp", Name: "time_stamp", Value: event.TimeStamp},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
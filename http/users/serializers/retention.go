@@ -0,0 +1,85 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func UserRetentionSerializer(username string, days int, custom bool) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/retention/", userConst.Href, username)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: []utils.DataField{
+					{Prompt: "days kept, 0 for forever", Name: "days", Value: strconv.Itoa(days)},
+					{Prompt: "set by user", Name: "custom", Value: strconv.FormatBool(custom)},
+				},
+				Links: []utils.CollectionLink{
+					{Href: href + "runs/", Rel: "retention runs", Render: "link"},
+				},
+			}},
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{
+				{Prompt: "days kept", Name: "days", Value: ""},
+			}},
+		},
+	})
+}
+
+func CloakRetentionSerializer(username, cloakID string, days int) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/cloaks/%s/retention/", userConst.Href, username, cloakID)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: []utils.DataField{
+					{Prompt: "most days kept", Name: "days", Value: strconv.Itoa(days)},
+				},
+				Links: []utils.CollectionLink{},
+			}},
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{Data: []utils.DataField{
+				{Prompt: "most days kept", Name: "days", Value: ""},
+			}},
+		},
+	})
+}
+
+func RetentionRunItemsSerializer(username string, runs []db.RetentionRun) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/retention/runs/", userConst.Href, username)
+	var items []utils.CollectionItem
+	for _, run := range runs {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: []utils.DataField{
+				{Prompt: "device id", Name: "device_id", Value: strconv.Itoa(run.DeviceID)},
+				{Prompt: "purged before", Name: "cutoff", Value: run.Cutoff},
+				{Prompt: "snapshots purged", Name: "purged", Value: strconv.Itoa(run.Purged)},
+				{Prompt: "archived", Name: "archived", Value: strconv.FormatBool(run.Archived)},
+				{Prompt: "ran", Name: "ran", Value: run.Ran},
+			},
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/invoices"
+	"github.com/mcctor/marauders/utils"
+)
+
+func StatementItemsSerializer(username string, statements []db.Statement) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/billings/statements/", userConst.Href, username)
+	items := make([]utils.CollectionItem, 0, len(statements))
+	for _, statement := range statements {
+		items = append(items, statementItem(href, statement))
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func StatementSerializer(username string, statement db.Statement) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/billings/statements/", userConst.Href, username)
+	item := statementItem(href, statement)
+	items := []utils.CollectionItem{item}
+	for _, line := range statement.Lines {
+		items = append(items, utils.CollectionItem{
+			Href: item.Href,
+			Data: []utils.DataField{
+				{Prompt: "line", Name: "line", Value: strconv.Itoa(line.Line)},
+				{Prompt: "transaction id", Name: "transaction_id", Value: strconv.Itoa(line.TransactionID)},
+				{Prompt: "type", Name: "type", Value: line.Type},
+				{Prompt: "description", Name: "description", Value: line.Description},
+				{Prompt: "amount", Name: "amount", Value: formatAmount(line.Amount)},
+				{Prompt: "posted", Name: "posted", Value: line.Posted},
+			},
+			Links: []utils.CollectionLink{
+				{Href: fmt.Sprintf("%s%s/billings/%d/", userConst.Href, username, line.TransactionID), Rel: "billing",
+					Render: "link"},
+			},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     item.Href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func statementItem(href string, statement db.Statement) utils.CollectionItem {
+	itemHref := fmt.Sprintf("%s%d/", href, statement.Number)
+	return utils.CollectionItem{
+		Href: itemHref,
+		Data: []utils.DataField{
+			{Prompt: "invoice number", Name: "invoice", Value: invoices.Number(statement)},
+			{Prompt: "period start", Name: "period_start", Value: statement.PeriodStart},
+			{Prompt: "period end", Name: "period_end", Value: statement.PeriodEnd},
+			{Prompt: "currency", Name: "currency", Value: statement.Currency},
+			{Prompt: "opening balance", Name: "opening", Value: formatAmount(statement.Opening)},
+			{Prompt: "closing balance", Name: "closing", Value: formatAmount(statement.Closing)},
+			{Prompt: "issued", Name: "issued", Value: statement.Issued},
+		},
+		Links: []utils.CollectionLink{
+			{Href: itemHref + "?format=" + invoices.FormatCSV, Rel: "csv invoice", Render: "link"},
+			{Href: itemHref + "?format=" + invoices.FormatPDF, Rel: "pdf invoice", Render: "link"},
+		},
+	}
+}
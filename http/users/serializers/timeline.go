@@ -0,0 +1,60 @@
+package serializers
+
+import (
+	"strconv"
+
+	"github.com/mcctor/marauders/timeline"
+	"github.com/mcctor/marauders/utils"
+)
+
+func TimelineSerializer(href string, segments []timeline.Segment) ([]byte, error) {
+	var items []utils.CollectionItem
+	for _, segment := range segments {
+		data := []utils.DataField{
+			{Prompt: "kind", Name: "kind", Value: segment.Kind},
+			{Prompt: "start", Name: "start", Value: segment.Start.Format(utils.TimeFormat)},
+			{Prompt: "end", Name: "end", Value: segment.End.Format(utils.TimeFormat)},
+			{Prompt: "duration in seconds", Name: "duration",
+				Value: strconv.Itoa(int(segment.Duration().Seconds()))},
+		}
+		if segment.Kind == timeline.KindStay {
+			data = append(data,
+				utils.DataField{Prompt: "latitude", Name: "latitude", Value: formatCoordinate(segment.From.Latitude)},
+				utils.DataField{Prompt: "longitude", Name: "longitude", Value: formatCoordinate(segment.From.Longitude)},
+				utils.DataField{Prompt: "place", Name: "place", Value: segment.Place},
+			)
+		} else {
+			data = append(data,
+				utils.DataField{Prompt: "start latitude", Name: "start_latitude",
+					Value: formatCoordinate(segment.From.Latitude)},
+				utils.DataField{Prompt: "start longitude", Name: "start_longitude",
+					Value: formatCoordinate(segment.From.Longitude)},
+				utils.DataField{Prompt: "end latitude", Name: "end_latitude",
+					Value: formatCoordinate(segment.To.Latitude)},
+				utils.DataField{Prompt: "end longitude", Name: "end_longitude",
+					Value: formatCoordinate(segment.To.Longitude)},
+				utils.DataField{Prompt: "distance in metres", Name: "distance",
+					Value: strconv.Itoa(int(segment.Distance))},
+				utils.DataField{Prompt: "mode of travel", Name: "mode", Value: segment.Mode},
+			)
+		}
+		items = append(items, utils.CollectionItem{
+			Href:  href,
+			Data:  data,
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items:   items,
+			Links:   []utils.CollectionLink{},
+			Queries: []utils.CollectionQuery{
+				{Href: href, Rel: "search", Prompt: "timeline for another day",
+					Data: []utils.DataField{{Prompt: "date", Name: "date", Value: ""}}},
+			},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
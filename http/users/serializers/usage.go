@@ -0,0 +1,69 @@
+package serializers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+	userConst "github.com/mcctor/marauders/http/users"
+	"github.com/mcctor/marauders/utils"
+)
+
+func UsageSerializer(username string, usage db.Usage, charge db.Money) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/usage/", userConst.Href, username)
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version: utils.CollectionVersion,
+			Href:    href,
+			Items: []utils.CollectionItem{{
+				Href: href,
+				Data: append(usageData(usage),
+					utils.DataField{Prompt: "charge so far", Name: "amount", Value: formatAmount(charge.Amount)},
+					utils.DataField{Prompt: "currency", Name: "currency", Value: charge.Currency}),
+				Links: []utils.CollectionLink{
+					{Href: href + "statements/", Rel: "usage statements", Render: "link"},
+				},
+			}},
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func UsageStatementItemsSerializer(username string, statements []db.UsageStatement) ([]byte, error) {
+	href := fmt.Sprintf("%s%s/usage/statements/", userConst.Href, username)
+	var items []utils.CollectionItem
+	for _, statement := range statements {
+		items = append(items, utils.CollectionItem{
+			Href: href,
+			Data: append(usageData(statement.Usage),
+				utils.DataField{Prompt: "charge", Name: "amount", Value: formatAmount(statement.Amount)},
+				utils.DataField{Prompt: "currency", Name: "currency", Value: statement.Currency},
+				utils.DataField{Prompt: "billed", Name: "billed", Value: strconv.FormatBool(statement.Billed)},
+				utils.DataField{Prompt: "closed", Name: "closed", Value: statement.Closed}),
+			Links: []utils.CollectionLink{},
+		})
+	}
+	return userCollectionSerializer(utils.Collection{
+		Collection: utils.ItemsCollection{
+			Version:  utils.CollectionVersion,
+			Href:     href,
+			Items:    items,
+			Links:    []utils.CollectionLink{},
+			Queries:  []utils.CollectionQuery{},
+			Template: utils.ItemTemplate{},
+		},
+	})
+}
+
+func usageData(usage db.Usage) []utils.DataField {
+	return []utils.DataField{
+		{Prompt: "period start", Name: "period_start", Value: usage.PeriodStart},
+		{Prompt: "period end", Name: "period_end", Value: usage.PeriodEnd},
+		{Prompt: "snapshots stored", Name: "snapshots", Value: strconv.Itoa(usage.Snapshots)},
+		{Prompt: "active devices", Name: "active_devices", Value: strconv.Itoa(usage.ActiveDevices)},
+		{Prompt: "cloaks", Name: "cloaks", Value: strconv.Itoa(usage.Cloaks)},
+		{Prompt: "cloak members", Name: "members", Value: strconv.Itoa(usage.Members)},
+	}
+}
@@ -0,0 +1,33 @@
+package invoices
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/mcctor/marauders/db"
+)
+
+var csvHeader = []string{"invoice", "date", "type", "description", "amount", "currency"}
+
+// writeCSV writes a row for the opening balance, one for every line of the statement and
+// one for the closing balance.
+func writeCSV(w io.Writer, statement db.Statement) error {
+	number := Number(statement)
+	rows := [][]string{
+		csvHeader,
+		{number, statement.PeriodStart, "opening", "opening balance", formatAmount(statement.Opening),
+			statement.Currency},
+	}
+	for _, line := range statement.Lines {
+		rows = append(rows, []string{number, line.Posted, line.Type, line.Description, formatAmount(line.Amount),
+			statement.Currency})
+	}
+	rows = append(rows, []string{number, statement.PeriodEnd, "closing", "closing balance",
+		formatAmount(statement.Closing), statement.Currency})
+
+	out := csv.NewWriter(w)
+	if err := out.WriteAll(rows); err != nil {
+		return err
+	}
+	return out.Error()
+}
@@ -0,0 +1,47 @@
+// Package invoices renders issued statements as invoices users can download.
+package invoices
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	FormatCSV = "csv"
+	FormatPDF = "pdf"
+)
+
+// Number is the invoice number of the statement, as printed on the invoice.
+func Number(statement db.Statement) string {
+	return fmt.Sprintf("INV-%06d", statement.Number)
+}
+
+// Write renders the statement to w as an invoice in the passed format.
+func Write(format string, w io.Writer, statement db.Statement) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, statement)
+	case FormatPDF:
+		return writePDF(w, statement)
+	}
+	return fmt.Errorf("unknown invoice format <%s>", format)
+}
+
+// ContentType is the media type of invoices in the passed format.
+func ContentType(format string) string {
+	if format == FormatPDF {
+		return "application/pdf"
+	}
+	return "text/csv"
+}
+
+// formatAmount renders an amount in minor units with two decimal places.
+func formatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
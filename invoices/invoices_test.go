@@ -0,0 +1,89 @@
+package invoices
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+var statement = db.Statement{
+	Number:      42,
+	User:        "john",
+	PeriodStart: "2026-09-01 00:00:00",
+	PeriodEnd:   "2026-10-01 00:00:00",
+	Currency:    "USD",
+	Opening:     1000,
+	Closing:     1250,
+	Issued:      "2026-10-01 00:05:00",
+	Lines: []db.StatementLine{
+		{Line: 1, Type: db.TransactionCharge, Description: "usage (September)", Amount: 750,
+			Posted: "2026-09-30 23:59:59"},
+		{Line: 2, Type: db.TransactionPayment, Description: "card", Amount: -500, Posted: "2026-09-15 12:00:00"},
+	},
+}
+
+func TestCSV(t *testing.T) {
+	t.Log("Given the need to test exporting a statement as a CSV invoice.")
+	{
+		var out bytes.Buffer
+		if err := Write(FormatCSV, &out, statement); err != nil {
+			t.Fatal("\t\tShould write the invoice:", failMark, err)
+		}
+		rows, err := csv.NewReader(&out).ReadAll()
+		if err != nil || len(rows) != 5 {
+			t.Fatal("\t\tShould have a header, opening, two lines and closing:", failMark, rows, err)
+		}
+		if rows[1][4] != "10.00" || rows[3][4] != "-5.00" || rows[4][4] != "12.50" || rows[4][0] != "INV-000042" {
+			t.Fatal("\t\tShould state the balances and amounts:", failMark, rows)
+		}
+		t.Log("\t\tShould state the balances and amounts:", passMark)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	t.Log("Given the need to test exporting a statement as a PDF invoice.")
+	{
+		long := statement
+		long.Lines = nil
+		for i := 0; i < 3*linesPerPage; i++ {
+			long.Lines = append(long.Lines, db.StatementLine{Line: i + 1, Type: db.TransactionCharge,
+				Description: "(daily) usage", Amount: 1, Posted: "2026-09-02 00:00:00"})
+		}
+		var out bytes.Buffer
+		if err := Write(FormatPDF, &out, long); err != nil {
+			t.Fatal("\t\tShould write the invoice:", failMark, err)
+		}
+		pdf := out.String()
+		if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+			t.Fatal("\t\tShould be framed as a PDF:", failMark)
+		}
+		if !strings.Contains(pdf, "/Count 4") || !strings.Contains(pdf, `\(daily\) usage`) {
+			t.Fatal("\t\tShould spread the lines over four pages with escaped text:", failMark)
+		}
+		t.Log("\t\tShould spread the lines over four pages with escaped text:", passMark)
+
+		startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+		xref, _ := strconv.Atoi(startxref[1])
+		if !strings.HasPrefix(pdf[xref:], "xref\n") {
+			t.Fatal("\t\tShould point startxref at the cross-reference table:", failMark, xref)
+		}
+		for i, offset := range regexp.MustCompile(`(\d{10}) 00000 n`).FindAllStringSubmatch(pdf[xref:], -1) {
+			at, _ := strconv.Atoi(offset[1])
+			if !strings.HasPrefix(pdf[at:], fmt.Sprintf("%d 0 obj", i+1)) {
+				t.Fatal("\t\tShould give the offset of every object:", failMark, i+1, at)
+			}
+		}
+		t.Log("\t\tShould give the offset of every object:", passMark)
+	}
+}
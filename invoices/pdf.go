@@ -0,0 +1,116 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	// pages are A4 in points, with text set in 10 point Courier
+	pageWidth    = 595
+	pageHeight   = 842
+	margin       = 56
+	leading      = 14
+	linesPerPage = (pageHeight - 2*margin) / leading
+)
+
+// writePDF lays the invoice out as monospaced text over as many pages as it needs, in a
+// PDF small enough to be written by hand: a catalog, a page tree, a font and a page with
+// its content stream for every page.
+func writePDF(w io.Writer, statement db.Statement) error {
+	text := invoiceText(statement)
+	var pages [][]string
+	for len(text) > linesPerPage {
+		pages, text = append(pages, text[:linesPerPage]), text[linesPerPage:]
+	}
+	pages = append(pages, text)
+
+	// objects 1 to 3 are the catalog, page tree and font, followed by a page and its
+	// content stream for every page
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+	)
+	for i, lines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F1 10 Tf %d TL %d %d Td\n", leading, margin, pageHeight-margin)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) '\n", escapePDF(line))
+		}
+		content.WriteString("ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+				"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// invoiceText is the invoice as lines of text, with the balances and amounts lined up on
+// the right.
+func invoiceText(statement db.Statement) []string {
+	row := func(date, description string, amount int64) string {
+		if len(description) > 40 {
+			description = description[:37] + "..."
+		}
+		return fmt.Sprintf("%-19s  %-40s %14s", date, description, formatAmount(amount))
+	}
+	text := []string{
+		"INVOICE " + Number(statement),
+		"",
+		"Billed to: " + statement.User,
+		"Period:    " + statement.PeriodStart + " to " + statement.PeriodEnd,
+		"Issued:    " + statement.Issued,
+		"Currency:  " + statement.Currency,
+		"",
+		row(statement.PeriodStart, "Opening balance", statement.Opening),
+	}
+	for _, line := range statement.Lines {
+		text = append(text, row(line.Posted, line.Type+": "+line.Description, line.Amount))
+	}
+	return append(text, row(statement.PeriodEnd, "Closing balance", statement.Closing))
+}
+
+// escapePDF escapes the characters that end or escape a PDF string, and replaces what the
+// standard Courier font cannot show.
+func escapePDF(line string) string {
+	var escaped strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r < ' ' || r > '~':
+			escaped.WriteRune('?')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
@@ -1,17 +1,74 @@
 package main
 
 import (
+	"context"
 	"log"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	_ "github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geocode"
 	"github.com/mcctor/marauders/http"
+	_ "github.com/mcctor/marauders/http/ingest/handlers"
+	_ "github.com/mcctor/marauders/http/payments/handlers"
 	_ "github.com/mcctor/marauders/http/users/handlers"
+	"github.com/mcctor/marauders/payments"
+	"github.com/mcctor/marauders/tracker"
+	"github.com/mcctor/marauders/tracker/protocol"
 )
 
+// trackerPorts are the ports hardware trackers connect to, by protocol.
+var trackerPorts = map[string]string{
+	protocol.GT06{}.Name():  ":5023",
+	protocol.TK103{}.Name(): ":5002",
+}
+
 func main() {
+	// place names are only given when both reverse geocoding datasets are configured
+	citiesPath, countriesPath := os.Getenv("MARAUDERS_GEONAMES_CITIES"), os.Getenv("MARAUDERS_COUNTRY_BOUNDARIES")
+	if citiesPath != "" && countriesPath != "" {
+		geocoder, err := geocode.Load(citiesPath, countriesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		geocode.Default = geocoder
+	}
+	// the stub provider takes no real money and is only enabled for trying out payments
+	if secret := os.Getenv("MARAUDERS_STUB_PAYMENTS_SECRET"); secret != "" {
+		payments.Register(payments.NewStub([]byte(secret), http.ServerAddr))
+	}
+	db.StartRetentionJob(time.Hour)
+	db.StartMeteringJob(time.Hour)
+	for name, addr := range trackerPorts {
+		server := &tracker.Server{Addr: addr, Decoder: protocol.Decoders[name]}
+		go func(name string) {
+			log.Printf("Started %s tracker server at %s ...", name, server.Addr)
+			log.Fatal(server.ListenAndServe())
+		}(name)
+	}
+	stopIngest := db.StartBatchedIngest()
+	// ListenAndServe returns as soon as shutting down starts, so requests still in flight
+	// are waited for before the ingest queue is drained
+	shutDown := make(chan struct{})
+	go func() {
+		defer close(shutDown)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("Shutting down Marauders server ...")
+		if err := http.Server.Shutdown(context.Background()); err != nil {
+			log.Print(err)
+		}
+	}()
 	log.Println("Started Marauders server at port 8080 ...")
-	if err := http.Server.ListenAndServe(); err != nil {
+	if err := http.Server.ListenAndServe(); err != nil && err != nethttp.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-shutDown
+	// snapshots still queued are written before exiting
+	stopIngest()
 }
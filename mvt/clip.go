@@ -0,0 +1,83 @@
+package mvt
+
+import "math"
+
+// ClipLine cuts the line to the square running from min to max along both axes, such as
+// a tile and its buffer. Segments crossing the edges are cut where they cross, so a line
+// leaving the square and coming back is returned as a line for each pass through it.
+func ClipLine(line []Coordinate, min, max int) [][]Coordinate {
+	var lines [][]Coordinate
+	var current []Coordinate
+	flush := func() {
+		if len(current) > 1 {
+			lines = append(lines, current)
+		}
+		current = nil
+	}
+	for i := 1; i < len(line); i++ {
+		from, to, ok := clipSegment(line[i-1], line[i], float64(min), float64(max))
+		if !ok {
+			flush()
+			continue
+		}
+		if len(current) == 0 || current[len(current)-1] != from {
+			flush()
+			current = []Coordinate{from}
+		}
+		if to != from {
+			current = append(current, to)
+		}
+	}
+	flush()
+	return lines
+}
+
+// clipSegment cuts the segment from a to b to the square running from min to max along
+// both axes, using the Liang-Barsky algorithm. It reports false if no part of the segment
+// lies within the square.
+func clipSegment(a, b Coordinate, min, max float64) (from, to Coordinate, ok bool) {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	start, end := 0.0, 1.0
+	edges := [4][2]float64{
+		{-dx, float64(a.X) - min},
+		{dx, max - float64(a.X)},
+		{-dy, float64(a.Y) - min},
+		{dy, max - float64(a.Y)},
+	}
+	for _, edge := range edges {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			// parallel to the edge, and outside of it if q is negative
+			if q < 0 {
+				return from, to, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > end {
+				return from, to, false
+			}
+			start = math.Max(start, r)
+		} else {
+			if r < start {
+				return from, to, false
+			}
+			end = math.Min(end, r)
+		}
+	}
+	at := func(t float64) Coordinate {
+		return Coordinate{
+			X: int(math.Round(float64(a.X) + t*dx)),
+			Y: int(math.Round(float64(a.Y) + t*dy)),
+		}
+	}
+	from, to = a, b
+	if start > 0 {
+		from = at(start)
+	}
+	if end < 1 {
+		to = at(end)
+	}
+	return from, to, true
+}
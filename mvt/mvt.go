@@ -0,0 +1,203 @@
+// Package mvt encodes Mapbox Vector Tiles, following version 2.1 of the specification.
+// Only what the server draws is supported: points and lines with string and number
+// properties.
+package mvt
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultExtent is the number of units across a tile its geometry is laid out in.
+const DefaultExtent = 4096
+
+// geometry types
+const (
+	typePoint      = 1
+	typeLineString = 2
+)
+
+// geometry commands
+const (
+	commandMoveTo = 1
+	commandLineTo = 2
+)
+
+// Coordinate is a position within a tile in extent units, from its north west corner.
+// Positions in the buffer around a tile fall outside 0 to the extent.
+type Coordinate struct {
+	X, Y int
+}
+
+// Feature is a point or a line along with its properties. Property values have to be
+// strings, integers or floats.
+type Feature struct {
+	ID         uint64
+	Points     []Coordinate
+	Line       []Coordinate
+	Properties map[string]interface{}
+}
+
+// Layer is a named set of features.
+type Layer struct {
+	Name     string
+	Extent   int
+	Features []Feature
+}
+
+// Encode returns the protocol buffer encoding of a tile made up of the passed layers.
+// Empty layers are left out.
+func Encode(layers ...Layer) []byte {
+	var tile []byte
+	for _, layer := range layers {
+		if len(layer.Features) == 0 {
+			continue
+		}
+		tile = appendBytesField(tile, 3, encodeLayer(layer))
+	}
+	return tile
+}
+
+func encodeLayer(layer Layer) []byte {
+	extent := layer.Extent
+	if extent <= 0 {
+		extent = DefaultExtent
+	}
+	keys, values := newTable(), newTable()
+	var encodedFeatures [][]byte
+	for _, feature := range layer.Features {
+		if encoded := encodeFeature(feature, keys, values); encoded != nil {
+			encodedFeatures = append(encodedFeatures, encoded)
+		}
+	}
+
+	encoded := appendVarintField(nil, 15, 2)
+	encoded = appendBytesField(encoded, 1, []byte(layer.Name))
+	for _, feature := range encodedFeatures {
+		encoded = appendBytesField(encoded, 2, feature)
+	}
+	for _, key := range keys.entries {
+		encoded = appendBytesField(encoded, 3, []byte(key.(string)))
+	}
+	for _, value := range values.entries {
+		encoded = appendBytesField(encoded, 4, encodeValue(value))
+	}
+	return appendVarintField(encoded, 5, uint64(extent))
+}
+
+func encodeFeature(feature Feature, keys, values *table) []byte {
+	var geometryType uint64
+	var geometry []uint32
+	switch {
+	case len(feature.Points) > 0:
+		geometryType = typePoint
+		geometry = append(geometry, command(commandMoveTo, len(feature.Points)))
+		geometry = appendCoordinates(geometry, Coordinate{}, feature.Points)
+	case len(feature.Line) > 1:
+		geometryType = typeLineString
+		geometry = append(geometry, command(commandMoveTo, 1))
+		geometry = appendCoordinates(geometry, Coordinate{}, feature.Line[:1])
+		geometry = append(geometry, command(commandLineTo, len(feature.Line)-1))
+		geometry = appendCoordinates(geometry, feature.Line[0], feature.Line[1:])
+	default:
+		return nil
+	}
+
+	// properties are tagged in key order so that the same feature always encodes the same
+	names := make([]string, 0, len(feature.Properties))
+	for name := range feature.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var tags []uint32
+	for _, name := range names {
+		value := normalizeValue(feature.Properties[name])
+		if value == nil {
+			continue
+		}
+		tags = append(tags, keys.index(name), values.index(value))
+	}
+
+	var encoded []byte
+	if feature.ID != 0 {
+		encoded = appendVarintField(encoded, 1, feature.ID)
+	}
+	if len(tags) > 0 {
+		encoded = appendPackedField(encoded, 2, tags)
+	}
+	encoded = appendVarintField(encoded, 3, geometryType)
+	return appendPackedField(encoded, 4, geometry)
+}
+
+// appendCoordinates appends the coordinates as zigzag encoded offsets, each from the one
+// before it, starting from the cursor.
+func appendCoordinates(geometry []uint32, cursor Coordinate, coordinates []Coordinate) []uint32 {
+	for _, coordinate := range coordinates {
+		geometry = append(geometry, zigzag(coordinate.X-cursor.X), zigzag(coordinate.Y-cursor.Y))
+		cursor = coordinate
+	}
+	return geometry
+}
+
+func command(id, count int) uint32 {
+	return uint32(id&0x7 | count<<3)
+}
+
+func zigzag(n int) uint32 {
+	return uint32(int32(n)<<1 ^ int32(n)>>31)
+}
+
+// normalizeValue converts a property value to one of the kinds a tile can hold, string,
+// int64 or float64, or nil if it cannot be held.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+func encodeValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return appendBytesField(nil, 1, []byte(v))
+	case float64:
+		encoded := appendKey(nil, 3, wireFixed64)
+		bits := math.Float64bits(v)
+		for i := 0; i < 8; i++ {
+			encoded = append(encoded, byte(bits>>(8*i)))
+		}
+		return encoded
+	case int64:
+		return appendVarintField(nil, 6, uint64(v<<1^v>>63))
+	}
+	return nil
+}
+
+// table assigns indices to the keys or values of a layer in the order they are first used.
+type table struct {
+	indices map[interface{}]uint32
+	entries []interface{}
+}
+
+func newTable() *table {
+	return &table{indices: make(map[interface{}]uint32)}
+}
+
+func (t *table) index(entry interface{}) uint32 {
+	if i, ok := t.indices[entry]; ok {
+		return i
+	}
+	t.indices[entry] = uint32(len(t.entries))
+	t.entries = append(t.entries, entry)
+	return t.indices[entry]
+}
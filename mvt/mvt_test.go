@@ -0,0 +1,80 @@
+package mvt
+
+import (
+	"bytes"
+	"testing"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+func TestEncode(t *testing.T) {
+	t.Log("Given the need to test encoding a tile with a single point.")
+	{
+		tile := Encode(Layer{Name: "members", Features: []Feature{{Points: []Coordinate{{25, 17}}}}})
+		// the point example of the specification, wrapped in a layer and a tile
+		feature := []byte{0x18, 0x01, 0x22, 0x03, 0x09, 0x32, 0x22}
+		if !bytes.Contains(tile, feature) {
+			t.Fatalf("\t\tShould encode the point as the specification does: %s %x", failMark, tile)
+		}
+		t.Log("\t\tShould encode the point as the specification does:", passMark)
+	}
+
+	t.Log("Given the need to test encoding a line.")
+	{
+		line := Feature{Line: []Coordinate{{2, 2}, {2, 10}, {10, 10}}}
+		encoded := encodeFeature(line, newTable(), newTable())
+		geometry := []byte{0x22, 0x08, 0x09, 0x04, 0x04, 0x12, 0x00, 0x10, 0x10, 0x00}
+		if !bytes.HasSuffix(encoded, geometry) {
+			t.Fatalf("\t\tShould encode the line as the specification does: %s %x", failMark, encoded)
+		}
+		t.Log("\t\tShould encode the line as the specification does:", passMark)
+	}
+
+	t.Log("Given the need to test that empty layers are left out.")
+	{
+		if tile := Encode(Layer{Name: "tracks"}); len(tile) != 0 {
+			t.Fatal("\t\tShould encode an empty tile:", failMark, tile)
+		}
+		t.Log("\t\tShould encode an empty tile:", passMark)
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	t.Log("Given the need to test clipping a line that crosses the edge of a tile.")
+	{
+		lines := ClipLine([]Coordinate{{10, 10}, {110, 10}}, 0, 100)
+		if len(lines) != 1 || len(lines[0]) != 2 || lines[0][1] != (Coordinate{100, 10}) {
+			t.Fatal("\t\tShould cut the line where it leaves the tile:", failMark, lines)
+		}
+		t.Log("\t\tShould cut the line where it leaves the tile:", passMark)
+	}
+
+	t.Log("Given the need to test clipping a line that passes through a tile without a point in it.")
+	{
+		lines := ClipLine([]Coordinate{{-50, 50}, {150, 50}}, 0, 100)
+		if len(lines) != 1 || lines[0][0] != (Coordinate{0, 50}) || lines[0][1] != (Coordinate{100, 50}) {
+			t.Fatal("\t\tShould keep the part of the line within the tile:", failMark, lines)
+		}
+		t.Log("\t\tShould keep the part of the line within the tile:", passMark)
+	}
+
+	t.Log("Given the need to test clipping a line that leaves a tile and comes back.")
+	{
+		lines := ClipLine([]Coordinate{{50, 50}, {150, 50}, {150, 80}, {50, 80}, {-50, 200}}, 0, 100)
+		if len(lines) != 2 || lines[0][1] != (Coordinate{100, 50}) || lines[1][0] != (Coordinate{100, 80}) {
+			t.Fatal("\t\tShould return a line for each pass through the tile:", failMark, lines)
+		}
+		t.Log("\t\tShould return a line for each pass through the tile:", passMark)
+	}
+
+	t.Log("Given the need to test clipping a line that misses a tile.")
+	{
+		if lines := ClipLine([]Coordinate{{150, 50}, {150, 150}}, 0, 100); len(lines) != 0 {
+			t.Fatal("\t\tShould leave out a line outside the tile:", failMark, lines)
+		}
+		t.Log("\t\tShould leave out a line outside the tile:", passMark)
+	}
+}
@@ -0,0 +1,72 @@
+// Package payments takes top ups of users' accounts through payment providers. A provider
+// opens a checkout the user pays at, then reports the outcome to a webhook with a signed
+// event, which is applied to the ledger once however often it is delivered.
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mcctor/marauders/db"
+)
+
+// Types of events providers report about checkouts.
+const (
+	EventPaid   = "checkout.paid"
+	EventFailed = "checkout.failed"
+)
+
+// ErrBadSignature is returned for webhook requests whose signature does not check out.
+var ErrBadSignature = errors.New("webhook signature does not match")
+
+// Event is what a provider reports about one of its checkouts.
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Checkout string `json:"checkout"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Provider is a payment service users pay their checkouts with.
+type Provider interface {
+	Name() string
+	// Checkout opens a checkout for the user to pay the amount, returning the id the
+	// provider gave it and the URL the user pays it at.
+	Checkout(username string, amount db.Money) (id, url string, err error)
+	// Event returns the event carried by a webhook request, after checking that the
+	// provider signed it.
+	Event(request *http.Request) (Event, error)
+}
+
+// Providers holds the providers payments can be taken with by name. It is empty unless
+// providers are registered at start up.
+var Providers = map[string]Provider{}
+
+// Register makes the provider available to take payments with.
+func Register(provider Provider) {
+	Providers[provider.Name()] = provider
+}
+
+// Open opens a checkout with the provider for the user to top up their account with the
+// amount.
+func Open(provider Provider, user *db.User, amount db.Money) (db.Checkout, error) {
+	id, url, err := provider.Checkout(user.Username, amount)
+	if err != nil {
+		return db.Checkout{}, fmt.Errorf("could not open checkout with provider<%s>: %v", provider.Name(), err)
+	}
+	return user.NewCheckout(provider.Name(), id, url, amount)
+}
+
+// Apply updates the checkout the event is about. Events of types it does not handle are
+// ignored.
+func Apply(provider Provider, event Event) (db.Checkout, error) {
+	switch event.Type {
+	case EventPaid:
+		return db.PayCheckout(provider.Name(), event.Checkout, db.Money{Amount: event.Amount, Currency: event.Currency})
+	case EventFailed:
+		return db.FailCheckout(provider.Name(), event.Checkout)
+	}
+	return db.GetCheckout(provider.Name(), event.Checkout)
+}
@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+func TestSignature(t *testing.T) {
+	secret, payload := []byte("secret"), []byte(`{"id": "evt_1"}`)
+	now := time.Now()
+
+	t.Log("Given the need to test the signatures of webhooks.")
+	{
+		if err := Verify(secret, Sign(secret, now, payload), payload, now); err != nil {
+			t.Fatal("\t\tShould accept a payload signed with the secret:", failMark, err)
+		}
+		t.Log("\t\tShould accept a payload signed with the secret:", passMark)
+
+		if Verify(secret, Sign(secret, now, payload), []byte(`{"id": "evt_2"}`), now) != ErrBadSignature ||
+			Verify([]byte("other"), Sign(secret, now, payload), payload, now) != ErrBadSignature {
+			t.Fatal("\t\tShould refuse a changed payload or another secret:", failMark)
+		}
+		t.Log("\t\tShould refuse a changed payload or another secret:", passMark)
+
+		old := Sign(secret, now.Add(-SignatureTolerance-time.Minute), payload)
+		if Verify(secret, old, payload, now) != ErrBadSignature || Verify(secret, "", payload, now) != ErrBadSignature {
+			t.Fatal("\t\tShould refuse a replayed or missing signature:", failMark)
+		}
+		t.Log("\t\tShould refuse a replayed or missing signature:", passMark)
+	}
+}
+
+func TestStubSettle(t *testing.T) {
+	var delivered []Event
+	stub := NewStub([]byte("secret"), "")
+	webhook := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		event, err := stub.Event(request)
+		if err != nil {
+			http.Error(writer, "", http.StatusBadRequest)
+			return
+		}
+		delivered = append(delivered, event)
+	}))
+	defer webhook.Close()
+	stub.BaseURL = webhook.URL
+
+	t.Log("Given the need to test the stub provider delivering the outcome of a checkout.")
+	{
+		id, _, err := stub.Checkout("john", db.Money{Amount: 500, Currency: "USD"})
+		if err != nil {
+			t.Fatal("\t\tShould open a checkout:", failMark, err)
+		}
+		checkout := db.Checkout{Provider: stub.Name(), ID: id, Amount: 500, Currency: "USD"}
+		for i := 0; i < 2; i++ {
+			if _, err := stub.Settle(checkout, true); err != nil {
+				t.Fatal("\t\tShould deliver a signed event:", failMark, err)
+			}
+		}
+		if len(delivered) != 2 || delivered[0] != delivered[1] || delivered[0].Type != EventPaid ||
+			delivered[0].Checkout != id || delivered[0].Amount != 500 {
+			t.Fatal("\t\tShould redeliver the same paid event:", failMark, delivered)
+		}
+		t.Log("\t\tShould redeliver the same paid event:", passMark)
+	}
+}
@@ -0,0 +1,53 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureTolerance is how old a signed webhook may be, so that captured requests cannot
+// be replayed later.
+const SignatureTolerance = 5 * time.Minute
+
+// Sign signs the payload of a webhook sent at the passed time, as the value of its
+// signature header: the time in Unix seconds and an HMAC-SHA256 of the time and payload.
+func Sign(secret []byte, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signatureOf(secret, timestamp, payload))
+}
+
+// Verify checks that the signature header was made by Sign with the secret for the payload,
+// no longer than SignatureTolerance before now.
+func Verify(secret []byte, header string, payload []byte, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		if value := strings.TrimPrefix(part, "t="); value != part {
+			timestamp = value
+		} else if value := strings.TrimPrefix(part, "v1="); value != part {
+			signature = value
+		}
+	}
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(signed, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(signatureOf(secret, timestamp, payload))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func signatureOf(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+)
+
+// StubSignatureHeader is the header the stub provider signs its webhooks in.
+const StubSignatureHeader = "Stub-Signature"
+
+// Stub is a payment provider that takes no real money, for trying out the whole flow of a
+// top up locally. Its checkouts are paid or declined on pages served by the server itself,
+// after which the stub delivers the outcome to the webhook like a real provider would.
+type Stub struct {
+	Secret []byte
+	// BaseURL is where the server serves the stub's checkout pages and webhook.
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewStub returns a stub provider signing its webhooks with the secret, for a server
+// listening at baseURL.
+func NewStub(secret []byte, baseURL string) *Stub {
+	return &Stub{Secret: secret, BaseURL: baseURL, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *Stub) Name() string { return "stub" }
+
+// CheckoutURL is the page the checkout with the passed id is paid at.
+func (s *Stub) CheckoutURL(id string) string {
+	return fmt.Sprintf("%s/v1/payments/stub/checkouts/%s/", s.BaseURL, id)
+}
+
+// WebhookURL is where the stub delivers its events.
+func (s *Stub) WebhookURL() string {
+	return fmt.Sprintf("%s/v1/payments/webhooks/%s/", s.BaseURL, s.Name())
+}
+
+func (s *Stub) Checkout(username string, amount db.Money) (id, url string, err error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	id = "stub_" + hex.EncodeToString(random)
+	return id, s.CheckoutURL(id), nil
+}
+
+func (s *Stub) Event(request *http.Request) (Event, error) {
+	payload, err := ioutil.ReadAll(io.LimitReader(request.Body, 1<<16))
+	if err != nil {
+		return Event{}, err
+	}
+	if err := Verify(s.Secret, request.Header.Get(StubSignatureHeader), payload, time.Now()); err != nil {
+		return Event{}, err
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, fmt.Errorf("bad stub event: %v", err)
+	}
+	return event, nil
+}
+
+// Settle pays or declines the checkout and delivers the event to the webhook. Each outcome
+// of a checkout keeps the same event id, so settling it again redelivers the same event
+// the way a real provider retries.
+func (s *Stub) Settle(checkout db.Checkout, paid bool) (Event, error) {
+	event := Event{
+		ID:       "evt_" + checkout.ID + "_failed",
+		Type:     EventFailed,
+		Checkout: checkout.ID,
+		Amount:   checkout.Amount,
+		Currency: checkout.Currency,
+	}
+	if paid {
+		event.ID, event.Type = "evt_"+checkout.ID+"_paid", EventPaid
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	request, err := http.NewRequest(http.MethodPost, s.WebhookURL(), bytes.NewReader(payload))
+	if err != nil {
+		return event, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(StubSignatureHeader, Sign(s.Secret, time.Now(), payload))
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return event, fmt.Errorf("could not deliver stub event<%s>: %v", event.ID, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return event, fmt.Errorf("stub event<%s> was refused with status %d", event.ID, response.StatusCode)
+	}
+	return event, nil
+}
@@ -0,0 +1,237 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	hubQueueSize           = 1024
+	subscriptionBufferSize = 64
+	// visibilityTTL is how long the hub trusts that a viewer can see a cloak before checking
+	// its members again, so that leaving a cloak cuts off an open stream soon after.
+	visibilityTTL = 30 * time.Second
+)
+
+// DefaultHub receives every location snapshot recorded through the db package.
+var DefaultHub = NewHub()
+
+// Update is a single location snapshot pushed to a subscriber, already filtered
+// and obscured by the rules of the cloak it is being shared through.
+type Update struct {
+	CloakID   string  `json:"cloak_id"`
+	DeviceID  int     `json:"device_id"`
+	User      string  `json:"user"`
+	TimeStamp string  `json:"time_stamp"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Place     string  `json:"place,omitempty"`
+}
+
+// Hub fans out newly saved location snapshots to the subscriptions of the cloaks
+// the reporting device is a member of.
+type Hub struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+	queue         chan db.LocationSnapshot
+	once          sync.Once
+
+	visibilityLock sync.Mutex
+	visibility     map[visibilityKey]visibilityCheck
+}
+
+// visibilityKey identifies a visibility check by the cloak, its rules at the time and the viewer,
+// so that a change to the rules is never answered from an older check.
+type visibilityKey struct {
+	cloakID                                         string
+	active, everyone, creatorVisible, memberVisible bool
+	viewer                                          string
+}
+
+type visibilityCheck struct {
+	visible bool
+	checked time.Time
+}
+
+// NewHub returns an empty hub. Its dispatch loop is started with the first subscription.
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[*Subscription]struct{}),
+		queue:         make(chan db.LocationSnapshot, hubQueueSize),
+		visibility:    make(map[visibilityKey]visibilityCheck),
+	}
+}
+
+// Subscribe registers a subscription for the viewer to the passed cloaks. The caller
+// is expected to have checked that the viewer is allowed to see each of the cloaks.
+func (h *Hub) Subscribe(viewer string, cloaks []*db.Cloak) *Subscription {
+	h.once.Do(func() { go h.dispatch() })
+
+	sub := newSubscription(viewer, cloaks)
+	h.lock.Lock()
+	h.subscriptions[sub] = struct{}{}
+	h.lock.Unlock()
+	return sub
+}
+
+// Unsubscribe removes the passed subscription from the hub and closes it.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.lock.Lock()
+	delete(h.subscriptions, sub)
+	h.lock.Unlock()
+	sub.close()
+}
+
+// Publish queues the passed snapshot to be pushed to the interested subscriptions.
+// It never blocks the caller; snapshots are dropped if the hub falls behind.
+func (h *Hub) Publish(snapshot db.LocationSnapshot) {
+	if h.subscriptionCount() == 0 {
+		return
+	}
+	select {
+	case h.queue <- snapshot:
+	default:
+		log.Printf("stream hub queue is full, dropped snapshot for device<%d>", snapshot.DeviceID)
+	}
+}
+
+func (h *Hub) subscriptionCount() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subscriptions)
+}
+
+// dispatch pushes every queued snapshot through the cloaks of its device to the
+// subscriptions that are watching those cloaks.
+func (h *Hub) dispatch() {
+	for snapshot := range h.queue {
+		h.deliver(snapshot)
+	}
+}
+
+func (h *Hub) deliver(snapshot db.LocationSnapshot) {
+	device, err := db.GetDeviceByID(snapshot.DeviceID)
+	if err != nil {
+		log.Printf("could not stream snapshot: %v", err)
+		return
+	}
+	cloaks, err := device.AssociatedCloaks()
+	if err != nil {
+		log.Printf("could not stream snapshot: %v", err)
+		return
+	}
+
+	for _, cloak := range cloaks {
+		if !cloak.Permits(snapshot) {
+			continue
+		}
+		obscured := cloak.Obscure(snapshot)
+		update := Update{
+			CloakID:   cloak.ID,
+			DeviceID:  obscured.DeviceID,
+			User:      device.User,
+			TimeStamp: obscured.TimeStamp,
+			Latitude:  obscured.Latitude,
+			Longitude: obscured.Longitude,
+			Place:     obscured.PlaceLabel(),
+		}
+		for _, sub := range h.watchers(cloak.ID) {
+			if h.canView(cloak, sub.Viewer) {
+				sub.push(update)
+			}
+		}
+	}
+}
+
+// watchers returns the subscriptions that are watching the passed cloak.
+func (h *Hub) watchers(cloakID string) (subs []*Subscription) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for sub := range h.subscriptions {
+		if sub.watches(cloakID) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// canView checks whether the viewer can see the cloak. The cloak's rules are those of its
+// current row, so changes to them take effect on open streams right away, while whether
+// the viewer is still a member is only looked up again once visibilityTTL has passed.
+func (h *Hub) canView(cloak *db.Cloak, viewer string) bool {
+	key := visibilityKey{
+		cloakID:        cloak.ID,
+		active:         cloak.Active,
+		everyone:       cloak.EveryoneVisible,
+		creatorVisible: cloak.CreatorVisible,
+		memberVisible:  cloak.MemberVisible,
+		viewer:         viewer,
+	}
+	h.visibilityLock.Lock()
+	check, ok := h.visibility[key]
+	h.visibilityLock.Unlock()
+	if ok && time.Since(check.checked) < visibilityTTL {
+		return check.visible
+	}
+
+	visible, err := cloak.CanBeViewedBy(viewer)
+	if err != nil {
+		log.Printf("could not stream snapshot: %v", err)
+		return false
+	}
+	h.visibilityLock.Lock()
+	defer h.visibilityLock.Unlock()
+	for stale, check := range h.visibility {
+		if time.Since(check.checked) >= visibilityTTL {
+			delete(h.visibility, stale)
+		}
+	}
+	h.visibility[key] = visibilityCheck{visible: visible, checked: time.Now()}
+	return visible
+}
+
+func init() {
+	db.Subscribe(db.TopicLocationSnapshotRecorded, func(event db.Event) {
+		// late snapshots belong to history, which subscribers catch up on through a replay
+		if recorded := event.(db.LocationSnapshotRecorded); !recorded.Late {
+			DefaultHub.Publish(recorded.Snapshot)
+		}
+	})
+}
+
+// Replay returns the updates shared through the passed cloaks after the since
+// timestamp, oldest first, so that a reconnecting subscriber can catch up.
+func Replay(cloaks []*db.Cloak, since string, lim int) (updates []Update, err error) {
+	for _, cloak := range cloaks {
+		members, err := cloak.Members()
+		if err != nil {
+			return nil, fmt.Errorf("could not replay updates for cloak<%s>: %v", cloak.ID, err)
+		}
+		for _, member := range members {
+			snapshots, err := cloak.LocationSnapshotsForMemberSince(member, since, lim)
+			if err != nil {
+				return nil, fmt.Errorf("could not replay updates for cloak<%s>: %v", cloak.ID, err)
+			}
+			for _, snapshot := range snapshots {
+				updates = append(updates, Update{
+					CloakID:   cloak.ID,
+					DeviceID:  snapshot.DeviceID,
+					User:      member.User,
+					TimeStamp: snapshot.TimeStamp,
+					Latitude:  snapshot.Latitude,
+					Longitude: snapshot.Longitude,
+					Place:     snapshot.PlaceLabel(),
+				})
+			}
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].TimeStamp < updates[j].TimeStamp
+	})
+	return updates, nil
+}
@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/mcctor/marauders/db"
+)
+
+// Subscription is a viewer's live feed of the location updates shared through
+// one or more cloaks.
+type Subscription struct {
+	Viewer  string
+	Updates chan Update
+
+	cloakIDs map[string]struct{}
+	lock     sync.Mutex
+	closed   bool
+	lagged   bool
+}
+
+func newSubscription(viewer string, cloaks []*db.Cloak) *Subscription {
+	sub := &Subscription{
+		Viewer:   viewer,
+		Updates:  make(chan Update, subscriptionBufferSize),
+		cloakIDs: make(map[string]struct{}),
+	}
+	for _, cloak := range cloaks {
+		sub.cloakIDs[cloak.ID] = struct{}{}
+	}
+	return sub
+}
+
+// Lagged reports whether the subscription was closed because its consumer could
+// not keep up with the updates pushed to it.
+func (sub *Subscription) Lagged() bool {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	return sub.lagged
+}
+
+func (sub *Subscription) watches(cloakID string) bool {
+	_, ok := sub.cloakIDs[cloakID]
+	return ok
+}
+
+// push hands the update to the consumer without blocking. A consumer whose buffer
+// is full is cut off, and is expected to reconnect and resume from its last update.
+func (sub *Subscription) push(update Update) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.Updates <- update:
+	default:
+		sub.lagged = true
+		sub.closed = true
+		close(sub.Updates)
+	}
+}
+
+func (sub *Subscription) close() {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.Updates)
+	}
+}
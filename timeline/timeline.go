@@ -0,0 +1,205 @@
+// Package timeline segments the location history of a device into the places it
+// stayed at and the trips it made between them.
+package timeline
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+	"github.com/mcctor/marauders/geocode"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	KindStay = "stay"
+	KindTrip = "trip"
+
+	ModeStationary = "stationary"
+	ModeWalking    = "walking"
+	ModeCycling    = "cycling"
+	ModeDriving    = "driving"
+
+	// stayRadius is how far in metres a device may wander from where it arrived and
+	// still be considered to be staying at the same place.
+	stayRadius = 200.0
+	// stayMinDuration is how long a device has to remain within the stay radius for
+	// the place to count as a stay rather than part of a trip.
+	stayMinDuration = 5 * time.Minute
+
+	// upper average speeds in metres per second of each mode of travel
+	walkingMaxSpeed = 2.5
+	cyclingMaxSpeed = 7.0
+)
+
+// Segment is a stretch of a device's history during which it either stayed at a
+// single place or travelled from one place to another.
+type Segment struct {
+	Kind  string
+	Start time.Time
+	End   time.Time
+	// From is where a trip started, or the place of a stay
+	From geo.Point
+	// To is where a trip ended, or the place of a stay
+	To       geo.Point
+	Distance float64
+	Mode     string
+	Points   int
+	// Place names where a stay was, if it can be told
+	Place string
+}
+
+// Duration returns how long the segment lasted.
+func (s Segment) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+type fix struct {
+	point geo.Point
+	time  time.Time
+	// place is the name a cloak gave the position, if any
+	place string
+}
+
+// Build segments the passed snapshots into stays and trips, in chronological order.
+func Build(snapshots []db.LocationSnapshot) ([]Segment, error) {
+	fixes := make([]fix, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build timeline: %v", err)
+		}
+		fixes = append(fixes, fix{
+			point: geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude},
+			time:  recorded,
+			place: snapshot.Place,
+		})
+	}
+	sort.Slice(fixes, func(i, j int) bool { return fixes[i].time.Before(fixes[j].time) })
+	fixes = dropOutliers(fixes)
+
+	var segments []Segment
+	// a trip runs from the last fix of the previous stay to the first fix of the next one
+	tripStart, lastStayEnd := -1, -1
+	for i := 0; i < len(fixes); {
+		j := i + 1
+		for j < len(fixes) && geo.Distance(fixes[i].point, fixes[j].point) <= stayRadius {
+			j++
+		}
+		if fixes[j-1].time.Sub(fixes[i].time) < stayMinDuration {
+			if tripStart < 0 {
+				tripStart = i
+				if lastStayEnd >= 0 {
+					tripStart = lastStayEnd
+				}
+			}
+			i++
+			continue
+		}
+
+		if tripStart < 0 && lastStayEnd >= 0 {
+			tripStart = lastStayEnd
+		}
+		if tripStart >= 0 {
+			segments = append(segments, newTrip(fixes[tripStart:i+1]))
+		}
+		segments = append(segments, newStay(fixes[i:j]))
+		tripStart, lastStayEnd = -1, j-1
+		i = j
+	}
+	if tripStart >= 0 {
+		segments = append(segments, newTrip(fixes[tripStart:]))
+	}
+	return segments, nil
+}
+
+// dropOutliers leaves out the fixes that jump away from both of their neighbours while the
+// neighbours themselves are close together, so that a single bad fix does not split a stay.
+func dropOutliers(fixes []fix) []fix {
+	kept := make([]fix, 0, len(fixes))
+	for i, f := range fixes {
+		if i > 0 && i < len(fixes)-1 {
+			before, after := fixes[i-1].point, fixes[i+1].point
+			if geo.Distance(before, after) <= stayRadius &&
+				geo.Distance(before, f.point) > stayRadius && geo.Distance(f.point, after) > stayRadius {
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+func newStay(fixes []fix) Segment {
+	var latitude, longitude float64
+	for _, f := range fixes {
+		latitude += f.point.Latitude
+		longitude += f.point.Longitude
+	}
+	place := geo.Point{
+		Latitude:  latitude / float64(len(fixes)),
+		Longitude: longitude / float64(len(fixes)),
+	}
+	return Segment{
+		Kind:   KindStay,
+		Start:  fixes[0].time,
+		End:    fixes[len(fixes)-1].time,
+		From:   place,
+		To:     place,
+		Mode:   ModeStationary,
+		Points: len(fixes),
+		Place:  placeOf(place, fixes),
+	}
+}
+
+// placeOf names the place of a stay. Fixes seen through a cloak carry the names the cloak
+// allows, so the one most of them carry is used, rather than naming the place afresh.
+func placeOf(place geo.Point, fixes []fix) string {
+	counts := make(map[string]int)
+	named := ""
+	for _, f := range fixes {
+		if f.place == "" {
+			continue
+		}
+		if counts[f.place]++; counts[f.place] > counts[named] {
+			named = f.place
+		}
+	}
+	if named != "" {
+		return named
+	}
+	return geocode.Default.Lookup(place).Label(geocode.LevelCity)
+}
+
+func newTrip(fixes []fix) Segment {
+	trip := Segment{
+		Kind:   KindTrip,
+		Start:  fixes[0].time,
+		End:    fixes[len(fixes)-1].time,
+		From:   fixes[0].point,
+		To:     fixes[len(fixes)-1].point,
+		Points: len(fixes),
+	}
+	for i := 1; i < len(fixes); i++ {
+		trip.Distance += geo.Distance(fixes[i-1].point, fixes[i].point)
+	}
+	trip.Mode = inferMode(trip.Distance, trip.Duration())
+	return trip
+}
+
+// inferMode guesses how a trip was made from its average speed.
+func inferMode(distance float64, duration time.Duration) string {
+	if duration <= 0 {
+		return ModeStationary
+	}
+	speed := distance / duration.Seconds()
+	switch {
+	case speed <= walkingMaxSpeed:
+		return ModeWalking
+	case speed <= cyclingMaxSpeed:
+		return ModeCycling
+	}
+	return ModeDriving
+}
@@ -0,0 +1,123 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+// fixAt returns a snapshot taken the passed number of minutes into the day, a number of
+// degrees of latitude north of a point on the equator. A thousandth of a degree is about
+// 111 metres.
+func fixAt(minutes int, north float64) db.LocationSnapshot {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	return db.LocationSnapshot{
+		TimeStamp: start.Add(time.Duration(minutes) * time.Minute).Format(utils.TimeFormat),
+		Latitude:  north,
+		Longitude: 36.8219,
+		Place:     "Somewhere",
+	}
+}
+
+func TestBuild(t *testing.T) {
+	cases := []struct {
+		given     string
+		snapshots []db.LocationSnapshot
+		kinds     []string
+		modes     []string
+	}{
+		{
+			given:     "a device that stays put",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(5, 0.0001), fixAt(10, 0)},
+			kinds:     []string{KindStay},
+			modes:     []string{ModeStationary},
+		},
+		{
+			given:     "a device that moves on before it has stayed long enough",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(2, 0.009), fixAt(4, 0.018)},
+			kinds:     []string{KindTrip},
+			modes:     []string{ModeDriving},
+		},
+		{
+			given: "a walk of a kilometre in ten minutes between two stays",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(5, 0), fixAt(10, 0),
+				fixAt(20, 0.009), fixAt(25, 0.009), fixAt(30, 0.009)},
+			kinds: []string{KindStay, KindTrip, KindStay},
+			modes: []string{ModeStationary, ModeWalking, ModeStationary},
+		},
+		{
+			given: "a ride of five kilometres in fifteen minutes between two stays",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(10, 0),
+				fixAt(25, 0.045), fixAt(35, 0.045)},
+			kinds: []string{KindStay, KindTrip, KindStay},
+			modes: []string{ModeStationary, ModeCycling, ModeStationary},
+		},
+		{
+			given: "a drive of twenty kilometres in fifteen minutes between two stays",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(10, 0),
+				fixAt(25, 0.18), fixAt(35, 0.18)},
+			kinds: []string{KindStay, KindTrip, KindStay},
+			modes: []string{ModeStationary, ModeDriving, ModeStationary},
+		},
+		{
+			given: "a stay with a single fix a kilometre off",
+			snapshots: []db.LocationSnapshot{fixAt(0, 0), fixAt(2, 0), fixAt(4, 0.009),
+				fixAt(6, 0), fixAt(8, 0), fixAt(10, 0)},
+			kinds: []string{KindStay},
+			modes: []string{ModeStationary},
+		},
+	}
+	for _, c := range cases {
+		t.Log("Given the need to test the timeline of " + c.given + ".")
+		{
+			segments, err := Build(c.snapshots)
+			if err != nil || len(segments) != len(c.kinds) {
+				t.Fatal("\t\tShould segment it into", c.kinds, failMark, err, segments)
+			}
+			for i, segment := range segments {
+				if segment.Kind != c.kinds[i] || segment.Mode != c.modes[i] {
+					t.Fatal("\t\tShould segment it into", c.kinds, "made", c.modes, failMark, segments)
+				}
+			}
+			t.Log("\t\tShould segment it into", c.kinds, "made", c.modes, passMark)
+		}
+	}
+
+	t.Log("Given the need to test that a stay leaves out a fix that is off.")
+	{
+		segments, _ := Build(cases[len(cases)-1].snapshots)
+		if len(segments) != 1 || segments[0].Points != 5 || segments[0].From.Latitude != 0 {
+			t.Fatal("\t\tShould place the stay where the other fixes are:", failMark, segments)
+		}
+		t.Log("\t\tShould place the stay where the other fixes are:", passMark)
+	}
+}
+
+func TestInferMode(t *testing.T) {
+	cases := []struct {
+		distance float64
+		duration time.Duration
+		mode     string
+	}{
+		{1000, 0, ModeStationary},
+		{1000, 10 * time.Minute, ModeWalking},
+		{3000, 10 * time.Minute, ModeCycling},
+		{10000, 10 * time.Minute, ModeDriving},
+	}
+	t.Log("Given the need to test guessing how a trip was made from its average speed.")
+	{
+		for _, c := range cases {
+			if mode := inferMode(c.distance, c.duration); mode != c.mode {
+				t.Fatal("\t\tShould guess", c.mode, "for", c.distance, "metres in", c.duration, failMark, mode)
+			}
+			t.Log("\t\tShould guess", c.mode, "for", c.distance, "metres in", c.duration, passMark)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// GT06 message numbers
+const (
+	gt06Login       = 0x01
+	gt06GPS         = 0x10
+	gt06GPSLBS      = 0x12
+	gt06Heartbeat   = 0x13
+	gt06Alarm       = 0x16
+	gt06GPSLBS2     = 0x22
+	gt06MinLocation = 18
+)
+
+// GT06 decodes the binary protocol of Concox GT06 trackers and their many clones. A packet
+// starts with 0x7878 and a one byte length, or 0x7979 and a two byte length, followed by
+// the message number, content, serial number, a CRC-ITU checksum and 0x0D0A.
+type GT06 struct{}
+
+func (GT06) Name() string { return "gt06" }
+
+func (GT06) Decode(r *bufio.Reader) (Message, error) {
+	start := make([]byte, 2)
+	if _, err := io.ReadFull(r, start); err != nil {
+		return Message{}, err
+	}
+	var header []byte
+	switch {
+	case start[0] == 0x78 && start[1] == 0x78:
+		header = make([]byte, 1)
+	case start[0] == 0x79 && start[1] == 0x79:
+		header = make([]byte, 2)
+	default:
+		return Message{}, fmt.Errorf("%w: gt06 start bytes %x", ErrBadPacket, start)
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Message{}, err
+	}
+	length := int(header[0])
+	if len(header) == 2 {
+		length = int(binary.BigEndian.Uint16(header))
+	}
+	// the length counts the message number, content, serial number and checksum
+	if length < 5 {
+		return Message{}, fmt.Errorf("%w: gt06 length %d", ErrBadPacket, length)
+	}
+	body := make([]byte, length+2)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Message{}, err
+	}
+	if body[length] != 0x0D || body[length+1] != 0x0A {
+		return Message{}, fmt.Errorf("%w: gt06 stop bytes %x", ErrBadPacket, body[length:])
+	}
+	checked := append(header, body[:length-2]...)
+	if crcITU(checked) != binary.BigEndian.Uint16(body[length-2:length]) {
+		return Message{}, fmt.Errorf("%w: gt06 checksum", ErrBadPacket)
+	}
+
+	number, content, serial := body[0], body[1:length-4], body[length-4:length-2]
+	switch number {
+	case gt06Login:
+		if len(content) < 8 {
+			return Message{}, fmt.Errorf("%w: gt06 login too short", ErrBadPacket)
+		}
+		// the IMEI is packed as 16 BCD digits with a leading zero
+		imei := hex.EncodeToString(content[:8])
+		return Message{Kind: KindLogin, IMEI: imei[len(imei)-15:], Ack: gt06Ack(number, serial)}, nil
+	case gt06Heartbeat:
+		return Message{Kind: KindHeartbeat, Ack: gt06Ack(number, serial)}, nil
+	case gt06GPS, gt06GPSLBS, gt06GPSLBS2, gt06Alarm:
+		if len(content) < gt06MinLocation {
+			return Message{}, fmt.Errorf("%w: gt06 location too short", ErrBadPacket)
+		}
+		message := Message{Kind: KindLocation, Fix: gt06Fix(content)}
+		if number == gt06Alarm {
+			message.Ack = gt06Ack(number, serial)
+		}
+		return message, nil
+	}
+	return Message{Kind: KindOther}, nil
+}
+
+// gt06Fix reads the GPS block that location and alarm messages start with.
+func gt06Fix(content []byte) Fix {
+	recorded := time.Date(2000+int(content[0]), time.Month(content[1]), int(content[2]),
+		int(content[3]), int(content[4]), int(content[5]), 0, time.UTC)
+	// coordinates are in units of 1/30000 of a minute
+	latitude := float64(binary.BigEndian.Uint32(content[7:11])) / 1800000
+	longitude := float64(binary.BigEndian.Uint32(content[11:15])) / 1800000
+	flags := binary.BigEndian.Uint16(content[16:18])
+	if flags&(1<<10) == 0 {
+		latitude = -latitude
+	}
+	if flags&(1<<11) != 0 {
+		longitude = -longitude
+	}
+	return Fix{
+		Time:      recorded,
+		Latitude:  latitude,
+		Longitude: longitude,
+		Speed:     float64(content[15]),
+		Course:    float64(flags & 0x3FF),
+		Valid:     flags&(1<<12) != 0,
+	}
+}
+
+// gt06Ack returns the response acknowledging a message, which echoes its number and serial.
+func gt06Ack(number byte, serial []byte) []byte {
+	ack := []byte{0x78, 0x78, 0x05, number, serial[0], serial[1]}
+	crc := crcITU(ack[2:])
+	return append(ack, byte(crc>>8), byte(crc), 0x0D, 0x0A)
+}
+
+// crcITU computes the CRC-16/X-25 checksum GT06 packets carry.
+func crcITU(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
@@ -0,0 +1,61 @@
+// Package protocol decodes the packets hardware GPS trackers send over TCP. Each protocol
+// is a Decoder that reads one message at a time off a connection, and hands back the
+// bytes the tracker expects as an acknowledgement.
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"time"
+)
+
+// Kinds of messages a tracker sends.
+const (
+	// KindLogin is the first message of a connection, identifying the tracker
+	KindLogin = iota + 1
+	// KindHeartbeat keeps the connection alive without reporting a position
+	KindHeartbeat
+	KindLocation
+	// KindOther is any message the decoder understands the framing of but not the content
+	KindOther
+)
+
+// ErrBadPacket is returned when the framing of a packet is broken, after which nothing
+// more can be read from the connection.
+var ErrBadPacket = errors.New("bad packet")
+
+// Fix is a position reported by a tracker.
+type Fix struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	// Speed over ground in kilometres per hour
+	Speed float64
+	// Course in degrees clockwise from true north
+	Course float64
+	// Valid is false when the tracker had no GPS fix and reports its last known position
+	Valid bool
+}
+
+// Message is a decoded packet.
+type Message struct {
+	Kind int
+	// IMEI identifies the tracker. It is only set on login messages.
+	IMEI string
+	// Fix is only set on location messages.
+	Fix Fix
+	// Ack is written back to the tracker once the message is handled, if not empty.
+	Ack []byte
+}
+
+// Decoder reads messages of a tracker protocol.
+type Decoder interface {
+	Name() string
+	Decode(r *bufio.Reader) (Message, error)
+}
+
+// Decoders holds the decoders of every supported protocol by name.
+var Decoders = map[string]Decoder{
+	GT06{}.Name():  GT06{},
+	TK103{}.Name(): TK103{},
+}
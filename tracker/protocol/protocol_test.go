@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+func TestCRCITU(t *testing.T) {
+	t.Log("Given the need to test the checksum of GT06 packets.")
+	{
+		if sum := crcITU([]byte("123456789")); sum != 0x906E {
+			t.Fatalf("\t\tShould match the CRC-16/X-25 check value: %s %x", failMark, sum)
+		}
+		t.Log("\t\tShould match the CRC-16/X-25 check value:", passMark)
+	}
+}
+
+func TestGT06(t *testing.T) {
+	t.Log("Given the need to test decoding a GT06 login.")
+	{
+		login := []byte{0x78, 0x78, 0x0D, 0x01, 0x01, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23, 0x45, 0x00, 0x01}
+		sum := crcITU(login[2:])
+		login = append(login, byte(sum>>8), byte(sum), 0x0D, 0x0A)
+		message, err := GT06{}.Decode(bufio.NewReader(bytes.NewReader(login)))
+		if err != nil || message.Kind != KindLogin || message.IMEI != "123456789012345" {
+			t.Fatal("\t\tShould read the IMEI of the tracker:", failMark, err, message)
+		}
+		t.Log("\t\tShould read the IMEI of the tracker:", passMark)
+		if !bytes.Equal(message.Ack[:6], []byte{0x78, 0x78, 0x05, 0x01, 0x00, 0x01}) {
+			t.Fatalf("\t\tShould acknowledge with the serial of the login: %s %x", failMark, message.Ack)
+		}
+		t.Log("\t\tShould acknowledge with the serial of the login:", passMark)
+	}
+
+	t.Log("Given the need to test decoding a GT06 location.")
+	{
+		// the GPS and LBS location example of the protocol's documentation
+		location := []byte{0x78, 0x78, 0x1F, 0x12, 0x0B, 0x08, 0x1D, 0x11, 0x2E, 0x10, 0xCF, 0x02, 0x7A, 0xC7,
+			0xEB, 0x0C, 0x46, 0x58, 0x49, 0x00, 0x14, 0x8F, 0x01, 0xCC, 0x00, 0x28, 0x7D, 0x00, 0x1F, 0xB8,
+			0x00, 0x03, 0x80, 0x81, 0x0D, 0x0A}
+		message, err := GT06{}.Decode(bufio.NewReader(bytes.NewReader(location)))
+		if err != nil || message.Kind != KindLocation || !message.Fix.Valid || message.Ack != nil {
+			t.Fatal("\t\tShould read a valid fix without acknowledging it:", failMark, err, message)
+		}
+		fix := message.Fix
+		if math.Abs(fix.Latitude-23.11167) > 1e-5 || math.Abs(fix.Longitude-114.40929) > 1e-5 {
+			t.Fatal("\t\tShould read the position in decimal degrees:", failMark, fix)
+		}
+		t.Log("\t\tShould read the position in decimal degrees:", passMark)
+		if fix.Time.Format("2006-01-02 15:04:05") != "2011-08-29 17:46:16" || fix.Speed != 0 || fix.Course != 143 {
+			t.Fatal("\t\tShould read the time, speed and course of the fix:", failMark, fix)
+		}
+		t.Log("\t\tShould read the time, speed and course of the fix:", passMark)
+	}
+
+	t.Log("Given the need to test that corrupted packets are refused.")
+	{
+		corrupted := []byte{0x78, 0x78, 0x05, 0x13, 0x00, 0x01, 0x00, 0x00, 0x0D, 0x0A}
+		if _, err := (GT06{}).Decode(bufio.NewReader(bytes.NewReader(corrupted))); err == nil {
+			t.Fatal("\t\tShould refuse a packet with a bad checksum:", failMark)
+		}
+		t.Log("\t\tShould refuse a packet with a bad checksum:", passMark)
+	}
+}
+
+func TestTK103(t *testing.T) {
+	t.Log("Given the need to test decoding a TK103 location.")
+	{
+		packet := "(027028258309BR00080612A2232.9828N11404.9297W000.0022828000.0000000000L000230AA)"
+		message, err := TK103{}.Decode(bufio.NewReader(strings.NewReader(packet)))
+		if err != nil || message.Kind != KindLocation || !message.Fix.Valid {
+			t.Fatal("\t\tShould read a valid fix:", failMark, err, message)
+		}
+		fix := message.Fix
+		if math.Abs(fix.Latitude-22.54971) > 1e-5 || math.Abs(fix.Longitude+114.08216) > 1e-5 {
+			t.Fatal("\t\tShould read the position in decimal degrees:", failMark, fix)
+		}
+		t.Log("\t\tShould read the position in decimal degrees:", passMark)
+		if fix.Time.Format("2006-01-02 15:04:05") != "2008-06-12 02:28:28" {
+			t.Fatal("\t\tShould read the time of the fix:", failMark, fix.Time)
+		}
+		t.Log("\t\tShould read the time of the fix:", passMark)
+	}
+
+	t.Log("Given the need to test decoding a TK103 login.")
+	{
+		packet := "(012345678901BP05123456789012345HSO)"
+		message, err := TK103{}.Decode(bufio.NewReader(strings.NewReader(packet)))
+		if err != nil || message.IMEI != "123456789012345" || string(message.Ack) != "(012345678901AP05)" {
+			t.Fatal("\t\tShould read the IMEI and acknowledge:", failMark, err, message)
+		}
+		t.Log("\t\tShould read the IMEI and acknowledge:", passMark)
+	}
+
+	t.Log("Given the need to test that a TK103 packet cannot run on without end.")
+	{
+		endless := "(012345678901BP05" + strings.Repeat("0", 2*tk103MaxPacket)
+		_, err := TK103{}.Decode(bufio.NewReader(strings.NewReader(endless)))
+		if !errors.Is(err, ErrBadPacket) {
+			t.Fatal("\t\tShould refuse a packet longer than the limit:", failMark, err)
+		}
+		t.Log("\t\tShould refuse a packet longer than the limit:", passMark)
+	}
+}
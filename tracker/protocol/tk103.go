@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tk103MinLocation = 45
+	// tk103MaxPacket is the longest a packet may run before its closing parenthesis, so that
+	// a peer that never sends one cannot have the server buffer without end.
+	tk103MaxPacket = 512
+)
+
+var imeiPattern = regexp.MustCompile(`\d{15}`)
+
+// TK103 decodes the text protocol of TK103 trackers. A message is enclosed in parentheses,
+// and made up of a twelve digit terminal id, a four character command and its data.
+type TK103 struct{}
+
+func (TK103) Name() string { return "tk103" }
+
+func (TK103) Decode(r *bufio.Reader) (Message, error) {
+	packet, err := readTK103Packet(r)
+	if err != nil {
+		return Message{}, err
+	}
+	start := strings.LastIndexByte(packet, '(')
+	if start < 0 || len(packet)-start < 18 {
+		return Message{}, fmt.Errorf("%w: tk103 packet %q", ErrBadPacket, packet)
+	}
+	content := packet[start+1 : len(packet)-1]
+	id, command, data := content[:12], content[12:16], content[16:]
+
+	switch command {
+	case "BP05":
+		imei := imeiPattern.FindString(data)
+		if imei == "" {
+			return Message{}, fmt.Errorf("%w: tk103 login without imei", ErrBadPacket)
+		}
+		return Message{Kind: KindLogin, IMEI: imei, Ack: []byte("(" + id + "AP05)")}, nil
+	case "BP00":
+		// the handshake carries the IMEI too, so it doubles as a login
+		message := Message{Kind: KindHeartbeat, Ack: []byte("(" + id + "AP01HSO)")}
+		if imei := imeiPattern.FindString(data); imei != "" {
+			message.Kind, message.IMEI = KindLogin, imei
+		}
+		return message, nil
+	case "BR00", "BR01", "BR02":
+		fix, err := tk103Fix(data)
+		if err != nil {
+			return Message{}, err
+		}
+		return Message{Kind: KindLocation, Fix: fix}, nil
+	case "BO01":
+		// alarms lead with the alarm code, which is echoed in the acknowledgement
+		if len(data) < 1 {
+			return Message{}, fmt.Errorf("%w: tk103 alarm without code", ErrBadPacket)
+		}
+		fix, err := tk103Fix(data[1:])
+		if err != nil {
+			return Message{}, err
+		}
+		return Message{Kind: KindLocation, Fix: fix, Ack: []byte("(" + id + "AS01" + data[:1] + ")")}, nil
+	}
+	return Message{Kind: KindOther}, nil
+}
+
+// readTK103Packet reads up to and including the closing parenthesis of the next packet.
+func readTK103Packet(r *bufio.Reader) (string, error) {
+	var packet []byte
+	for len(packet) < tk103MaxPacket {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		packet = append(packet, b)
+		if b == ')' {
+			return string(packet), nil
+		}
+	}
+	return "", fmt.Errorf("%w: tk103 packet longer than %d bytes", ErrBadPacket, tk103MaxPacket)
+}
+
+// tk103Fix reads a position laid out as YYMMDD, A or V for validity, ddmm.mmmm and N or S,
+// dddmm.mmmm and E or W, speed in km/h as 000.0, HHMMSS and course as 000.00.
+func tk103Fix(data string) (Fix, error) {
+	if len(data) < tk103MinLocation {
+		return Fix{}, fmt.Errorf("%w: tk103 location too short", ErrBadPacket)
+	}
+	recorded, err := time.Parse("060102150405", data[0:6]+data[33:39])
+	if err != nil {
+		return Fix{}, fmt.Errorf("%w: tk103 time: %v", ErrBadPacket, err)
+	}
+	latitude, err := nmeaDegrees(data[7:16], 2)
+	if err != nil {
+		return Fix{}, err
+	}
+	longitude, err := nmeaDegrees(data[17:27], 3)
+	if err != nil {
+		return Fix{}, err
+	}
+	if data[16] == 'S' {
+		latitude = -latitude
+	}
+	if data[27] == 'W' {
+		longitude = -longitude
+	}
+	speed, err := strconv.ParseFloat(data[28:33], 64)
+	if err != nil {
+		return Fix{}, fmt.Errorf("%w: tk103 speed: %v", ErrBadPacket, err)
+	}
+	course, err := strconv.ParseFloat(data[39:45], 64)
+	if err != nil {
+		return Fix{}, fmt.Errorf("%w: tk103 course: %v", ErrBadPacket, err)
+	}
+	return Fix{
+		Time:      recorded,
+		Latitude:  latitude,
+		Longitude: longitude,
+		Speed:     speed,
+		Course:    course,
+		Valid:     data[6] == 'A',
+	}, nil
+}
+
+// nmeaDegrees converts a coordinate written as degrees followed by decimal minutes, with
+// the passed number of digits for the degrees, into decimal degrees.
+func nmeaDegrees(value string, degreeDigits int) (float64, error) {
+	degrees, err := strconv.ParseFloat(value[:degreeDigits], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: tk103 coordinate %q", ErrBadPacket, value)
+	}
+	minutes, err := strconv.ParseFloat(value[degreeDigits:], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: tk103 coordinate %q", ErrBadPacket, value)
+	}
+	return degrees + minutes/60, nil
+}
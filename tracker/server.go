@@ -0,0 +1,115 @@
+// Package tracker runs the TCP listeners hardware GPS trackers connect to, and feeds the
+// positions they report into the location history of the devices they are registered to.
+package tracker
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/tracker/protocol"
+	"github.com/mcctor/marauders/utils"
+)
+
+// DefaultIdleTimeout is how long a connection may stay silent before it is dropped.
+// Trackers send a heartbeat every few minutes at most.
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Server accepts connections from trackers speaking a single protocol.
+type Server struct {
+	Addr        string
+	Decoder     protocol.Decoder
+	IdleTimeout time.Duration
+}
+
+// ListenAndServe listens on the server's address and serves every tracker connecting to
+// it on its own goroutine.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve reads messages off a tracker's connection until it closes, goes idle or breaks
+// the framing of its protocol. Positions are only recorded once the tracker has logged in
+// with an IMEI registered to a device.
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	idleTimeout := s.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	reader := bufio.NewReader(conn)
+	var device *db.Device
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		message, err := s.Decoder.Decode(reader)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("%s tracker %s: %v", s.Decoder.Name(), conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		switch message.Kind {
+		case protocol.KindLogin:
+			found, err := db.GetDeviceByIdentifier(db.ProtocolIMEI, message.IMEI)
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Printf("%s tracker %s: unregistered imei <%s>", s.Decoder.Name(), conn.RemoteAddr(), message.IMEI)
+				return
+			} else if err != nil {
+				log.Print(err)
+				return
+			}
+			device = &found
+		case protocol.KindLocation:
+			if device == nil {
+				log.Printf("%s tracker %s: location before login", s.Decoder.Name(), conn.RemoteAddr())
+				return
+			}
+			if message.Fix.Valid {
+				record(*device, message.Fix)
+			}
+		}
+
+		if len(message.Ack) > 0 {
+			if _, err := conn.Write(message.Ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// record saves a fix through the same ingest path as positions reported over HTTP.
+func record(device db.Device, fix protocol.Fix) {
+	err := device.RecordLocationSnapshot(db.LocationSnapshot{
+		TimeStamp: fix.Time.UTC().Format(utils.TimeFormatMilli),
+		Latitude:  fix.Latitude,
+		Longitude: fix.Longitude,
+		Speed:     sql.NullFloat64{Float64: fix.Speed / 3.6, Valid: true},
+		Bearing:   sql.NullFloat64{Float64: fix.Course, Valid: true},
+		Provider:  sql.NullString{String: db.ProviderGPS, Valid: true},
+	})
+	var rejection *db.RejectedSnapshotError
+	if err != nil && !errors.As(err, &rejection) && !errors.Is(err, db.ErrUpgradeRequired) {
+		log.Print(err)
+	}
+}
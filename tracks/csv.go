@@ -0,0 +1,71 @@
+package tracks
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/mcctor/marauders/db"
+)
+
+var csvHeader = []string{
+	"time", "latitude", "longitude", "altitude", "accuracy", "speed", "bearing", "battery", "provider",
+}
+
+// csvEncoder writes a CSV file with a header row and a row for every snapshot. Readings
+// the device did not report are left empty.
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) ContentType() string { return "text/csv" }
+
+func (e *csvEncoder) Extension() string { return "csv" }
+
+func (e *csvEncoder) Begin(name string) error {
+	return e.w.Write(csvHeader)
+}
+
+func (e *csvEncoder) Encode(snapshot db.LocationSnapshot) error {
+	recorded, err := timeOf(snapshot)
+	if err != nil {
+		return err
+	}
+	battery := ""
+	if snapshot.Battery.Valid {
+		battery = strconv.FormatInt(snapshot.Battery.Int64, 10)
+	}
+	err = e.w.Write([]string{
+		recorded,
+		formatFloat(snapshot.Latitude),
+		formatFloat(snapshot.Longitude),
+		formatNullFloat(snapshot.Altitude.Float64, snapshot.Altitude.Valid),
+		formatNullFloat(snapshot.Accuracy.Float64, snapshot.Accuracy.Valid),
+		formatNullFloat(snapshot.Speed.Float64, snapshot.Speed.Valid),
+		formatNullFloat(snapshot.Bearing.Float64, snapshot.Bearing.Valid),
+		battery,
+		snapshot.Provider.String,
+	})
+	if err != nil {
+		return err
+	}
+	// flush each row so that it reaches the client as it is written
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func formatNullFloat(value float64, valid bool) string {
+	if !valid {
+		return ""
+	}
+	return formatFloat(value)
+}
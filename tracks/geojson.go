@@ -0,0 +1,81 @@
+package tracks
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/mcctor/marauders/db"
+)
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// geoJSONEncoder writes a GeoJSON FeatureCollection with a point feature for every snapshot.
+type geoJSONEncoder struct {
+	w       io.Writer
+	written int
+}
+
+func (e *geoJSONEncoder) ContentType() string { return "application/geo+json" }
+
+func (e *geoJSONEncoder) Extension() string { return "geojson" }
+
+func (e *geoJSONEncoder) Begin(name string) error {
+	encodedName, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, `{"type":"FeatureCollection","name":`+string(encodedName)+`,"features":[`+"\n")
+	return err
+}
+
+func (e *geoJSONEncoder) Encode(snapshot db.LocationSnapshot) error {
+	recorded, err := timeOf(snapshot)
+	if err != nil {
+		return err
+	}
+	// GeoJSON orders positions as longitude, latitude and altitude
+	coordinates := []float64{snapshot.Longitude, snapshot.Latitude}
+	if snapshot.Altitude.Valid {
+		coordinates = append(coordinates, snapshot.Altitude.Float64)
+	}
+	properties := map[string]interface{}{"time": recorded}
+	if snapshot.Accuracy.Valid {
+		properties["accuracy"] = snapshot.Accuracy.Float64
+	}
+	if snapshot.Speed.Valid {
+		properties["speed"] = snapshot.Speed.Float64
+	}
+	if snapshot.Bearing.Valid {
+		properties["bearing"] = snapshot.Bearing.Float64
+	}
+	feature, err := json.Marshal(geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONPoint{Type: "Point", Coordinates: coordinates},
+		Properties: properties,
+	})
+	if err != nil {
+		return err
+	}
+	if e.written > 0 {
+		if _, err := io.WriteString(e.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	e.written++
+	_, err = e.w.Write(feature)
+	return err
+}
+
+func (e *geoJSONEncoder) End() error {
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
@@ -0,0 +1,100 @@
+package tracks
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+const gpxHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="marauders" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><name>`
+
+// gpxEncoder writes a GPX 1.1 document holding a single track segment.
+type gpxEncoder struct {
+	w io.Writer
+}
+
+func (e *gpxEncoder) ContentType() string { return "application/gpx+xml" }
+
+func (e *gpxEncoder) Extension() string { return "gpx" }
+
+func (e *gpxEncoder) Begin(name string) error {
+	if _, err := io.WriteString(e.w, gpxHeader); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(e.w, []byte(name)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</name><trkseg>\n")
+	return err
+}
+
+func (e *gpxEncoder) Encode(snapshot db.LocationSnapshot) error {
+	recorded, err := timeOf(snapshot)
+	if err != nil {
+		return err
+	}
+	point := fmt.Sprintf(`<trkpt lat="%s" lon="%s">`, formatFloat(snapshot.Latitude), formatFloat(snapshot.Longitude))
+	if snapshot.Altitude.Valid {
+		point += "<ele>" + formatFloat(snapshot.Altitude.Float64) + "</ele>"
+	}
+	point += "<time>" + recorded + "</time></trkpt>\n"
+	_, err = io.WriteString(e.w, point)
+	return err
+}
+
+func (e *gpxEncoder) End() error {
+	_, err := io.WriteString(e.w, "</trkseg></trk>\n</gpx>\n")
+	return err
+}
+
+type gpxPoint struct {
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+}
+
+// decodeGPX reads the track points of every track in a GPX document. Points without a
+// time are skipped, as they cannot be placed in the history of a device.
+func decodeGPX(r io.Reader, fn func(db.LocationSnapshot) error) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("bad gpx document: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "trkpt" {
+			continue
+		}
+		var point gpxPoint
+		if err := decoder.DecodeElement(&point, &start); err != nil {
+			return fmt.Errorf("bad gpx track point: %v", err)
+		}
+		recorded, err := time.Parse(time.RFC3339Nano, point.Time)
+		if err != nil {
+			continue
+		}
+		snapshot := db.LocationSnapshot{
+			TimeStamp: recorded.UTC().Format(utils.TimeFormatMilli),
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Provider:  sql.NullString{String: db.ProviderGPS, Valid: true},
+		}
+		if point.Elevation != nil {
+			snapshot.Altitude = sql.NullFloat64{Float64: *point.Elevation, Valid: true}
+		}
+		if err := fn(snapshot); err != nil {
+			return err
+		}
+	}
+}
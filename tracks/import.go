@@ -0,0 +1,101 @@
+package tracks
+
+import (
+	"io"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/geo"
+)
+
+// DefaultImportBatchSize is how many snapshots are saved at a time when importing.
+const DefaultImportBatchSize = 500
+
+// ImportReport counts what happened to the snapshots read from an imported track.
+type ImportReport struct {
+	Read       int  `json:"read"`
+	Imported   int  `json:"imported"`
+	Duplicates int  `json:"duplicates"`
+	Invalid    int  `json:"invalid"`
+	DryRun     bool `json:"dry_run"`
+}
+
+// Importer bulk loads tracks recorded elsewhere into the location history of a device.
+type Importer struct {
+	Device db.Device
+	// DryRun reports what an import would do without saving anything, counting snapshots
+	// repeated across batches as duplicates as a real import would
+	DryRun    bool
+	BatchSize int
+	// Progress, if set, is called with the running totals after every batch
+	Progress func(ImportReport)
+}
+
+// Import reads a track in the passed format from r into the device's history, skipping
+// snapshots at time stamps the device already has one for, and those with an impossible
+// or null island position. On error, the report covers the batches saved before it.
+func (im Importer) Import(format string, r io.Reader) (ImportReport, error) {
+	report := ImportReport{DryRun: im.DryRun}
+	batchSize := im.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	batch := make([]db.LocationSnapshot, 0, batchSize)
+	// a dry run saves nothing for later batches to be checked against, so the time stamps
+	// of the earlier ones are remembered instead
+	var planned map[string]bool
+	if im.DryRun {
+		planned = make(map[string]bool)
+	}
+	flush := func() error {
+		if planned != nil {
+			fresh := batch[:0]
+			for _, snapshot := range batch {
+				if planned[snapshot.TimeStamp] {
+					report.Duplicates++
+					continue
+				}
+				fresh = append(fresh, snapshot)
+			}
+			batch = fresh
+		}
+		imported, duplicates, err := im.Device.ImportLocationSnapshots(batch, im.DryRun)
+		if err != nil {
+			return err
+		}
+		if planned != nil {
+			for _, snapshot := range batch {
+				planned[snapshot.TimeStamp] = true
+			}
+		}
+		report.Imported += imported
+		report.Duplicates += duplicates
+		batch = batch[:0]
+		if im.Progress != nil {
+			im.Progress(report)
+		}
+		return nil
+	}
+
+	err := Decode(format, r, func(snapshot db.LocationSnapshot) error {
+		report.Read++
+		point := geo.Point{Latitude: snapshot.Latitude, Longitude: snapshot.Longitude}
+		if !point.IsValid() || (point.Latitude == 0 && point.Longitude == 0) {
+			report.Invalid++
+			return nil
+		}
+		batch = append(batch, snapshot)
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return report, err
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
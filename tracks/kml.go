@@ -0,0 +1,52 @@
+package tracks
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const kmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document><name>`
+
+// kmlEncoder writes a KML document with a time stamped placemark for every snapshot.
+type kmlEncoder struct {
+	w io.Writer
+}
+
+func (e *kmlEncoder) ContentType() string { return "application/vnd.google-earth.kml+xml" }
+
+func (e *kmlEncoder) Extension() string { return "kml" }
+
+func (e *kmlEncoder) Begin(name string) error {
+	if _, err := io.WriteString(e.w, kmlHeader); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(e.w, []byte(name)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</name>\n")
+	return err
+}
+
+func (e *kmlEncoder) Encode(snapshot db.LocationSnapshot) error {
+	recorded, err := timeOf(snapshot)
+	if err != nil {
+		return err
+	}
+	// KML orders coordinates as longitude, latitude and altitude
+	coordinates := formatFloat(snapshot.Longitude) + "," + formatFloat(snapshot.Latitude)
+	if snapshot.Altitude.Valid {
+		coordinates += "," + formatFloat(snapshot.Altitude.Float64)
+	}
+	_, err = io.WriteString(e.w, "<Placemark><TimeStamp><when>"+recorded+"</when></TimeStamp>"+
+		"<Point><coordinates>"+coordinates+"</coordinates></Point></Placemark>\n")
+	return err
+}
+
+func (e *kmlEncoder) End() error {
+	_, err := io.WriteString(e.w, "</Document>\n</kml>\n")
+	return err
+}
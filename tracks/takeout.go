@@ -0,0 +1,105 @@
+package tracks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+// takeoutLocation is an entry of the locations array in the Records.json file of a Google
+// Takeout archive. Older archives give the time in milliseconds since the epoch, newer
+// ones as an RFC 3339 time stamp.
+type takeoutLocation struct {
+	LatitudeE7  *int64   `json:"latitudeE7"`
+	LongitudeE7 *int64   `json:"longitudeE7"`
+	TimestampMs string   `json:"timestampMs"`
+	Timestamp   string   `json:"timestamp"`
+	Accuracy    *float64 `json:"accuracy"`
+	Altitude    *float64 `json:"altitude"`
+	Velocity    *float64 `json:"velocity"`
+	Heading     *float64 `json:"heading"`
+}
+
+// time returns when the location was recorded, or false if the entry has no usable time.
+func (location takeoutLocation) time() (time.Time, bool) {
+	if location.Timestamp != "" {
+		recorded, err := time.Parse(time.RFC3339Nano, location.Timestamp)
+		return recorded, err == nil
+	}
+	millis, err := strconv.ParseInt(location.TimestampMs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)), true
+}
+
+// decodeTakeout reads the locations of a Google Takeout location history one at a time,
+// so that archives spanning years need not fit in memory. Entries missing a position or
+// a time are skipped.
+func decodeTakeout(r io.Reader, fn func(db.LocationSnapshot) error) error {
+	decoder := json.NewDecoder(r)
+	if err := seekTakeoutLocations(decoder); err != nil {
+		return err
+	}
+	for decoder.More() {
+		var location takeoutLocation
+		if err := decoder.Decode(&location); err != nil {
+			return fmt.Errorf("bad takeout location: %v", err)
+		}
+		recorded, ok := location.time()
+		if !ok || location.LatitudeE7 == nil || location.LongitudeE7 == nil {
+			continue
+		}
+		snapshot := db.LocationSnapshot{
+			TimeStamp: recorded.UTC().Format(utils.TimeFormatMilli),
+			Latitude:  float64(*location.LatitudeE7) / 1e7,
+			Longitude: float64(*location.LongitudeE7) / 1e7,
+			Altitude:  nullFloat(location.Altitude),
+			Accuracy:  nullFloat(location.Accuracy),
+			Speed:     nullFloat(location.Velocity),
+			Bearing:   nullFloat(location.Heading),
+		}
+		if err := fn(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seekTakeoutLocations advances the decoder to just inside the top level locations array.
+func seekTakeoutLocations(decoder *json.Decoder) error {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("bad takeout document: expected an object")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("bad takeout document: %v", err)
+		}
+		if token == "locations" {
+			if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+				return fmt.Errorf("bad takeout document: locations should be an array")
+			}
+			return nil
+		}
+		// skip the value of any other key
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return fmt.Errorf("bad takeout document: %v", err)
+		}
+	}
+	return fmt.Errorf("bad takeout document: no locations")
+}
+
+func nullFloat(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *value, Valid: true}
+}
@@ -0,0 +1,76 @@
+// Package tracks writes location histories out in the formats other mapping tools read.
+// Encoders write each snapshot as soon as it is passed to them, so that a history of any
+// length can be streamed without holding it in memory.
+package tracks
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mcctor/marauders/db"
+	"github.com/mcctor/marauders/utils"
+)
+
+const (
+	FormatGPX     = "gpx"
+	FormatKML     = "kml"
+	FormatGeoJSON = "geojson"
+	FormatCSV     = "csv"
+	// FormatTakeout is the location history JSON of a Google Takeout archive, which can
+	// only be imported.
+	FormatTakeout = "takeout"
+)
+
+// Encoder writes a track made up of location snapshots. Begin has to be called before
+// the first snapshot is encoded and End after the last one.
+type Encoder interface {
+	Begin(name string) error
+	Encode(snapshot db.LocationSnapshot) error
+	End() error
+	// ContentType is the media type of the encoded track.
+	ContentType() string
+	// Extension is the file extension conventionally used for the encoded track.
+	Extension() string
+}
+
+// NewEncoder returns an encoder writing to w in the passed format.
+func NewEncoder(format string, w io.Writer) (Encoder, error) {
+	switch format {
+	case FormatGPX:
+		return &gpxEncoder{w: w}, nil
+	case FormatKML:
+		return &kmlEncoder{w: w}, nil
+	case FormatGeoJSON:
+		return &geoJSONEncoder{w: w}, nil
+	case FormatCSV:
+		return newCSVEncoder(w), nil
+	}
+	return nil, fmt.Errorf("unknown track format <%s>", format)
+}
+
+// timeOf returns the time stamp of the snapshot in RFC 3339, which all the formats use.
+func timeOf(snapshot db.LocationSnapshot) (string, error) {
+	recorded, err := time.Parse(utils.TimeFormat, snapshot.TimeStamp)
+	if err != nil {
+		return "", fmt.Errorf("bad time stamp <%s> for device<%d>: %v", snapshot.TimeStamp, snapshot.DeviceID, err)
+	}
+	return recorded.UTC().Format(time.RFC3339Nano), nil
+}
+
+// Decode reads a track in the passed format from r, calling fn with each snapshot read
+// in the order they appear. It stops at the first error returned by fn.
+func Decode(format string, r io.Reader, fn func(db.LocationSnapshot) error) error {
+	switch format {
+	case FormatGPX:
+		return decodeGPX(r, fn)
+	case FormatTakeout:
+		return decodeTakeout(r, fn)
+	}
+	return fmt.Errorf("cannot import track format <%s>", format)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,227 @@
+package tracks
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/mcctor/marauders/db"
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+)
+
+// track is a snapshot with every reading a device may report, followed by one without any.
+var track = []db.LocationSnapshot{
+	{
+		TimeStamp: "2024-03-01 08:00:00",
+		Latitude:  -1.2921,
+		Longitude: 36.8219,
+		Altitude:  sql.NullFloat64{Float64: 1661.5, Valid: true},
+		Accuracy:  sql.NullFloat64{Float64: 5, Valid: true},
+		Speed:     sql.NullFloat64{Float64: 1.5, Valid: true},
+		Bearing:   sql.NullFloat64{Float64: 90, Valid: true},
+		Battery:   sql.NullInt64{Int64: 80, Valid: true},
+		Provider:  sql.NullString{String: db.ProviderGPS, Valid: true},
+	},
+	{TimeStamp: "2024-03-01 08:01:00", Latitude: -1.2925, Longitude: 36.8215},
+}
+
+// encodeTrack writes the track in the format, named after a device.
+func encodeTrack(t *testing.T, format string) string {
+	var encoded bytes.Buffer
+	encoder, err := NewEncoder(format, &encoded)
+	if err != nil {
+		t.Fatal("\t\tShould have an encoder for", format, failMark, err)
+	}
+	if err := encoder.Begin("device <1>"); err != nil {
+		t.Fatal("\t\tShould begin the track:", failMark, err)
+	}
+	for _, snapshot := range track {
+		if err := encoder.Encode(snapshot); err != nil {
+			t.Fatal("\t\tShould encode every snapshot:", failMark, err)
+		}
+	}
+	if err := encoder.End(); err != nil {
+		t.Fatal("\t\tShould end the track:", failMark, err)
+	}
+	return encoded.String()
+}
+
+func TestEncoders(t *testing.T) {
+	golden := map[string]string{
+		FormatGPX: `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="marauders" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><name>device &lt;1&gt;</name><trkseg>
+<trkpt lat="-1.2921" lon="36.8219"><ele>1661.5</ele><time>2024-03-01T08:00:00Z</time></trkpt>
+<trkpt lat="-1.2925" lon="36.8215"><time>2024-03-01T08:01:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>
+`,
+		FormatKML: `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document><name>device &lt;1&gt;</name>
+<Placemark><TimeStamp><when>2024-03-01T08:00:00Z</when></TimeStamp><Point><coordinates>36.8219,-1.2921,1661.5</coordinates></Point></Placemark>
+<Placemark><TimeStamp><when>2024-03-01T08:01:00Z</when></TimeStamp><Point><coordinates>36.8215,-1.2925</coordinates></Point></Placemark>
+</Document>
+</kml>
+`,
+		FormatGeoJSON: `{"type":"FeatureCollection","name":"device \u003c1\u003e","features":[
+{"type":"Feature","geometry":{"type":"Point","coordinates":[36.8219,-1.2921,1661.5]},"properties":{"accuracy":5,"bearing":90,"speed":1.5,"time":"2024-03-01T08:00:00Z"}},
+{"type":"Feature","geometry":{"type":"Point","coordinates":[36.8215,-1.2925]},"properties":{"time":"2024-03-01T08:01:00Z"}}
+]}
+`,
+		FormatCSV: `time,latitude,longitude,altitude,accuracy,speed,bearing,battery,provider
+2024-03-01T08:00:00Z,-1.2921,36.8219,1661.5,5,1.5,90,80,gps
+2024-03-01T08:01:00Z,-1.2925,36.8215,,,,,,
+`,
+	}
+	for _, format := range []string{FormatGPX, FormatKML, FormatGeoJSON, FormatCSV} {
+		t.Log("Given the need to test writing a track as " + format + ".")
+		{
+			if encoded := encodeTrack(t, format); encoded != golden[format] {
+				t.Fatalf("\t\tShould write the track as expected: %s\n%s", failMark, encoded)
+			}
+			t.Log("\t\tShould write the track as expected:", passMark)
+		}
+	}
+
+	t.Log("Given the need to test reading back a track written as gpx.")
+	{
+		var decoded []db.LocationSnapshot
+		err := Decode(FormatGPX, bytes.NewBufferString(encodeTrack(t, FormatGPX)), func(snapshot db.LocationSnapshot) error {
+			decoded = append(decoded, snapshot)
+			return nil
+		})
+		if err != nil || len(decoded) != len(track) {
+			t.Fatal("\t\tShould read back every snapshot:", failMark, err, decoded)
+		}
+		for i, snapshot := range decoded {
+			if snapshot.TimeStamp != track[i].TimeStamp+".000" || snapshot.Latitude != track[i].Latitude ||
+				snapshot.Longitude != track[i].Longitude || snapshot.Altitude != track[i].Altitude {
+				t.Fatal("\t\tShould read back the position and time of every snapshot:", failMark, snapshot)
+			}
+		}
+		t.Log("\t\tShould read back the position and time of every snapshot:", passMark)
+	}
+
+	t.Log("Given the need to test writing a track in an unknown format.")
+	{
+		if _, err := NewEncoder("shp", &bytes.Buffer{}); err == nil {
+			t.Fatal("\t\tShould refuse the format:", failMark)
+		}
+		t.Log("\t\tShould refuse the format:", passMark)
+	}
+}
+
+// decodeAll reads every snapshot of a track in the format.
+func decodeAll(format, document string) ([]db.LocationSnapshot, error) {
+	var decoded []db.LocationSnapshot
+	err := Decode(format, strings.NewReader(document), func(snapshot db.LocationSnapshot) error {
+		decoded = append(decoded, snapshot)
+		return nil
+	})
+	return decoded, err
+}
+
+func TestDecodeGPX(t *testing.T) {
+	// two tracks written by another tool, one of whose points has no time
+	document := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="elsewhere" xmlns="http://www.topografix.com/GPX/1/1">
+<metadata><time>2024-03-01T07:00:00Z</time></metadata>
+<trk><name>morning</name><trkseg>
+<trkpt lat="-1.2921" lon="36.8219"><ele>1661.5</ele><time>2024-03-01T11:00:00.250+03:00</time></trkpt>
+<trkpt lat="-1.2923" lon="36.8217"></trkpt>
+</trkseg></trk>
+<trk><name>evening</name><trkseg>
+<trkpt lat="-1.2925" lon="36.8215"><time>2024-03-01T17:30:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>`
+
+	t.Log("Given the need to test reading the points of a gpx document.")
+	{
+		decoded, err := decodeAll(FormatGPX, document)
+		if err != nil || len(decoded) != 2 {
+			t.Fatal("\t\tShould read the points of every track, skipping those without a time:", failMark, err, decoded)
+		}
+		t.Log("\t\tShould read the points of every track, skipping those without a time:", passMark)
+
+		first := decoded[0]
+		if first.TimeStamp != "2024-03-01 08:00:00.250" || first.Latitude != -1.2921 || first.Longitude != 36.8219 ||
+			first.Altitude.Float64 != 1661.5 || first.Provider.String != db.ProviderGPS {
+			t.Fatal("\t\tShould read the position, elevation and time in UTC of a point:", failMark, first)
+		}
+		if decoded[1].Altitude.Valid {
+			t.Fatal("\t\tShould leave out the elevation of a point without one:", failMark, decoded[1])
+		}
+		t.Log("\t\tShould read the position, elevation and time in UTC of a point:", passMark)
+	}
+
+	t.Log("Given the need to test reading a document that is not gpx.")
+	{
+		if _, err := decodeAll(FormatGPX, `<gpx><trk><trkseg><trkpt lat="x"`); err == nil {
+			t.Fatal("\t\tShould refuse the document:", failMark)
+		}
+		t.Log("\t\tShould refuse the document:", passMark)
+	}
+}
+
+func TestDecodeTakeout(t *testing.T) {
+	// a location history mixing the time stamps of older and newer archives, along with an
+	// entry without a position
+	document := `{
+  "version": 1,
+  "activitySegments": [{"ignored": true}],
+  "locations": [{
+    "latitudeE7": -12921000,
+    "longitudeE7": 368219000,
+    "accuracy": 12,
+    "altitude": 1661,
+    "velocity": 3,
+    "heading": 270,
+    "timestampMs": "1709280000250"
+  }, {
+    "timestamp": "2024-03-01T08:01:00Z",
+    "accuracy": 20
+  }, {
+    "latitudeE7": -12925000,
+    "longitudeE7": 368215000,
+    "timestamp": "2024-03-01T11:02:00+03:00"
+  }]
+}`
+
+	t.Log("Given the need to test reading the locations of a takeout location history.")
+	{
+		decoded, err := decodeAll(FormatTakeout, document)
+		if err != nil || len(decoded) != 2 {
+			t.Fatal("\t\tShould read every location, skipping those without a position:", failMark, err, decoded)
+		}
+		t.Log("\t\tShould read every location, skipping those without a position:", passMark)
+
+		first := decoded[0]
+		if first.TimeStamp != "2024-03-01 08:00:00.250" || first.Latitude != -1.2921 || first.Longitude != 36.8219 ||
+			first.Accuracy.Float64 != 12 || first.Altitude.Float64 != 1661 || first.Speed.Float64 != 3 ||
+			first.Bearing.Float64 != 270 {
+			t.Fatal("\t\tShould read the position, readings and time of a location in milliseconds:", failMark, first)
+		}
+		t.Log("\t\tShould read the position, readings and time of a location in milliseconds:", passMark)
+
+		if decoded[1].TimeStamp != "2024-03-01 08:02:00.000" || decoded[1].Accuracy.Valid {
+			t.Fatal("\t\tShould read the time of a location as a time stamp in UTC:", failMark, decoded[1])
+		}
+		t.Log("\t\tShould read the time of a location as a time stamp in UTC:", passMark)
+	}
+
+	t.Log("Given the need to test reading a document without locations.")
+	{
+		for _, bad := range []string{`[]`, `{"version": 1}`, `{"locations": {}}`} {
+			if _, err := decodeAll(FormatTakeout, bad); err == nil {
+				t.Fatal("\t\tShould refuse the document:", failMark, bad)
+			}
+		}
+		t.Log("\t\tShould refuse the document:", passMark)
+	}
+}
@@ -1,7 +1,11 @@
 package utils
 
 const (
+	// TimeFormat is how time stamps are stored and passed around. Time stamps carry no zone
+	// and are always in UTC.
 	TimeFormat = "2006-01-02 15:04:05"
+	// TimeFormatMilli is TimeFormat with millisecond precision, as used by location snapshots
+	TimeFormatMilli = "2006-01-02 15:04:05.000"
 )
 
 var (